@@ -17,128 +17,134 @@
 package binlog
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/dhbin/ra/binlog/event"
-	"github.com/dhbin/ra/binlog/parse"
+	"github.com/dhbin/ra/binlog/sink"
+	"github.com/dhbin/ra/binlog/sql"
 	"github.com/dhbin/ra/config"
-	"github.com/go-mysql-org/go-mysql/canal"
-	"github.com/go-mysql-org/go-mysql/mysql"
-	"github.com/siddontang/go-log/log"
-	"strconv"
+	"io"
+	"os"
 )
 
-func newCanal(config *config.BinlogConfig) (*canal.Canal, error) {
-	cfg := canal.NewDefaultConfig()
-	cfg.Addr = config.Host + ":" + strconv.Itoa(config.Port)
-	cfg.User = config.Username
-	cfg.Password = config.Password
-	cfg.Logger = log.NewDefault(&event.DiscardLogHandler{})
+// ErrInterrupted 解析被ctx取消，最后处理完成的位置已输出到stderr
+var ErrInterrupted = errors.New("已中断")
 
-	cfg.Dump.ExecutionPath = ""
-
-	// 数据库过滤配置
-	if config.Database != "" {
-		if len(config.Tables) == 0 {
-			cfg.IncludeTableRegex = []string{config.Database + "\\..*"}
-		} else {
-			includeTableRegex := make([]string, len(config.Tables))
-			for i, table := range config.Tables {
-				includeTableRegex[i] = config.Database + "\\." + table + "$"
-			}
-			cfg.IncludeTableRegex = includeTableRegex
+// newSink 根据输出格式创建Sink，单文件格式输出到out，flashback为true时倒序输出
+func newSink(config *config.BinlogConfig, out io.Writer, flashback bool) (event.Sink, error) {
+	masker, err := sql.NewMasker(config.MaskRules, config.MaskSalt)
+	if err != nil {
+		return nil, err
+	}
+	options := &sink.Options{
+		Out:          out,
+		OutDir:       config.OutDir,
+		RowGroupSize: config.RowGroupSize,
+		Masker:       masker,
+		OnError:      config.OnError,
+	}
+	var s event.Sink
+	if config.ApplyTo != "" {
+		if config.DDL {
+			return nil, fmt.Errorf("apply-to不能与ddl同时使用，ddl需要手动执行")
 		}
+		s, err = sink.NewApplySink(options, &sink.ApplyOptions{
+			DSN:          config.ApplyTo,
+			DryRun:       config.DryRun,
+			Batch:        config.ApplyBatch,
+			Check:        config.ApplyCheck,
+			ProgressFile: config.ProgressFile,
+			Reverse:      flashback,
+		})
 	} else {
-		if len(config.Tables) != 0 {
-			includeTableRegex := make([]string, len(config.Tables))
-			for i, table := range config.Tables {
-				includeTableRegex[i] = ".*\\." + table + "$"
-			}
-			cfg.IncludeTableRegex = includeTableRegex
-		}
+		s, err = sink.New(config.Format, options)
 	}
-
-	c, err := canal.NewCanal(cfg)
-	return c, err
+	if err != nil || !flashback {
+		return s, err
+	}
+	reverse, err := sink.NewReverseSink(s)
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return reverse, nil
 }
 
-func ToSql(config *config.BinlogConfig) error {
-	c, err := newCanal(config)
+// newVerifySink 闪回前校验数据是否在之后被再次修改
+func newVerifySink(config *config.BinlogConfig, s event.Sink) (event.Sink, error) {
+	verifyDSN, err := dsn(config)
 	if err != nil {
-		return err
+		_ = s.Close()
+		return nil, err
 	}
-
-	done := make(chan interface{})
-	handler := event.ToSqlHandler{}
-	handler.Config = config
-	handler.Done = done
-	out, err := config.GetOut()
+	verifySink, err := sink.NewVerifySink(s, &sink.Options{}, &sink.VerifyOptions{
+		DSN:         verifyDSN,
+		SkipDrifted: config.SkipDrifted,
+	})
 	if err != nil {
-		return err
+		_ = s.Close()
+		return nil, err
 	}
-	handler.Out = out
-
-	go func() {
-		if config.Local {
-			parser := parse.NewLocalFileParser(config)
-			err := parser.Run(&handler)
-			done <- err
-		} else {
-			// Start canal
-			c.SetEventHandler(&handler)
-			err = c.RunFrom(mysql.Position{Name: config.StartBinlogName, Pos: config.StartPosition})
-			if err != nil {
-				done <- err
-			}
-		}
-	}()
+	return verifySink, nil
+}
 
-	switch i := (<-done).(type) {
-	case error:
-		fmt.Println(i.Error())
-	default:
+// ToSql 通过binlog生成sql，ctx取消时丢弃未完成的事务并输出最后处理完成的位置
+func ToSql(ctx context.Context, config *config.BinlogConfig) error {
+	if config.Follow {
+		return follow(ctx, config)
 	}
-	c.Close()
-	return nil
+	return run(ctx, config, false)
 }
 
-func Flashback(config *config.BinlogConfig) error {
-	c, err := newCanal(config)
+// Flashback 通过binlog生成恢复数据的sql，ctx取消时丢弃未完成的事务并输出最后处理完成的位置
+func Flashback(ctx context.Context, config *config.BinlogConfig) error {
+	return run(ctx, config, true)
+}
+
+func run(ctx context.Context, config *config.BinlogConfig, flashback bool) error {
+	out, err := config.GetOut()
 	if err != nil {
 		return err
 	}
-
-	done := make(chan interface{})
-	handler := event.FlashbackHandler{}
-	handler.Config = config
-	handler.Done = done
-
-	out, err := config.GetOut()
+	s, err := newSink(config, out, flashback)
 	if err != nil {
+		_ = out.Close()
 		return err
 	}
-	handler.Out = out
-
-	go func() {
-
-		if config.Local {
-			parser := parse.NewLocalFileParser(config)
-			err := parser.Run(&handler)
-			done <- err
-		} else {
-			c.SetEventHandler(&handler)
-			// Start canal
-			err = c.RunFrom(mysql.Position{Name: config.StartBinlogName, Pos: config.StartPosition})
-			if err != nil {
-				done <- err
-			}
+	// 恢复数据、校验需要按binlog顺序处理，在倒序之前
+	if flashback && config.RecoverDropped {
+		s = sink.NewRecoverSink(s, &sink.Options{})
+	}
+	if flashback && config.Verify {
+		s, err = newVerifySink(config, s)
+		if err != nil {
+			_ = out.Close()
+			return err
 		}
-	}()
+	}
+	return runReader(ctx, newReader(config, flashback), s, out)
+}
 
-	switch i := (<-done).(type) {
-	case error:
-		fmt.Println(i.Error())
-	default:
+// runReader 解析结束后关闭输出文件，被中断时输出最后处理完成的位置，便于继续解析，并返回ErrInterrupted
+func runReader(ctx context.Context, reader *Reader, s event.Sink, out io.Closer) error {
+	err := reader.Run(ctx, s)
+	if out != nil {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if ctx.Err() == nil {
+		return err
+	}
+	pos := reader.Position()
+	if pos.File == "" {
+		_, _ = fmt.Fprintln(os.Stderr, "已中断，还没有处理完成任何事务")
+	} else {
+		_, _ = fmt.Fprintf(os.Stderr, "已中断，最后处理完成的位置 %s:%d，可以通过--start-file %s --start-position %d继续\n", pos.File, pos.Pos, pos.File, pos.Pos)
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrInterrupted
 	}
-	c.Close()
-	return nil
+	return err
 }
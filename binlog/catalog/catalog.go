@@ -0,0 +1,172 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package catalog 获取行事件对应的表结构，本地解析和远程解析共用
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dhbin/ra/binlog/ddl"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"strings"
+)
+
+// Provider 获取表结构
+type Provider interface {
+	// Table 获取表结构，找不到时返回*NotFoundError
+	Table(db string, table string) (*schema.Table, error)
+}
+
+// Observer 需要跟踪binlog的Provider，解析时依次传入table map事件和ddl
+type Observer interface {
+	OnTableMap(e *replication.TableMapEvent)
+	OnDDL(db string, query string)
+}
+
+// Closer 需要释放资源的Provider
+type Closer interface {
+	Close() error
+}
+
+// NotFoundError 找不到表结构，Reasons为每个Provider找不到的原因
+type NotFoundError struct {
+	Schema  string
+	Table   string
+	Reasons []string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("找不到表%s.%s的表结构: %s", e.Schema, e.Table, strings.Join(e.Reasons, "，"))
+}
+
+func notFound(db string, table string, reason string) *NotFoundError {
+	return &NotFoundError{Schema: db, Table: table, Reasons: []string{reason}}
+}
+
+// Chain 依次从多个Provider获取表结构，前面的优先
+type Chain []Provider
+
+func (c Chain) Table(db string, table string) (*schema.Table, error) {
+	missing := &NotFoundError{Schema: db, Table: table}
+	for _, p := range c {
+		t, err := p.Table(db, table)
+		if err == nil {
+			return t, nil
+		}
+		var notFound *NotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, err
+		}
+		missing.Reasons = append(missing.Reasons, notFound.Reasons...)
+	}
+	return nil, missing
+}
+
+func (c Chain) OnTableMap(e *replication.TableMapEvent) {
+	for _, p := range c {
+		if o, ok := p.(Observer); ok {
+			o.OnTableMap(e)
+		}
+	}
+}
+
+func (c Chain) OnDDL(db string, query string) {
+	for _, p := range c {
+		if o, ok := p.(Observer); ok {
+			o.OnDDL(db, query)
+		}
+	}
+}
+
+func (c Chain) Close() error {
+	var err error
+	for _, p := range c {
+		if closer, ok := p.(Closer); ok {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+// Cache 缓存表结构以及找不到表结构的结果，ddl修改表结构后重新获取。
+// 每个table map事件都可能不同，Metadata不需要缓存
+type Cache struct {
+	Provider
+	tables map[string]*cached
+}
+
+type cached struct {
+	table *schema.Table
+	err   error
+}
+
+// NewCache 创建Cache
+func NewCache(p Provider) *Cache {
+	return &Cache{Provider: p, tables: make(map[string]*cached)}
+}
+
+func (c *Cache) Table(db string, table string) (*schema.Table, error) {
+	key := db + "." + table
+	if t, ok := c.tables[key]; ok {
+		return t.table, t.err
+	}
+	t, err := c.Provider.Table(db, table)
+	var notFound *NotFoundError
+	if err == nil || errors.As(err, &notFound) {
+		c.tables[key] = &cached{table: t, err: err}
+	}
+	return t, err
+}
+
+func (c *Cache) OnTableMap(e *replication.TableMapEvent) {
+	if o, ok := c.Provider.(Observer); ok {
+		o.OnTableMap(e)
+	}
+}
+
+// OnDDL 删除ddl涉及的表的缓存，无法解析时清空缓存
+func (c *Cache) OnDDL(db string, query string) {
+	if o, ok := c.Provider.(Observer); ok {
+		o.OnDDL(db, query)
+	}
+	tables, err := ddl.ChangedTables(query, db)
+	if err != nil {
+		c.tables = make(map[string]*cached)
+		return
+	}
+	for _, t := range tables {
+		if t.Name != "" {
+			delete(c.tables, t.Schema+"."+t.Name)
+			continue
+		}
+		for key := range c.tables {
+			if strings.HasPrefix(key, t.Schema+".") {
+				delete(c.tables, key)
+			}
+		}
+	}
+}
+
+func (c *Cache) Close() error {
+	if closer, ok := c.Provider.(Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package catalog
+
+import (
+	"errors"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"reflect"
+	"testing"
+)
+
+// fakeProvider 记录获取表结构的次数和收到的ddl
+type fakeProvider struct {
+	tables   map[string]*schema.Table
+	err      error
+	calls    map[string]int
+	ddl      []string
+	closeErr error
+	closed   bool
+}
+
+func newFakeProvider(tables ...string) *fakeProvider {
+	p := &fakeProvider{tables: make(map[string]*schema.Table), calls: make(map[string]int)}
+	for _, t := range tables {
+		p.tables[t] = &schema.Table{Name: t}
+	}
+	return p
+}
+
+func (p *fakeProvider) Table(db string, table string) (*schema.Table, error) {
+	key := db + "." + table
+	p.calls[key]++
+	if p.err != nil {
+		return nil, p.err
+	}
+	if t, ok := p.tables[key]; ok {
+		return t, nil
+	}
+	return nil, notFound(db, table, "fake中没有"+key)
+}
+
+func (p *fakeProvider) OnTableMap(*replication.TableMapEvent) {}
+
+func (p *fakeProvider) OnDDL(db string, query string) {
+	p.ddl = append(p.ddl, db+":"+query)
+}
+
+func (p *fakeProvider) Close() error {
+	p.closed = true
+	return p.closeErr
+}
+
+// tableOnly 只实现Provider
+type tableOnly struct {
+	Provider
+}
+
+func TestChain(t *testing.T) {
+	first := newFakeProvider("test.a")
+	second := newFakeProvider("test.a", "test.b")
+	chain := Chain{first, tableOnly{second}}
+
+	if got, err := chain.Table("test", "a"); err != nil || got != first.tables["test.a"] {
+		t.Errorf("Table(a) = %v, %v, 应使用前面的Provider", got, err)
+	}
+	if got, err := chain.Table("test", "b"); err != nil || got != second.tables["test.b"] {
+		t.Errorf("Table(b) = %v, %v, 应使用后面的Provider", got, err)
+	}
+
+	_, err := chain.Table("test", "c")
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("Table(c) error = %v, want *NotFoundError", err)
+	}
+	if want := []string{"fake中没有test.c", "fake中没有test.c"}; !reflect.DeepEqual(notFound.Reasons, want) {
+		t.Errorf("Reasons = %v, want %v", notFound.Reasons, want)
+	}
+
+	// 不是找不到表结构的错误直接返回
+	first.err = errors.New("连接失败")
+	if _, err := chain.Table("test", "b"); err != first.err {
+		t.Errorf("Table(b) error = %v, want %v", err, first.err)
+	}
+	if second.calls["test.b"] != 1 {
+		t.Errorf("出错后不应从后面的Provider获取")
+	}
+
+	chain.OnDDL("test", "drop table a")
+	if !reflect.DeepEqual(first.ddl, []string{"test:drop table a"}) || second.ddl != nil {
+		t.Errorf("OnDDL只传给实现Observer的Provider: %v %v", first.ddl, second.ddl)
+	}
+
+	first.closeErr = errors.New("first")
+	third := newFakeProvider()
+	third.closeErr = errors.New("third")
+	if err := append(chain, third).Close(); err != first.closeErr || !third.closed {
+		t.Errorf("Close() = %v, 应关闭所有Provider并返回第一个错误", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	p := newFakeProvider("test.a", "test.b", "other.a")
+	cache := NewCache(p)
+	get := func(db string, table string) {
+		t.Helper()
+		if _, err := cache.Table(db, table); err != nil && !isNotFound(err) {
+			t.Fatal(err)
+		}
+	}
+	getAll := func() {
+		get("test", "a")
+		get("test", "b")
+		get("other", "a")
+		get("test", "c")
+	}
+	tests := []struct {
+		name  string
+		db    string
+		query string
+		want  map[string]int
+	}{
+		{name: "没有ddl时使用缓存，包括找不到的表", want: map[string]int{}},
+		{name: "修改表结构", db: "test", query: "alter table a add column c int", want: map[string]int{"test.a": 1}},
+		{name: "指定库名", db: "other", query: "alter table test.b drop column c", want: map[string]int{"test.b": 1}},
+		{name: "创建表", db: "test", query: "create table c (id int)", want: map[string]int{"test.c": 1}},
+		{name: "重命名表", db: "test", query: "rename table a to c", want: map[string]int{"test.a": 1, "test.c": 1}},
+		{name: "删除库", db: "test", query: "drop database test", want: map[string]int{"test.a": 1, "test.b": 1, "test.c": 1}},
+		{name: "不修改表结构的语句", db: "test", query: "create user ra", want: map[string]int{}},
+		{name: "无法解析时清空缓存", db: "test", query: "alter tabel a", want: map[string]int{"test.a": 1, "test.b": 1, "other.a": 1, "test.c": 1}},
+	}
+	getAll()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.calls = make(map[string]int)
+			if tt.query != "" {
+				cache.OnDDL(tt.db, tt.query)
+			}
+			getAll()
+			if !reflect.DeepEqual(p.calls, tt.want) {
+				t.Errorf("重新获取的表 = %v, want %v", p.calls, tt.want)
+			}
+		})
+	}
+	if len(p.ddl) != len(tests)-1 {
+		t.Errorf("ddl应传给Provider: %v", p.ddl)
+	}
+
+	// 其它错误不缓存
+	p.err = errors.New("连接失败")
+	p.calls = make(map[string]int)
+	cache.OnDDL("test", "drop table a")
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Table("test", "a"); err != p.err {
+			t.Errorf("Table() error = %v, want %v", err, p.err)
+		}
+	}
+	if p.calls["test.a"] != 2 {
+		t.Errorf("出错时不应缓存，获取次数 = %d", p.calls["test.a"])
+	}
+}
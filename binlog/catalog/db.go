@@ -0,0 +1,83 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package catalog
+
+import (
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"github.com/pingcap/errors"
+)
+
+// DB 从数据库获取当前的表结构，第一次使用时连接数据库，连接断开时重新连接一次。
+// 解析范围之后修改过的表，当前表结构与binlog不一致
+type DB struct {
+	connect func() (*client.Conn, error)
+	conn    *client.Conn
+}
+
+// NewDB 创建DB，connect用于连接数据库
+func NewDB(connect func() (*client.Conn, error)) *DB {
+	return &DB{connect: connect}
+}
+
+func (d *DB) Table(db string, table string) (*schema.Table, error) {
+	t, err := d.table(db, table)
+	if err != nil && d.conn != nil && !tableNotExist(err) {
+		// 长时间解析本地文件时连接可能已经断开
+		_ = d.conn.Close()
+		d.conn = nil
+		t, err = d.table(db, table)
+	}
+	if tableNotExist(err) {
+		return nil, notFound(db, table, "数据库中不存在该表")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取表%s.%s的表结构失败: %w", db, table, err)
+	}
+	return t, nil
+}
+
+func (d *DB) table(db string, table string) (*schema.Table, error) {
+	if d.conn == nil {
+		conn, err := d.connect()
+		if err != nil {
+			return nil, fmt.Errorf("连接数据库失败: %w", err)
+		}
+		d.conn = conn
+	}
+	return schema.NewTable(d.conn, db, table)
+}
+
+// tableNotExist 库或表不存在
+func tableNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	myErr, ok := errors.Cause(err).(*mysql.MyError)
+	return ok && (myErr.Code == mysql.ER_NO_SUCH_TABLE || myErr.Code == mysql.ER_BAD_DB_ERROR)
+}
+
+func (d *DB) Close() error {
+	if d.conn == nil {
+		return nil
+	}
+	err := d.conn.Close()
+	d.conn = nil
+	return err
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package catalog
+
+import (
+	"fmt"
+	"github.com/dhbin/ra/binlog/ddl"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"os"
+)
+
+// File 从表结构文件获取表结构，如mysqldump --no-data的输出、pitr的快照文件。
+// 文件中为解析范围开始时的表结构，解析过程中跟踪ddl对表结构的修改
+type File struct {
+	tracker *ddl.Tracker
+}
+
+// NewFile 加载表结构文件，没有USE语句时库名为database，后面的文件覆盖前面的文件中的同名表
+func NewFile(database string, files ...string) (*File, error) {
+	tracker := ddl.NewTracker()
+	for _, file := range files {
+		if err := loadFile(tracker, file, database); err != nil {
+			return nil, err
+		}
+	}
+	return &File{tracker: tracker}, nil
+}
+
+func loadFile(tracker *ddl.Tracker, file string, database string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := tracker.Load(f, database); err != nil {
+		return fmt.Errorf("加载表结构文件%s失败: %w", file, err)
+	}
+	return nil
+}
+
+func (f *File) Table(db string, table string) (*schema.Table, error) {
+	t, err := f.tracker.Table(db, table)
+	if err != nil {
+		return nil, notFound(db, table, "表结构文件中"+err.Error())
+	}
+	return t, nil
+}
+
+func (f *File) OnTableMap(*replication.TableMapEvent) {}
+
+func (f *File) OnDDL(db string, query string) {
+	f.tracker.Apply(query, db)
+}
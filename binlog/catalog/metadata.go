@@ -0,0 +1,187 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package catalog
+
+import (
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"github.com/pingcap/tidb/parser/charset"
+	"strings"
+)
+
+// binaryCollation binary字符集的collation id
+const binaryCollation = 63
+
+// Metadata 从table map事件的元数据获取表结构，需要binlog_row_metadata=FULL（mysql 8.0.1以上）。
+// 表结构与行事件一致，不受解析范围之后的ddl影响
+type Metadata struct {
+	tables map[string]*metadataTable
+}
+
+type metadataTable struct {
+	event *replication.TableMapEvent
+	table *schema.Table
+}
+
+// NewMetadata 创建Metadata
+func NewMetadata() *Metadata {
+	return &Metadata{tables: make(map[string]*metadataTable)}
+}
+
+func (m *Metadata) OnTableMap(e *replication.TableMapEvent) {
+	key := string(e.Schema) + "." + string(e.Table)
+	if t, ok := m.tables[key]; ok && t.event == e {
+		return
+	}
+	m.tables[key] = &metadataTable{event: e}
+}
+
+func (m *Metadata) OnDDL(string, string) {}
+
+func (m *Metadata) Table(db string, table string) (*schema.Table, error) {
+	t, ok := m.tables[db+"."+table]
+	if !ok {
+		return nil, notFound(db, table, "binlog中没有table map事件")
+	}
+	if t.table == nil {
+		if len(t.event.ColumnName) == 0 {
+			return nil, notFound(db, table, "binlog中没有字段名，需要binlog_row_metadata=FULL")
+		}
+		t.table = metadataSchema(db, table, t.event)
+	}
+	return t.table, nil
+}
+
+// metadataSchema 根据字段类型和元数据还原information_schema中的字段类型
+func metadataSchema(db string, name string, e *replication.TableMapEvent) *schema.Table {
+	table := &schema.Table{Schema: db, Name: name}
+	names := e.ColumnNameString()
+	unsigned := e.UnsignedMap()
+	collations := e.CollationMap()
+	enums := e.EnumStrValueMap()
+	sets := e.SetStrValueMap()
+	for i := 0; i < int(e.ColumnCount); i++ {
+		collation := ""
+		// 元数据中char、varchar的长度为字节数
+		maxLen := 1
+		if id, ok := collations[i]; ok {
+			if c, err := charset.GetCollationByID(int(id)); err == nil {
+				collation = c.Name
+				if cs, _ := charset.GetCharsetInfo(c.CharsetName); cs != nil && cs.Maxlen > 0 {
+					maxLen = cs.Maxlen
+				}
+			}
+		}
+		typ := columnType(e.ColumnType[i], e.ColumnMeta[i], collations[i] == binaryCollation, maxLen, enums[i], sets[i])
+		if unsigned[i] {
+			typ += " unsigned"
+		}
+		table.AddColumn(names[i], typ, collation, "")
+		// AddColumn按逗号拆分，值中有逗号、引号时不准确
+		if enum, ok := enums[i]; ok {
+			table.Columns[i].EnumValues = enum
+		}
+		if set, ok := sets[i]; ok {
+			table.Columns[i].SetValues = set
+		}
+	}
+	if len(e.PrimaryKey) > 0 {
+		index := table.AddIndex("PRIMARY")
+		for _, i := range e.PrimaryKey {
+			index.AddColumn(names[i], 0)
+			table.PKColumns = append(table.PKColumns, int(i))
+		}
+	}
+	return table
+}
+
+// columnType maxLen为字符集中每个字符的最大字节数，用于将char、varchar的字节数转换为字符数
+func columnType(typ byte, meta uint16, binary bool, maxLen int, enum []string, set []string) string {
+	switch typ {
+	case mysql.MYSQL_TYPE_TINY:
+		return "tinyint"
+	case mysql.MYSQL_TYPE_SHORT:
+		return "smallint"
+	case mysql.MYSQL_TYPE_INT24:
+		return "mediumint"
+	case mysql.MYSQL_TYPE_LONG:
+		return "int"
+	case mysql.MYSQL_TYPE_LONGLONG:
+		return "bigint"
+	case mysql.MYSQL_TYPE_FLOAT:
+		return "float"
+	case mysql.MYSQL_TYPE_DOUBLE:
+		return "double"
+	case mysql.MYSQL_TYPE_NEWDECIMAL:
+		return fmt.Sprintf("decimal(%d,%d)", meta>>8, meta&0xff)
+	case mysql.MYSQL_TYPE_YEAR:
+		return "year"
+	case mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE:
+		return "date"
+	case mysql.MYSQL_TYPE_TIME, mysql.MYSQL_TYPE_TIME2:
+		return "time"
+	case mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_DATETIME2:
+		return "datetime"
+	case mysql.MYSQL_TYPE_TIMESTAMP, mysql.MYSQL_TYPE_TIMESTAMP2:
+		return "timestamp"
+	case mysql.MYSQL_TYPE_BIT:
+		return fmt.Sprintf("bit(%d)", (meta>>8)*8+meta&0xff)
+	case mysql.MYSQL_TYPE_JSON:
+		return "json"
+	case mysql.MYSQL_TYPE_GEOMETRY:
+		return "geometry"
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING:
+		if binary {
+			return fmt.Sprintf("varbinary(%d)", meta)
+		}
+		return fmt.Sprintf("varchar(%d)", int(meta)/maxLen)
+	case mysql.MYSQL_TYPE_BLOB:
+		names := []string{"tinyblob", "blob", "mediumblob", "longblob"}
+		if !binary {
+			names = []string{"tinytext", "text", "mediumtext", "longtext"}
+		}
+		if meta >= 1 && meta <= 4 {
+			return names[meta-1]
+		}
+		return names[1]
+	case mysql.MYSQL_TYPE_STRING:
+		realType := byte(meta >> 8)
+		switch realType {
+		case mysql.MYSQL_TYPE_ENUM:
+			return "enum(" + quoteValues(enum) + ")"
+		case mysql.MYSQL_TYPE_SET:
+			return "set(" + quoteValues(set) + ")"
+		}
+		// 长度超过255时高位保存在real type中
+		length := int(((meta>>4)&0x300)^0x300) + int(meta&0xff)
+		if binary {
+			return fmt.Sprintf("binary(%d)", length)
+		}
+		return fmt.Sprintf("char(%d)", length/maxLen)
+	}
+	return "unknown"
+}
+
+func quoteValues(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return strings.Join(quoted, ",")
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package catalog
+
+import (
+	"errors"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"testing"
+)
+
+func TestColumnType(t *testing.T) {
+	tests := []struct {
+		name   string
+		typ    byte
+		meta   uint16
+		binary bool
+		maxLen int
+		enum   []string
+		set    []string
+		want   string
+	}{
+		{name: "int", typ: mysql.MYSQL_TYPE_LONG, want: "int"},
+		{name: "decimal", typ: mysql.MYSQL_TYPE_NEWDECIMAL, meta: 10<<8 | 2, want: "decimal(10,2)"},
+		{name: "bit", typ: mysql.MYSQL_TYPE_BIT, meta: 1<<8 | 3, want: "bit(11)"},
+		{name: "datetime2", typ: mysql.MYSQL_TYPE_DATETIME2, meta: 3, want: "datetime"},
+		{name: "varchar按字符集转换为字符数", typ: mysql.MYSQL_TYPE_VARCHAR, meta: 40, maxLen: 4, want: "varchar(10)"},
+		{name: "varbinary", typ: mysql.MYSQL_TYPE_VARCHAR, meta: 16, binary: true, maxLen: 1, want: "varbinary(16)"},
+		{name: "text", typ: mysql.MYSQL_TYPE_BLOB, meta: 2, maxLen: 3, want: "text"},
+		{name: "longblob", typ: mysql.MYSQL_TYPE_BLOB, meta: 4, binary: true, maxLen: 1, want: "longblob"},
+		{name: "char", typ: mysql.MYSQL_TYPE_STRING, meta: uint16(mysql.MYSQL_TYPE_STRING)<<8 | 30, maxLen: 3, want: "char(10)"},
+		{name: "长度超过255的char", typ: mysql.MYSQL_TYPE_STRING, meta: uint16(mysql.MYSQL_TYPE_STRING^0x10)<<8 | 0xfc, maxLen: 4, want: "char(127)"},
+		{name: "binary", typ: mysql.MYSQL_TYPE_STRING, meta: uint16(mysql.MYSQL_TYPE_STRING)<<8 | 8, binary: true, maxLen: 1, want: "binary(8)"},
+		{name: "enum", typ: mysql.MYSQL_TYPE_STRING, meta: uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, enum: []string{"a", "b'c"}, want: "enum('a','b''c')"},
+		{name: "set", typ: mysql.MYSQL_TYPE_STRING, meta: uint16(mysql.MYSQL_TYPE_SET)<<8 | 1, set: []string{"x", "y"}, want: "set('x','y')"},
+		{name: "未知类型", typ: mysql.MYSQL_TYPE_NULL, want: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := columnType(tt.typ, tt.meta, tt.binary, tt.maxLen, tt.enum, tt.set); got != tt.want {
+				t.Errorf("columnType() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// metadataEvent id bigint unsigned主键，name varchar(10) utf8mb4，data varbinary(16)，status enum('a','b')
+func metadataEvent() *replication.TableMapEvent {
+	return &replication.TableMapEvent{
+		Schema:           []byte("test"),
+		Table:            []byte("user"),
+		ColumnCount:      4,
+		ColumnType:       []byte{mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_STRING},
+		ColumnMeta:       []uint16{0, 40, 16, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1},
+		ColumnName:       [][]byte{[]byte("id"), []byte("name"), []byte("data"), []byte("status")},
+		SignednessBitmap: []byte{0x80},
+		DefaultCharset:   []uint64{45, 1, 63},
+		EnumStrValue:     [][][]byte{{[]byte("a"), []byte("b")}},
+		PrimaryKey:       []uint64{0},
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	m := NewMetadata()
+	if _, err := m.Table("test", "user"); !isNotFound(err) {
+		t.Fatalf("没有table map事件时Table() error = %v", err)
+	}
+	m.OnTableMap(metadataEvent())
+	table, err := m.Table("test", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name      string
+		rawType   string
+		typ       int
+		collation string
+		unsigned  bool
+	}{
+		{name: "id", rawType: "bigint unsigned", typ: schema.TYPE_NUMBER, unsigned: true},
+		{name: "name", rawType: "varchar(10)", typ: schema.TYPE_STRING, collation: "utf8mb4_general_ci"},
+		{name: "data", rawType: "varbinary(16)", typ: schema.TYPE_BINARY, collation: "binary"},
+		{name: "status", rawType: "enum('a','b')", typ: schema.TYPE_ENUM},
+	}
+	if len(table.Columns) != len(want) {
+		t.Fatalf("len(Columns) = %d, want %d", len(table.Columns), len(want))
+	}
+	for i, w := range want {
+		c := table.Columns[i]
+		if c.Name != w.name || c.RawType != w.rawType || c.Type != w.typ || c.Collation != w.collation || c.IsUnsigned != w.unsigned {
+			t.Errorf("Columns[%d] = %s %s %d %s %v, want %+v", i, c.Name, c.RawType, c.Type, c.Collation, c.IsUnsigned, w)
+		}
+	}
+	if len(table.PKColumns) != 1 || table.PKColumns[0] != 0 {
+		t.Errorf("PKColumns = %v, want [0]", table.PKColumns)
+	}
+	if again, _ := m.Table("test", "user"); again != table {
+		t.Error("同一个table map事件应返回同一个表结构")
+	}
+
+	// 新的table map事件中没有字段名
+	e := metadataEvent()
+	e.ColumnName = nil
+	m.OnTableMap(e)
+	if _, err := m.Table("test", "user"); !isNotFound(err) {
+		t.Errorf("没有字段名时Table() error = %v", err)
+	}
+}
+
+func isNotFound(err error) bool {
+	var notFound *NotFoundError
+	return errors.As(err, &notFound)
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-sql-driver/mysql"
+	"strings"
+)
+
+// connect 连接数据库，执行查询用
+func connect(config *config.BinlogConfig) (*client.Conn, error) {
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.Connect(config.Addr(), config.Username, config.Password, "", func(c *client.Conn) {
+		c.SetTLSConfig(tlsConfig)
+	})
+}
+
+// dsn go-sql-driver使用的连接串，使用tls时注册tls配置。
+// tls配置在go-sql-driver中全局注册，按ssl参数生成配置名，不同参数的连接不会互相覆盖
+func dsn(config *config.BinlogConfig) (string, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = config.Username
+	cfg.Passwd = config.Password
+	cfg.Net = config.Network()
+	cfg.Addr = config.Addr()
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return "", err
+	}
+	if tlsConfig != nil {
+		name := tlsConfigName(config)
+		if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+			return "", err
+		}
+		cfg.TLSConfig = name
+	}
+	return cfg.FormatDSN(), nil
+}
+
+// tlsConfigName 由影响tls配置的参数生成注册名
+func tlsConfigName(config *config.BinlogConfig) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{config.SSLMode, config.SSLCA, config.SSLCert, config.SSLKey, config.Host}, "\x00")))
+	return "ra-" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"github.com/dhbin/ra/config"
+	"github.com/go-sql-driver/mysql"
+	"testing"
+)
+
+func TestDsnTLSConfig(t *testing.T) {
+	a := &config.BinlogConfig{Host: "a.internal", Port: 3306, SSLMode: config.SSLRequired}
+	b := &config.BinlogConfig{Host: "b.internal", Port: 3306, SSLMode: config.SSLRequired}
+	if tlsConfigName(a) == tlsConfigName(b) {
+		t.Errorf("不同的ssl参数应使用不同的tls配置名: %s", tlsConfigName(a))
+	}
+	if tlsConfigName(a) != tlsConfigName(&config.BinlogConfig{Host: "a.internal", Port: 3307, SSLMode: config.SSLRequired}) {
+		t.Error("ssl参数相同时tls配置名应相同")
+	}
+	for _, c := range []*config.BinlogConfig{a, b, {Host: "c.internal", Port: 3306}} {
+		s, err := dsn(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := mysql.ParseDSN(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if c.SSLMode != "" {
+			want = tlsConfigName(c)
+		}
+		if cfg.TLSConfig != want {
+			t.Errorf("%s: tls = %q, want %q", c.Host, cfg.TLSConfig, want)
+		}
+	}
+}
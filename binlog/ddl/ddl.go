@@ -0,0 +1,142 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ddl
+
+import (
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	"strings"
+	// 解析带默认值等字面量的ddl需要注册driver
+	_ "github.com/pingcap/tidb/parser/test_driver"
+)
+
+// Table 语句涉及的表
+type Table struct {
+	Schema string
+	Name   string
+}
+
+func (t Table) String() string {
+	return t.Schema + "." + t.Name
+}
+
+// Parse 解析query中的所有语句
+func Parse(query string) ([]ast.StmtNode, error) {
+	stmts, _, err := parser.New().Parse(query, "", "")
+	return stmts, err
+}
+
+// transactionPrefixes 事务中的控制语句，ROLLBACK TO回滚到savepoint，XA包括START、END、PREPARE、COMMIT、ROLLBACK
+var transactionPrefixes = []string{"SAVEPOINT ", "ROLLBACK TO ", "ROLLBACK WORK TO ", "RELEASE SAVEPOINT ", "XA "}
+
+// IsTransactionControl BEGIN、COMMIT、ROLLBACK、SAVEPOINT、XA等事务控制语句，不是ddl
+func IsTransactionControl(query string) bool {
+	q := normalize(query)
+	switch q {
+	case "BEGIN", "COMMIT", "ROLLBACK":
+		return true
+	}
+	for _, prefix := range transactionPrefixes {
+		if strings.HasPrefix(q, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// EndsTransaction 结束当前事务的语句：COMMIT、ROLLBACK、XA COMMIT、XA ROLLBACK，以及隐式提交之前事务的BEGIN。
+// SAVEPOINT、ROLLBACK TO、XA START、XA END等语句在事务中，不结束事务
+func EndsTransaction(query string) bool {
+	q := normalize(query)
+	switch q {
+	case "BEGIN", "COMMIT", "ROLLBACK":
+		return true
+	}
+	return strings.HasPrefix(q, "XA COMMIT ") || strings.HasPrefix(q, "XA ROLLBACK ")
+}
+
+// normalize 转为大写，合并空白，去掉末尾的分号
+func normalize(query string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(strings.TrimRight(strings.TrimSpace(query), ";")), " "))
+}
+
+// DroppedTables DROP TABLE、TRUNCATE TABLE删除了数据的表，表名没有带库名时为schema
+func DroppedTables(query string, schema string) ([]Table, error) {
+	stmts, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	var tables []Table
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.DropTableStmt:
+			if s.IsView || s.TemporaryKeyword != ast.TemporaryNone {
+				continue
+			}
+			for _, t := range s.Tables {
+				tables = append(tables, tableOf(t, schema))
+			}
+		case *ast.TruncateTableStmt:
+			tables = append(tables, tableOf(s.Table, schema))
+		}
+	}
+	return tables, nil
+}
+
+// ChangedTables ddl修改了结构的表，Name为空时表示该库的所有表
+func ChangedTables(query string, schema string) ([]Table, error) {
+	stmts, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	var tables []Table
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.CreateTableStmt:
+			tables = append(tables, tableOf(s.Table, schema))
+		case *ast.DropTableStmt:
+			for _, name := range s.Tables {
+				tables = append(tables, tableOf(name, schema))
+			}
+		case *ast.RenameTableStmt:
+			for _, tt := range s.TableToTables {
+				tables = append(tables, tableOf(tt.OldTable, schema), tableOf(tt.NewTable, schema))
+			}
+		case *ast.AlterTableStmt:
+			tables = append(tables, tableOf(s.Table, schema))
+			for _, spec := range s.Specs {
+				if spec.Tp == ast.AlterTableRenameTable {
+					tables = append(tables, tableOf(spec.NewTable, schema))
+				}
+			}
+		case *ast.CreateIndexStmt:
+			tables = append(tables, tableOf(s.Table, schema))
+		case *ast.DropIndexStmt:
+			tables = append(tables, tableOf(s.Table, schema))
+		case *ast.DropDatabaseStmt:
+			tables = append(tables, Table{Schema: s.Name})
+		}
+	}
+	return tables, nil
+}
+
+func tableOf(name *ast.TableName, schema string) Table {
+	if name.Schema.O != "" {
+		schema = name.Schema.O
+	}
+	return Table{Schema: schema, Name: name.Name.O}
+}
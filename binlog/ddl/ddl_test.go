@@ -0,0 +1,54 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ddl
+
+import "testing"
+
+func TestIsTransactionControl(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+		// ends 是否结束当前事务
+		ends bool
+	}{
+		{query: "BEGIN", want: true, ends: true},
+		{query: "commit", want: true, ends: true},
+		{query: " ROLLBACK ;", want: true, ends: true},
+		{query: "SAVEPOINT `sp1`", want: true},
+		{query: "ROLLBACK TO SAVEPOINT sp1", want: true},
+		{query: "rollback to sp1", want: true},
+		{query: "RELEASE SAVEPOINT sp1", want: true},
+		{query: "XA START X'31',X'',1", want: true},
+		{query: "XA END X'31',X'',1", want: true},
+		{query: "XA PREPARE X'31',X'',1", want: true},
+		{query: "XA COMMIT X'31',X'',1", want: true, ends: true},
+		{query: "XA ROLLBACK X'31',X'',1", want: true, ends: true},
+		{query: "create table savepoint_log (id int)"},
+		{query: "ALTER TABLE xa ADD COLUMN c INT"},
+		{query: "BEGIN NOT ATOMIC SELECT 1; END"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := IsTransactionControl(tt.query); got != tt.want {
+				t.Errorf("IsTransactionControl(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+			if got := EndsTransaction(tt.query); got != tt.ends {
+				t.Errorf("EndsTransaction(%q) = %v, want %v", tt.query, got, tt.ends)
+			}
+		})
+	}
+}
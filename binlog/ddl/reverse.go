@@ -0,0 +1,221 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ddl
+
+import (
+	"fmt"
+	"github.com/pingcap/tidb/parser/ast"
+	"strings"
+)
+
+// Reverse ddl的反向语句
+type Reverse struct {
+	// Queries 反向ddl，按执行顺序排列，不带分号
+	Queries []string
+	// Irreversible 无法闪回的原因，不为空时Queries为空
+	Irreversible []string
+}
+
+// Reverse 生成query的反向ddl，并将query对表结构的修改记录到Tracker。
+// 需要按binlog中的顺序对每一条ddl调用，之前的字段、索引定义来自已记录的表结构
+func (t *Tracker) Reverse(query string, schema string) *Reverse {
+	stmts, err := Parse(query)
+	if err != nil {
+		return &Reverse{Irreversible: []string{"无法解析ddl: " + err.Error()}}
+	}
+	r := &Reverse{}
+	var queries [][]string
+	for _, stmt := range stmts {
+		q, reasons := t.reverse(stmt, schema)
+		r.Irreversible = append(r.Irreversible, reasons...)
+		queries = append(queries, q)
+		t.apply(stmt, schema)
+	}
+	if len(r.Irreversible) != 0 {
+		return r
+	}
+	for i := len(queries) - 1; i >= 0; i-- {
+		r.Queries = append(r.Queries, queries[i]...)
+	}
+	return r
+}
+
+func (t *Tracker) reverse(stmt ast.StmtNode, schema string) ([]string, []string) {
+	switch s := stmt.(type) {
+	case *ast.CreateTableStmt:
+		if s.TemporaryKeyword != ast.TemporaryNone {
+			return nil, nil
+		}
+		if s.IfNotExists {
+			if t.table(s.Table, schema) != nil {
+				// 表已经存在，语句没有修改表结构
+				return nil, nil
+			}
+			return nil, []string{"CREATE TABLE IF NOT EXISTS无法确定表之前是否存在"}
+		}
+		return []string{"DROP TABLE " + quoteTable(tableOf(s.Table, schema))}, nil
+	case *ast.DropTableStmt:
+		if s.IsView {
+			return nil, []string{"DROP VIEW无法闪回，需要重新创建视图"}
+		}
+		if s.TemporaryKeyword != ast.TemporaryNone {
+			return nil, nil
+		}
+		return nil, []string{"DROP TABLE无法闪回，表结构需要从备份恢复，数据可以通过--recover-dropped恢复解析范围内变更过的行"}
+	case *ast.TruncateTableStmt:
+		return nil, []string{"TRUNCATE无法闪回，数据可以通过--recover-dropped恢复解析范围内变更过的行"}
+	case *ast.RenameTableStmt:
+		pairs := make([]string, 0, len(s.TableToTables))
+		for i := len(s.TableToTables) - 1; i >= 0; i-- {
+			tt := s.TableToTables[i]
+			pairs = append(pairs, quoteTable(tableOf(tt.NewTable, schema))+" TO "+quoteTable(tableOf(tt.OldTable, schema)))
+		}
+		return []string{"RENAME TABLE " + strings.Join(pairs, ", ")}, nil
+	case *ast.CreateIndexStmt:
+		if s.IfNotExists {
+			return nil, []string{"CREATE INDEX IF NOT EXISTS无法确定索引之前是否存在"}
+		}
+		return []string{"DROP INDEX " + quote(s.IndexName) + " ON " + quoteTable(tableOf(s.Table, schema))}, nil
+	case *ast.DropIndexStmt:
+		ts := t.table(s.Table, schema)
+		if ts == nil || ts.indexes[strings.ToLower(s.IndexName)] == "" {
+			return nil, []string{fmt.Sprintf("解析范围内没有索引%s之前的定义，可以通过--schema-file提供表结构", quote(s.IndexName))}
+		}
+		return []string{"ALTER TABLE " + quoteTable(tableOf(s.Table, schema)) + " ADD " + ts.indexes[strings.ToLower(s.IndexName)]}, nil
+	case *ast.AlterTableStmt:
+		return t.reverseAlter(s, schema)
+	}
+	return nil, []string{"不支持闪回的ddl"}
+}
+
+// reverseAlter 每个操作生成反向操作，按相反的顺序合并为一条ALTER TABLE
+func (t *Tracker) reverseAlter(s *ast.AlterTableStmt, schema string) ([]string, []string) {
+	ts := t.table(s.Table, schema)
+	if ts == nil {
+		ts = newTableSchema()
+	}
+	table := tableOf(s.Table, schema)
+	var specs []string
+	var reasons []string
+	for _, spec := range s.Specs {
+		reversed, reason := reverseSpec(ts, spec)
+		if reason != "" {
+			reasons = append(reasons, reason)
+			continue
+		}
+		if spec.Tp == ast.AlterTableRenameTable {
+			// 反向ddl在改名后的表上执行，最后改回原来的表名
+			reversed = []string{"RENAME TO " + quoteTable(table)}
+			table = tableOf(spec.NewTable, schema)
+		}
+		specs = append(reversed, specs...)
+	}
+	if len(reasons) != 0 || len(specs) == 0 {
+		return nil, reasons
+	}
+	return []string{"ALTER TABLE " + quoteTable(table) + " " + strings.Join(specs, ", ")}, nil
+}
+
+func reverseSpec(ts *tableSchema, spec *ast.AlterTableSpec) ([]string, string) {
+	switch spec.Tp {
+	case ast.AlterTableAddColumns:
+		specs := make([]string, 0, len(spec.NewColumns))
+		for i := len(spec.NewColumns) - 1; i >= 0; i-- {
+			specs = append(specs, "DROP COLUMN "+quote(spec.NewColumns[i].Name.Name.O))
+		}
+		return specs, ""
+	case ast.AlterTableDropColumn:
+		return nil, fmt.Sprintf("DROP COLUMN %s会丢失字段数据，无法闪回", quote(spec.OldColumnName.Name.O))
+	case ast.AlterTableModifyColumn, ast.AlterTableChangeColumn:
+		col := spec.NewColumns[0]
+		name := col.Name.Name.O
+		prefix := "MODIFY COLUMN " + quote(name) + " "
+		if spec.Tp == ast.AlterTableChangeColumn {
+			name = spec.OldColumnName.Name.O
+			prefix = "CHANGE COLUMN " + quote(col.Name.Name.O) + " " + quote(name) + " "
+		}
+		prev := ts.column(name)
+		if prev == nil || prev.def == "" {
+			return nil, fmt.Sprintf("解析范围内没有字段%s之前的定义，可以通过--schema-file提供表结构", quote(name))
+		}
+		position := ""
+		if spec.Position != nil && spec.Position.Tp != ast.ColumnPositionNone {
+			if !ts.complete {
+				return nil, fmt.Sprintf("字段%s之前的位置未知，可以通过--schema-file提供表结构", quote(name))
+			}
+			position = ts.position(name)
+		}
+		return []string{prefix + prev.def + position}, ""
+	case ast.AlterTableRenameColumn:
+		return []string{"RENAME COLUMN " + quote(spec.NewColumnName.Name.O) + " TO " + quote(spec.OldColumnName.Name.O)}, ""
+	case ast.AlterTableAlterColumn:
+		name := spec.NewColumns[0].Name.Name.O
+		prev := ts.column(name)
+		if prev == nil || prev.def == "" {
+			return nil, fmt.Sprintf("解析范围内没有字段%s之前的默认值，可以通过--schema-file提供表结构", quote(name))
+		}
+		return []string{"MODIFY COLUMN " + quote(name) + " " + prev.def}, ""
+	case ast.AlterTableAddConstraint:
+		return reverseAddConstraint(spec.Constraint)
+	case ast.AlterTableDropIndex:
+		def := ts.indexes[strings.ToLower(spec.Name)]
+		if def == "" {
+			return nil, fmt.Sprintf("解析范围内没有索引%s之前的定义，可以通过--schema-file提供表结构", quote(spec.Name))
+		}
+		return []string{"ADD " + def}, ""
+	case ast.AlterTableDropPrimaryKey:
+		def := ts.indexes[primaryKey]
+		if def == "" {
+			return nil, "解析范围内没有主键之前的定义，可以通过--schema-file提供表结构"
+		}
+		return []string{"ADD " + def}, ""
+	case ast.AlterTableDropForeignKey:
+		def := ts.indexes[foreignKey(spec.Name)]
+		if def == "" {
+			return nil, fmt.Sprintf("解析范围内没有外键%s之前的定义，可以通过--schema-file提供表结构", quote(spec.Name))
+		}
+		return []string{"ADD " + def}, ""
+	case ast.AlterTableRenameIndex:
+		return []string{"RENAME INDEX " + quote(spec.ToKey.O) + " TO " + quote(spec.FromKey.O)}, ""
+	case ast.AlterTableRenameTable, ast.AlterTableLock, ast.AlterTableAlgorithm, ast.AlterTableForce:
+		return nil, ""
+	case ast.AlterTableOption:
+		return nil, "表选项之前的值未知，无法闪回"
+	}
+	return nil, "不支持闪回的ALTER TABLE操作"
+}
+
+func reverseAddConstraint(constraint *ast.Constraint) ([]string, string) {
+	switch constraint.Tp {
+	case ast.ConstraintPrimaryKey:
+		return []string{"DROP PRIMARY KEY"}, ""
+	case ast.ConstraintForeignKey:
+		if constraint.Name == "" {
+			return nil, "外键没有指定名称，无法确定自动生成的名称"
+		}
+		return []string{"DROP FOREIGN KEY " + quote(constraint.Name)}, ""
+	case ast.ConstraintCheck:
+		if constraint.Name == "" {
+			return nil, "CHECK约束没有指定名称，无法确定自动生成的名称"
+		}
+		return []string{"DROP CHECK " + quote(constraint.Name)}, ""
+	}
+	if constraint.Name == "" {
+		return nil, "索引没有指定名称，无法确定自动生成的名称"
+	}
+	return []string{"DROP INDEX " + quote(constraint.Name)}, ""
+}
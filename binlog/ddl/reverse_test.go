@@ -0,0 +1,96 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ddl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTrackerReverse(t *testing.T) {
+	const schema = "create table t (id int primary key, name varchar(100) not null default '', key idx_name (name));"
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+		// irreversible 无法闪回
+		irreversible bool
+	}{
+		{name: "add column", query: "alter table t add column age int, add column c int",
+			want: []string{"ALTER TABLE `test`.`t` DROP COLUMN `c`, DROP COLUMN `age`"}},
+		{name: "modify column", query: "alter table t modify column name varchar(200)",
+			want: []string{"ALTER TABLE `test`.`t` MODIFY COLUMN `name` VARCHAR(100) NOT NULL DEFAULT _UTF8MB4''"}},
+		{name: "change column", query: "alter table t change column name nick varchar(100)",
+			want: []string{"ALTER TABLE `test`.`t` CHANGE COLUMN `nick` `name` VARCHAR(100) NOT NULL DEFAULT _UTF8MB4''"}},
+		{name: "rename column", query: "alter table t rename column name to nick",
+			want: []string{"ALTER TABLE `test`.`t` RENAME COLUMN `nick` TO `name`"}},
+		{name: "drop index", query: "drop index idx_name on t",
+			want: []string{"ALTER TABLE `test`.`t` ADD INDEX `idx_name`(`name`)"}},
+		{name: "create index", query: "create index idx_id on t (id)",
+			want: []string{"DROP INDEX `idx_id` ON `test`.`t`"}},
+		{name: "create table", query: "create table t2 (id int)",
+			want: []string{"DROP TABLE `test`.`t2`"}},
+		{name: "rename table", query: "rename table t to t3",
+			want: []string{"RENAME TABLE `test`.`t3` TO `test`.`t`"}},
+		{name: "多条语句倒序", query: "create table t4 (id int); create table t5 (id int)",
+			want: []string{"DROP TABLE `test`.`t5`", "DROP TABLE `test`.`t4`"}},
+		{name: "create table if not exists已存在", query: "create table if not exists t (id int)"},
+		{name: "drop column", query: "alter table t drop column name", irreversible: true},
+		{name: "drop table", query: "drop table t", irreversible: true},
+		{name: "truncate", query: "truncate table t", irreversible: true},
+		{name: "缺少之前的定义", query: "alter table other modify column c int", irreversible: true},
+		{name: "无法解析", query: "alter tabel t", irreversible: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker()
+			if err := tracker.Load(strings.NewReader(schema), "test"); err != nil {
+				t.Fatal(err)
+			}
+			r := tracker.Reverse(tt.query, "test")
+			if (len(r.Irreversible) != 0) != tt.irreversible {
+				t.Fatalf("Reverse(%q) Irreversible = %q, want %v", tt.query, r.Irreversible, tt.irreversible)
+			}
+			if !reflect.DeepEqual(r.Queries, tt.want) {
+				t.Errorf("Reverse(%q) = %q, want %q", tt.query, r.Queries, tt.want)
+			}
+		})
+	}
+}
+
+// TestTrackerReverseSequence 反向ddl使用解析范围内之前的ddl记录的定义
+func TestTrackerReverseSequence(t *testing.T) {
+	tracker := NewTracker()
+	queries := []string{
+		"create table t (id int primary key)",
+		"alter table t add column name varchar(10)",
+		"alter table t modify column name varchar(20)",
+	}
+	var got []string
+	for _, query := range queries {
+		got = append(got, tracker.Reverse(query, "test").Queries...)
+	}
+	want := []string{
+		"DROP TABLE `test`.`t`",
+		"ALTER TABLE `test`.`t` DROP COLUMN `name`",
+		"ALTER TABLE `test`.`t` MODIFY COLUMN `name` VARCHAR(10)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Reverse() = %q, want %q", got, want)
+	}
+}
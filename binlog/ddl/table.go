@@ -0,0 +1,64 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ddl
+
+import (
+	"errors"
+	"fmt"
+	tableschema "github.com/go-mysql-org/go-mysql/schema"
+)
+
+// Apply 将ddl应用到跟踪的表结构，无法解析的语句忽略
+func (t *Tracker) Apply(query string, schema string) {
+	stmts, err := Parse(query)
+	if err != nil {
+		return
+	}
+	for _, stmt := range stmts {
+		t.apply(stmt, schema)
+	}
+}
+
+// Table 跟踪到的表结构，表不存在或者表结构不完整时返回错误
+func (t *Tracker) Table(schema string, name string) (*tableschema.Table, error) {
+	ts := t.tables[tableKey(Table{Schema: schema, Name: name})]
+	if ts == nil {
+		return nil, errors.New("没有该表的CREATE TABLE")
+	}
+	if !ts.complete {
+		return nil, errors.New("表结构不完整")
+	}
+	table := &tableschema.Table{Schema: schema, Name: name}
+	for _, c := range ts.columns {
+		if c.typ == "" {
+			return nil, fmt.Errorf("字段%s的类型未知", c.name)
+		}
+		table.AddColumn(c.name, c.typ, "", c.extra)
+	}
+	if len(ts.primary) > 0 {
+		index := table.AddIndex("PRIMARY")
+		for _, key := range ts.primary {
+			i := table.FindColumn(key)
+			if i < 0 {
+				return nil, fmt.Errorf("主键字段%s不存在", key)
+			}
+			index.AddColumn(table.Columns[i].Name, 0)
+			table.PKColumns = append(table.PKColumns, i)
+		}
+	}
+	return table, nil
+}
@@ -0,0 +1,458 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ddl
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/format"
+	"github.com/pingcap/tidb/parser/mysql"
+	"io"
+	"os"
+	"strings"
+)
+
+// column 字段定义，def不含字段名，为空时之前的定义未知。
+// typ为information_schema中的字段类型，extra为auto_increment或生成列的类型，用于生成schema.Table
+type column struct {
+	name  string
+	def   string
+	typ   string
+	extra string
+}
+
+// tableSchema 解析范围内跟踪到的表结构
+type tableSchema struct {
+	// complete 表结构来自完整的CREATE TABLE，字段顺序可信
+	complete bool
+	columns  []*column
+	// indexes 索引定义，key为小写的索引名，主键为primary，外键以foreign key开头
+	indexes map[string]string
+	// primary 主键字段
+	primary []string
+}
+
+func newTableSchema() *tableSchema {
+	return &tableSchema{indexes: make(map[string]string)}
+}
+
+func (t *tableSchema) index(name string) int {
+	for i, c := range t.columns {
+		if strings.EqualFold(c.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *tableSchema) column(name string) *column {
+	if i := t.index(name); i >= 0 {
+		return t.columns[i]
+	}
+	return nil
+}
+
+func (t *tableSchema) remove(name string) {
+	if i := t.index(name); i >= 0 {
+		t.columns = append(t.columns[:i], t.columns[i+1:]...)
+	}
+	for i, key := range t.primary {
+		if strings.EqualFold(key, name) {
+			t.primary = append(t.primary[:i:i], t.primary[i+1:]...)
+			break
+		}
+	}
+}
+
+// renamePrimary 字段改名后更新主键
+func (t *tableSchema) renamePrimary(from string, to string) {
+	for i, key := range t.primary {
+		if strings.EqualFold(key, from) {
+			t.primary[i] = to
+		}
+	}
+}
+
+// insert 按位置插入字段，表结构不完整时只追加到最后
+func (t *tableSchema) insert(c *column, position *ast.ColumnPosition) {
+	i := len(t.columns)
+	if position != nil {
+		switch position.Tp {
+		case ast.ColumnPositionFirst:
+			i = 0
+		case ast.ColumnPositionAfter:
+			if j := t.index(position.RelativeColumn.Name.O); j >= 0 {
+				i = j + 1
+			}
+		}
+	}
+	t.columns = append(t.columns, nil)
+	copy(t.columns[i+1:], t.columns[i:])
+	t.columns[i] = c
+}
+
+// position 字段当前的位置，用于恢复字段顺序
+func (t *tableSchema) position(name string) string {
+	i := t.index(name)
+	if i == 0 {
+		return " FIRST"
+	}
+	return " AFTER " + quote(t.columns[i-1].name)
+}
+
+// Tracker 跟踪解析范围内ddl对表结构的修改，用于生成反向ddl。
+// 表结构只来自解析范围内的ddl和Load加载的表结构文件，不使用数据库的当前表结构，
+// 因为当前表结构已经包含了解析范围之后的修改
+type Tracker struct {
+	tables map[string]*tableSchema
+}
+
+// NewTracker 创建空的Tracker
+func NewTracker() *Tracker {
+	return &Tracker{tables: make(map[string]*tableSchema)}
+}
+
+// LoadFile 加载表结构文件，如mysqldump --no-data的输出，只读取其中的USE和CREATE TABLE语句
+func (t *Tracker) LoadFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := t.Load(f, ""); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+// Load 加载表结构，schema为默认库，语句以行末的分号结束
+func (t *Tracker) Load(r io.Reader, schema string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var stmt strings.Builder
+	line := 0
+	start := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if stmt.Len() == 0 {
+			if text == "" || strings.HasPrefix(text, "--") {
+				continue
+			}
+			start = line
+		}
+		stmt.WriteString(text)
+		stmt.WriteString("\n")
+		if !strings.HasSuffix(text, ";") {
+			continue
+		}
+		query := stmt.String()
+		stmt.Reset()
+		upper := strings.ToUpper(query)
+		switch {
+		case strings.HasPrefix(upper, "USE "):
+			schema = strings.Trim(strings.TrimSpace(query[4:len(strings.TrimSpace(query))-1]), "`")
+		case strings.HasPrefix(upper, "CREATE TABLE"):
+			stmts, err := Parse(query)
+			if err != nil {
+				return fmt.Errorf("第%d行: %w", start, err)
+			}
+			for _, s := range stmts {
+				t.apply(s, schema)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func (t *Tracker) table(name *ast.TableName, schema string) *tableSchema {
+	return t.tables[tableKey(tableOf(name, schema))]
+}
+
+// ensure 获取表结构，表不存在时创建不完整的表结构
+func (t *Tracker) ensure(name *ast.TableName, schema string) *tableSchema {
+	key := tableKey(tableOf(name, schema))
+	ts, ok := t.tables[key]
+	if !ok {
+		ts = newTableSchema()
+		t.tables[key] = ts
+	}
+	return ts
+}
+
+func (t *Tracker) apply(stmt ast.StmtNode, schema string) {
+	switch s := stmt.(type) {
+	case *ast.CreateTableStmt:
+		if s.TemporaryKeyword != ast.TemporaryNone {
+			return
+		}
+		if s.IfNotExists && t.table(s.Table, schema) != nil {
+			return
+		}
+		ts := newTableSchema()
+		if s.ReferTable != nil {
+			// CREATE TABLE ... LIKE
+			if refer := t.table(s.ReferTable, schema); refer != nil {
+				ts.complete = refer.complete
+				for _, c := range refer.columns {
+					copied := *c
+					ts.columns = append(ts.columns, &copied)
+				}
+				for name, def := range refer.indexes {
+					ts.indexes[name] = def
+				}
+				ts.primary = append(ts.primary, refer.primary...)
+			}
+		} else {
+			ts.complete = s.Select == nil
+			for _, col := range s.Cols {
+				ts.columns = append(ts.columns, newColumn(col))
+				addColumnIndexes(ts, col)
+			}
+			for _, constraint := range s.Constraints {
+				addIndex(ts, constraint)
+			}
+		}
+		t.tables[tableKey(tableOf(s.Table, schema))] = ts
+	case *ast.DropTableStmt:
+		for _, name := range s.Tables {
+			delete(t.tables, tableKey(tableOf(name, schema)))
+		}
+	case *ast.RenameTableStmt:
+		for _, tt := range s.TableToTables {
+			t.rename(tt.OldTable, tt.NewTable, schema)
+		}
+	case *ast.CreateIndexStmt:
+		if constraint := indexConstraint(s); constraint != nil {
+			addIndex(t.ensure(s.Table, schema), constraint)
+		}
+	case *ast.DropIndexStmt:
+		if ts := t.table(s.Table, schema); ts != nil {
+			delete(ts.indexes, strings.ToLower(s.IndexName))
+		}
+	case *ast.AlterTableStmt:
+		ts := t.ensure(s.Table, schema)
+		for _, spec := range s.Specs {
+			applySpec(ts, spec)
+		}
+		for _, spec := range s.Specs {
+			if spec.Tp == ast.AlterTableRenameTable {
+				t.rename(s.Table, spec.NewTable, schema)
+			}
+		}
+	}
+}
+
+func (t *Tracker) rename(from *ast.TableName, to *ast.TableName, schema string) {
+	key := tableKey(tableOf(from, schema))
+	ts, ok := t.tables[key]
+	if !ok {
+		return
+	}
+	delete(t.tables, key)
+	t.tables[tableKey(tableOf(to, schema))] = ts
+}
+
+func applySpec(ts *tableSchema, spec *ast.AlterTableSpec) {
+	switch spec.Tp {
+	case ast.AlterTableAddColumns:
+		for _, col := range spec.NewColumns {
+			ts.insert(newColumn(col), spec.Position)
+			addColumnIndexes(ts, col)
+		}
+	case ast.AlterTableDropColumn:
+		ts.remove(spec.OldColumnName.Name.O)
+	case ast.AlterTableModifyColumn, ast.AlterTableChangeColumn:
+		col := spec.NewColumns[0]
+		name := col.Name.Name.O
+		if spec.Tp == ast.AlterTableChangeColumn {
+			name = spec.OldColumnName.Name.O
+		}
+		if spec.Position != nil && spec.Position.Tp != ast.ColumnPositionNone {
+			primary := ts.primary
+			ts.remove(name)
+			ts.primary = primary
+			ts.insert(newColumn(col), spec.Position)
+		} else if i := ts.index(name); i >= 0 {
+			ts.columns[i] = newColumn(col)
+		} else {
+			ts.insert(newColumn(col), nil)
+		}
+		ts.renamePrimary(name, col.Name.Name.O)
+		addColumnIndexes(ts, col)
+	case ast.AlterTableRenameColumn:
+		if c := ts.column(spec.OldColumnName.Name.O); c != nil {
+			c.name = spec.NewColumnName.Name.O
+		}
+		ts.renamePrimary(spec.OldColumnName.Name.O, spec.NewColumnName.Name.O)
+	case ast.AlterTableAlterColumn:
+		// 默认值被修改，之前的定义不再准确
+		if c := ts.column(spec.NewColumns[0].Name.Name.O); c != nil {
+			c.def = ""
+		}
+	case ast.AlterTableAddConstraint:
+		addIndex(ts, spec.Constraint)
+	case ast.AlterTableDropIndex:
+		delete(ts.indexes, strings.ToLower(spec.Name))
+	case ast.AlterTableDropPrimaryKey:
+		delete(ts.indexes, primaryKey)
+		ts.primary = nil
+	case ast.AlterTableDropForeignKey:
+		delete(ts.indexes, foreignKey(spec.Name))
+	case ast.AlterTableRenameIndex:
+		key := strings.ToLower(spec.FromKey.O)
+		if def, ok := ts.indexes[key]; ok {
+			delete(ts.indexes, key)
+			ts.indexes[strings.ToLower(spec.ToKey.O)] = strings.Replace(def, quote(spec.FromKey.O), quote(spec.ToKey.O), 1)
+		}
+	}
+}
+
+const primaryKey = "primary"
+
+// restoreFlags 字符串不带字符集前缀，与binlog中的原始ddl一致
+const restoreFlags = format.DefaultRestoreFlags | format.RestoreStringWithoutCharset
+
+func foreignKey(name string) string {
+	return "foreign key " + strings.ToLower(name)
+}
+
+// newColumn 字段定义，主键、唯一键作为索引记录，不包含在字段定义中
+func newColumn(col *ast.ColumnDef) *column {
+	var sb strings.Builder
+	ctx := format.NewRestoreCtx(restoreFlags, &sb)
+	if col.Tp != nil {
+		if err := col.Tp.Restore(ctx); err != nil {
+			return &column{name: col.Name.Name.O}
+		}
+	}
+	for _, option := range col.Options {
+		if option.Tp == ast.ColumnOptionPrimaryKey || option.Tp == ast.ColumnOptionUniqKey {
+			continue
+		}
+		sb.WriteString(" ")
+		if err := option.Restore(ctx); err != nil {
+			return &column{name: col.Name.Name.O}
+		}
+	}
+	c := &column{name: col.Name.Name.O, def: sb.String()}
+	if col.Tp != nil {
+		c.typ = strings.ToLower(col.Tp.InfoSchemaStr())
+		if mysql.HasZerofillFlag(col.Tp.GetFlag()) {
+			c.typ += " zerofill"
+		}
+	}
+	for _, option := range col.Options {
+		switch option.Tp {
+		case ast.ColumnOptionAutoIncrement:
+			c.extra = "auto_increment"
+		case ast.ColumnOptionGenerated:
+			c.extra = "VIRTUAL GENERATED"
+			if option.Stored {
+				c.extra = "STORED GENERATED"
+			}
+		}
+	}
+	return c
+}
+
+// addColumnIndexes 字段定义中的PRIMARY KEY、UNIQUE
+func addColumnIndexes(ts *tableSchema, col *ast.ColumnDef) {
+	name := col.Name.Name.O
+	for _, option := range col.Options {
+		switch option.Tp {
+		case ast.ColumnOptionPrimaryKey:
+			ts.indexes[primaryKey] = "PRIMARY KEY(" + quote(name) + ")"
+			ts.primary = []string{name}
+		case ast.ColumnOptionUniqKey:
+			ts.indexes[strings.ToLower(name)] = "UNIQUE KEY " + quote(name) + "(" + quote(name) + ")"
+		}
+	}
+}
+
+func addIndex(ts *tableSchema, constraint *ast.Constraint) {
+	key := indexKey(constraint)
+	if key == "" {
+		return
+	}
+	def, err := restore(constraint)
+	if err != nil {
+		return
+	}
+	ts.indexes[key] = def
+	if key == primaryKey {
+		ts.primary = nil
+		for _, part := range constraint.Keys {
+			if part.Column != nil {
+				ts.primary = append(ts.primary, part.Column.Name.O)
+			}
+		}
+	}
+}
+
+// indexKey 索引在tableSchema.indexes中的key，没有名称的索引返回空
+func indexKey(constraint *ast.Constraint) string {
+	switch constraint.Tp {
+	case ast.ConstraintPrimaryKey:
+		return primaryKey
+	case ast.ConstraintForeignKey:
+		if constraint.Name == "" {
+			return ""
+		}
+		return foreignKey(constraint.Name)
+	case ast.ConstraintKey, ast.ConstraintIndex, ast.ConstraintUniq, ast.ConstraintUniqKey, ast.ConstraintUniqIndex, ast.ConstraintFulltext:
+		return strings.ToLower(constraint.Name)
+	}
+	return ""
+}
+
+// indexConstraint 将CREATE INDEX转换为ALTER TABLE ADD的索引定义
+func indexConstraint(s *ast.CreateIndexStmt) *ast.Constraint {
+	constraint := &ast.Constraint{Name: s.IndexName, Keys: s.IndexPartSpecifications, Option: s.IndexOption}
+	switch s.KeyType {
+	case ast.IndexKeyTypeNone:
+		constraint.Tp = ast.ConstraintIndex
+	case ast.IndexKeyTypeUnique:
+		constraint.Tp = ast.ConstraintUniqIndex
+	case ast.IndexKeyTypeFullText:
+		constraint.Tp = ast.ConstraintFulltext
+	default:
+		return nil
+	}
+	return constraint
+}
+
+func restore(node ast.Node) (string, error) {
+	var sb strings.Builder
+	if err := node.Restore(format.NewRestoreCtx(restoreFlags, &sb)); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+func tableKey(table Table) string {
+	return strings.ToLower(table.String())
+}
+
+func quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func quoteTable(table Table) string {
+	return quote(table.Schema) + "." + quote(table.Name)
+}
@@ -17,13 +17,13 @@
 package event
 
 import (
-	"fmt"
-	"github.com/dhbin/ra/binlog/sql"
+	"github.com/dhbin/ra/binlog/ddl"
 	"github.com/dhbin/ra/config"
 	"github.com/go-mysql-org/go-mysql/canal"
 	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/go-mysql-org/go-mysql/replication"
-	"io"
+	"path/filepath"
+	"strings"
 )
 
 // ToSqlHandler 生成sql
@@ -34,41 +34,175 @@ type ToSqlHandler struct {
 // FlashbackHandler 闪回sql
 type FlashbackHandler struct {
 	BaseHandler
+	// Tracker 跟踪表结构，用于生成反向ddl，为nil时从空的表结构开始跟踪
+	Tracker *ddl.Tracker
 }
 
 type BaseHandler struct {
 	canal.DummyEventHandler
 	Config *config.BinlogConfig
 	Done   chan interface{}
-	Out    io.Writer
+	Sink   Sink
 
 	isDone         bool
 	currentLogName string
+	currentGTID    string
+	// processedGTID 最后一个gtid事件的gtid，事务被过滤时也在处理完成后交给Checkpointer
+	processedGTID string
+	thread        uint32
+	xid           uint64
+	tx            *Transaction
+	position      Position
+	err           error
 }
 
+// OnRotate rotate事件属于之前的文件，先判断是否到达终止位置再切换文件名
 func (h *BaseHandler) OnRotate(header *replication.EventHeader, rotateEvent *replication.RotateEvent) error {
+	h.ignore(header)
 	h.currentLogName = string(rotateEvent.NextLogName)
+	return nil
+}
+
+func (h *BaseHandler) OnDDL(header *replication.EventHeader, _ mysql.Position, queryEvent *replication.QueryEvent) error {
+	if err := h.beforeQuery(header, queryEvent); err != nil {
+		return err
+	}
 	if h.ignore(header) {
 		return nil
 	}
-	return nil
+	return h.onQuery(header, queryEvent)
 }
 
-func (h *BaseHandler) OnDDL(header *replication.EventHeader, _ mysql.Position, _ *replication.QueryEvent) error {
+func (h *BaseHandler) OnXID(header *replication.EventHeader, _ mysql.Position) error {
+	if err := h.commit(header); err != nil {
+		return err
+	}
 	if h.ignore(header) {
 		return nil
 	}
+	return h.processed(header)
+}
+
+// beforeQuery ddl以及COMMIT、ROLLBACK等结束事务的语句先提交当前事务，
+// SAVEPOINT、XA END等事务中的控制语句不影响当前事务，避免一个事务被拆分为两个
+func (h *BaseHandler) beforeQuery(header *replication.EventHeader, queryEvent *replication.QueryEvent) error {
+	if inTransaction(queryEvent) {
+		return nil
+	}
+	return h.commit(header)
+}
+
+// onQuery 记录事务开始时的线程id，事务中的控制语句不记录位置，其它语句处理完成后记录位置
+func (h *BaseHandler) onQuery(header *replication.EventHeader, queryEvent *replication.QueryEvent) error {
+	if strings.EqualFold(strings.TrimSpace(string(queryEvent.Query)), "BEGIN") {
+		h.thread = queryEvent.SlaveProxyID
+		return nil
+	}
+	if inTransaction(queryEvent) {
+		return nil
+	}
+	return h.processed(header)
+}
+
+// processed 记录最后处理完成的位置，只在事务结束、ddl之后记录，从该位置继续解析不会从事务中间开始。
+// Sink实现了Checkpointer时通知Sink
+func (h *BaseHandler) processed(header *replication.EventHeader) error {
+	h.position = newPosition(h.currentLogName, header)
+	gtid := h.processedGTID
+	h.processedGTID = ""
+	if checkpointer, ok := h.Sink.(Checkpointer); ok {
+		return checkpointer.Checkpoint(h.position, gtid)
+	}
 	return nil
 }
 
-func (h *BaseHandler) OnXID(header *replication.EventHeader, _ mysql.Position) error {
-	if h.ignore(header) {
+// Position 最后处理完成的位置，还没有处理完成任何事务时File为空
+func (h *BaseHandler) Position() Position {
+	return h.position
+}
+
+// Discard 丢弃未完成的事务，Sink实现了Discarder时通知Sink
+func (h *BaseHandler) Discard() error {
+	tx := h.tx
+	if tx == nil {
 		return nil
 	}
+	h.tx = nil
+	h.xid = 0
+	h.thread = 0
+	h.currentGTID = ""
+	if discarder, ok := h.Sink.(Discarder); ok {
+		return discarder.Discard(tx)
+	}
 	return nil
 }
 
-func (h *BaseHandler) OnGTID(header *replication.EventHeader, _ mysql.GTIDSet) error {
+// SetXID 记录当前事务的xid，在OnXID时随事务提交
+func (h *BaseHandler) SetXID(xid uint64) {
+	h.xid = xid
+}
+
+// begin 在事务的第一行变更之前开始事务
+func (h *BaseHandler) begin(header *replication.EventHeader) error {
+	if h.tx != nil {
+		return nil
+	}
+	h.tx = &Transaction{
+		Position: newPosition(h.currentLogName, header),
+		GTID:     h.currentGTID,
+		Thread:   h.thread,
+	}
+	return h.Sink.Begin(h.tx)
+}
+
+// commit 提交当前事务，header为nil时位置不变
+func (h *BaseHandler) commit(header *replication.EventHeader) error {
+	if h.tx == nil {
+		return nil
+	}
+	tx := h.tx
+	tx.XID = h.xid
+	if header != nil {
+		tx.Position = newPosition(h.currentLogName, header)
+	}
+	h.tx = nil
+	h.xid = 0
+	h.thread = 0
+	h.currentGTID = ""
+	return h.Sink.Commit(tx)
+}
+
+// writeRows 将行事件转换为行变更写入Sink
+func (h *BaseHandler) writeRows(e *canal.RowsEvent, flashback bool) error {
+	if !h.Config.SupportSqlType(e.Action) {
+		return nil
+	}
+	if err := h.begin(e.Header); err != nil {
+		return err
+	}
+	for _, change := range newRowChanges(h.tx, newPosition(h.currentLogName, e.Header), e, flashback) {
+		if err := h.Sink.Row(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 提交未结束的事务并关闭Sink
+func (h *BaseHandler) Close() error {
+	err := h.err
+	if err == nil {
+		err = h.commit(nil)
+	}
+	if closeErr := h.Sink.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (h *BaseHandler) OnGTID(header *replication.EventHeader, gtid mysql.GTIDSet) error {
+	h.currentGTID = gtid.String()
+	h.processedGTID = h.currentGTID
 	if h.ignore(header) {
 		return nil
 	}
@@ -84,99 +218,121 @@ func (h *BaseHandler) OnTableChanged(header *replication.EventHeader, _ string,
 
 func (h *BaseHandler) OnPosSynced(header *replication.EventHeader, pos mysql.Position, _ mysql.GTIDSet, _ bool) error {
 	h.currentLogName = pos.Name
-	if h.ignore(header) {
+	// canal关闭时header为nil
+	if header == nil || h.ignore(header) {
 		return nil
 	}
 	return nil
 }
 
+// done 到达终止位置，只通知一次，Done需要有缓冲
+func (h *BaseHandler) done() {
+	if h.isDone {
+		return
+	}
+	h.err = h.commit(nil)
+	h.isDone = true
+	h.Done <- ""
+}
+
 func (h *BaseHandler) ignore(header *replication.EventHeader) bool {
 	if h.isDone {
 		return true
 	}
-	if h.Config.StopPosition != 0 && header.LogPos >= h.Config.StopPosition {
-		h.isDone = true
-		h.Done <- ""
+	if h.Config.StopPosition != 0 && header.LogPos >= h.Config.StopPosition && h.inStopFile() {
+		h.done()
 	}
 
 	if h.Config.StartDatetime != nil && h.Config.StartDatetime.Unix() > int64(header.Timestamp) {
 		return true
 	}
 
-	if h.Config.StopBinlogName == h.currentLogName || (h.currentLogName == "") {
+	if h.inStopFile() {
 		if h.Config.StopDatetime != nil && h.Config.StopDatetime.Unix() <= int64(header.Timestamp) {
-			h.isDone = true
-			h.Done <- ""
+			h.done()
 		}
 	}
 
 	return false
 }
 
+// inStopFile 是否在终止文件中，local模式时StopBinlogName可以是文件路径
+func (h *BaseHandler) inStopFile() bool {
+	return h.currentLogName == "" || h.currentLogName == filepath.Base(h.Config.StopBinlogName)
+}
+
 func (h *ToSqlHandler) OnDDL(header *replication.EventHeader, _ mysql.Position, queryEvent *replication.QueryEvent) error {
+	if err := h.beforeQuery(header, queryEvent); err != nil {
+		return err
+	}
 	if h.ignore(header) {
 		return nil
 	}
-	if h.Config.DDL {
-		_, _ = fmt.Fprintln(h.Out, string(queryEvent.Query))
+	if h.Config.DDL && !isTransactionControl(queryEvent) {
+		if err := h.Sink.DDL(h.newDDLChange(header, queryEvent)); err != nil {
+			return err
+		}
 	}
-	return nil
+	return h.onQuery(header, queryEvent)
 }
 
-func (h *ToSqlHandler) OnRow(e *canal.RowsEvent) error {
-	if h.ignore(e.Header) {
+// OnDDL 输出ddl时生成反向ddl，恢复被删除的数据时由Sink判断是否为DROP TABLE、TRUNCATE
+func (h *FlashbackHandler) OnDDL(header *replication.EventHeader, _ mysql.Position, queryEvent *replication.QueryEvent) error {
+	if err := h.beforeQuery(header, queryEvent); err != nil {
+		return err
+	}
+	if h.ignore(header) {
 		return nil
 	}
-	switch e.Action {
-	case canal.InsertAction:
-		if !h.Config.SupportSqlType(canal.InsertAction) {
-			return nil
-		}
-		for _, row := range e.Rows {
-			_, _ = fmt.Fprintf(h.Out, "%s # pos %d timestamp %d\n", sql.BuildInsertSql(e.Table, row), e.Header.LogPos, e.Header.Timestamp)
-		}
-	case canal.UpdateAction:
-		if !h.Config.SupportSqlType(canal.UpdateAction) {
-			return nil
-		}
-		_, _ = fmt.Fprintf(h.Out, "%s # pos %d timestamp %d\n", sql.BuildUpdateSql(e.Table, e.Rows[0], e.Rows[1]), e.Header.LogPos, e.Header.Timestamp)
-	case canal.DeleteAction:
-		if !h.Config.SupportSqlType(canal.DeleteAction) {
-			return nil
+	if (h.Config.DDL || h.Config.RecoverDropped) && !isTransactionControl(queryEvent) {
+		c := h.newDDLChange(header, queryEvent)
+		c.Flashback = true
+		if h.Config.DDL {
+			if h.Tracker == nil {
+				h.Tracker = ddl.NewTracker()
+			}
+			c.Reverse = h.Tracker.Reverse(c.Query, c.Schema)
 		}
-		for _, row := range e.Rows {
-			_, _ = fmt.Fprintf(h.Out, "%s # pos %d timestamp %d\n", sql.BuildDeleteSql(e.Table, row), e.Header.LogPos, e.Header.Timestamp)
+		if err := h.Sink.DDL(c); err != nil {
+			return err
 		}
 	}
-	return nil
+	return h.onQuery(header, queryEvent)
 }
 
-func (h *FlashbackHandler) OnRow(e *canal.RowsEvent) error {
+func (h *BaseHandler) newDDLChange(header *replication.EventHeader, queryEvent *replication.QueryEvent) *DDLChange {
+	return &DDLChange{
+		Position: newPosition(h.currentLogName, header),
+		Schema:   string(queryEvent.Schema),
+		Query:    string(queryEvent.Query),
+		GTID:     h.currentGTID,
+		Session:  newSession(queryEvent.StatusVars),
+	}
+}
+
+// isTransactionControl BEGIN、COMMIT、SAVEPOINT、XA等事务控制语句也会通过OnDDL传入，不作为ddl输出
+func isTransactionControl(queryEvent *replication.QueryEvent) bool {
+	return ddl.IsTransactionControl(string(queryEvent.Query))
+}
+
+// inTransaction SAVEPOINT、ROLLBACK TO、XA START、XA END等在事务中执行、不结束事务的语句
+func inTransaction(queryEvent *replication.QueryEvent) bool {
+	query := string(queryEvent.Query)
+	return ddl.IsTransactionControl(query) && !ddl.EndsTransaction(query)
+}
+
+func (h *ToSqlHandler) OnRow(e *canal.RowsEvent) error {
 	if h.ignore(e.Header) {
 		return nil
 	}
-	switch e.Action {
-	case canal.InsertAction:
-		if !h.Config.SupportSqlType(canal.InsertAction) {
-			return nil
-		}
-		for _, row := range e.Rows {
-			_, _ = fmt.Fprintf(h.Out, "%s # pos %d timestamp %d\n", sql.BuildDeleteSql(e.Table, row), e.Header.LogPos, e.Header.Timestamp)
-		}
-	case canal.UpdateAction:
-		if !h.Config.SupportSqlType(canal.UpdateAction) {
-			return nil
-		}
-		_, _ = fmt.Fprintf(h.Out, "%s # pos %d timestamp %d\n", sql.BuildUpdateSql(e.Table, e.Rows[1], e.Rows[0]), e.Header.LogPos, e.Header.Timestamp)
-	case canal.DeleteAction:
-		if !h.Config.SupportSqlType(canal.DeleteAction) {
-			return nil
-		}
-		for _, row := range e.Rows {
-			_, _ = fmt.Fprintf(h.Out, "%s # pos %d timestamp %d\n", sql.BuildInsertSql(e.Table, row), e.Header.LogPos, e.Header.Timestamp)
-		}
+	return h.writeRows(e, false)
+}
+
+func (h *FlashbackHandler) OnRow(e *canal.RowsEvent) error {
+	if h.ignore(e.Header) {
+		return nil
 	}
-	return nil
+	return h.writeRows(e, true)
 }
 
 type DiscardLogHandler struct {
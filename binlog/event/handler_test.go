@@ -0,0 +1,193 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package event
+
+import (
+	"fmt"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"reflect"
+	"testing"
+)
+
+// checkpointRecorder 记录Checkpoint的调用
+type checkpointRecorder struct {
+	commits     int
+	checkpoints []string
+}
+
+func (s *checkpointRecorder) Begin(*Transaction) error  { return nil }
+func (s *checkpointRecorder) Row(*RowChange) error      { return nil }
+func (s *checkpointRecorder) DDL(*DDLChange) error      { return nil }
+func (s *checkpointRecorder) Close() error              { return nil }
+func (s *checkpointRecorder) Commit(*Transaction) error { s.commits++; return nil }
+
+func (s *checkpointRecorder) Checkpoint(pos Position, gtid string) error {
+	s.checkpoints = append(s.checkpoints, pos.File+":"+gtid)
+	return nil
+}
+
+func TestBaseHandlerCheckpoint(t *testing.T) {
+	const uuid = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	gtid := func(gno int) mysql.GTIDSet {
+		set, err := mysql.ParseMysqlGTIDSet(fmt.Sprintf("%s:%d", uuid, gno))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return set
+	}
+	header := func(pos uint32) *replication.EventHeader {
+		return &replication.EventHeader{LogPos: pos, EventSize: 10}
+	}
+	table := &schema.Table{Schema: "db", Name: "t", Columns: []schema.TableColumn{{Name: "id"}}}
+
+	s := &checkpointRecorder{}
+	h := &ToSqlHandler{}
+	h.Config = &config.BinlogConfig{SqlTypes: []string{canal.InsertAction}, StopBinlogName: "mysql-bin.000001"}
+	h.Done, h.Sink = make(chan interface{}, 1), s
+	h.currentLogName = "mysql-bin.000001"
+
+	// 写入Sink的事务
+	_ = h.OnGTID(header(100), gtid(1))
+	if err := h.OnRow(&canal.RowsEvent{Table: table, Action: canal.InsertAction, Rows: [][]interface{}{{1}}, Header: header(200)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.OnXID(header(300), mysql.Position{}); err != nil {
+		t.Fatal(err)
+	}
+	// 被sql-type过滤的事务
+	_ = h.OnGTID(header(400), gtid(2))
+	if err := h.OnRow(&canal.RowsEvent{Table: table, Action: canal.DeleteAction, Rows: [][]interface{}{{1}}, Header: header(500)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.OnXID(header(600), mysql.Position{}); err != nil {
+		t.Fatal(err)
+	}
+	// 没有输出的ddl
+	_ = h.OnGTID(header(700), gtid(3))
+	if err := h.OnDDL(header(800), mysql.Position{}, &replication.QueryEvent{Query: []byte("CREATE TABLE t2 (id int)")}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"mysql-bin.000001:" + uuid + ":1", "mysql-bin.000001:" + uuid + ":2", "mysql-bin.000001:" + uuid + ":3"}
+	if !reflect.DeepEqual(s.checkpoints, want) {
+		t.Errorf("checkpoints = %v, want %v", s.checkpoints, want)
+	}
+	if s.commits != 1 {
+		t.Errorf("commits = %d, want 1", s.commits)
+	}
+	if got := h.Position(); got.Pos != 800 {
+		t.Errorf("Position() = %+v, want pos 800", got)
+	}
+}
+
+// txRecorder 记录每个事务的行数和输出的ddl
+type txRecorder struct {
+	rows int
+	txs  []int
+	ddl  []string
+}
+
+func (s *txRecorder) Begin(*Transaction) error { return nil }
+func (s *txRecorder) Row(*RowChange) error     { s.rows++; return nil }
+func (s *txRecorder) Close() error             { return nil }
+
+func (s *txRecorder) DDL(c *DDLChange) error {
+	s.ddl = append(s.ddl, c.Query)
+	return nil
+}
+
+func (s *txRecorder) Commit(*Transaction) error {
+	s.txs = append(s.txs, s.rows)
+	s.rows = 0
+	return nil
+}
+
+// TestHandlerTransactionControl 事务中的SAVEPOINT、XA等语句不提交事务，也不作为ddl输出
+func TestHandlerTransactionControl(t *testing.T) {
+	table := &schema.Table{Schema: "db", Name: "t", Columns: []schema.TableColumn{{Name: "id"}}}
+	pos := uint32(0)
+	header := func() *replication.EventHeader {
+		pos += 10
+		return &replication.EventHeader{LogPos: pos, EventSize: 10}
+	}
+	tests := []struct {
+		name string
+		// queries 依次执行，row为一行insert，xid为XID事件
+		queries []string
+		txs     []int
+		ddl     []string
+	}{
+		{
+			name:    "savepoint",
+			queries: []string{"BEGIN", "row", "SAVEPOINT sp1", "row", "ROLLBACK TO SAVEPOINT sp1", "row", "RELEASE SAVEPOINT sp1", "xid"},
+			txs:     []int{3},
+		},
+		{
+			name:    "xa one phase",
+			queries: []string{"XA START X'31',X'',1", "row", "row", "XA END X'31',X'',1", "XA COMMIT X'31',X'',1 ONE PHASE"},
+			txs:     []int{2},
+		},
+		{
+			name:    "非事务表以COMMIT结束",
+			queries: []string{"BEGIN", "row", "COMMIT", "BEGIN", "row", "ROLLBACK"},
+			txs:     []int{1, 1},
+		},
+		{
+			name:    "ddl提交未结束的事务",
+			queries: []string{"BEGIN", "row", "CREATE TABLE t2 (id int)", "row", "xid"},
+			txs:     []int{1, 1},
+			ddl:     []string{"CREATE TABLE t2 (id int)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &txRecorder{}
+			h := &ToSqlHandler{}
+			h.Config = &config.BinlogConfig{DDL: true, SqlTypes: []string{canal.InsertAction}, StopBinlogName: "mysql-bin.000001"}
+			h.Done, h.Sink = make(chan interface{}, 1), s
+			h.currentLogName = "mysql-bin.000001"
+			for _, q := range tt.queries {
+				var err error
+				switch q {
+				case "row":
+					err = h.OnRow(&canal.RowsEvent{Table: table, Action: canal.InsertAction, Rows: [][]interface{}{{1}}, Header: header()})
+				case "xid":
+					err = h.OnXID(header(), mysql.Position{})
+				default:
+					err = h.OnDDL(header(), mysql.Position{}, &replication.QueryEvent{Query: []byte(q)})
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(s.txs, tt.txs) || s.rows != 0 {
+				t.Errorf("每个事务的行数 = %v, 未提交 %d, want %v", s.txs, s.rows, tt.txs)
+			}
+			if !reflect.DeepEqual(s.ddl, tt.ddl) {
+				t.Errorf("ddl = %q, want %q", s.ddl, tt.ddl)
+			}
+			// 最后一个事件结束事务，位置为最后一个事件
+			if got := h.Position(); got.Pos != pos {
+				t.Errorf("Position() = %+v, want pos %d", got, pos)
+			}
+		})
+	}
+}
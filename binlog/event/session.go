@@ -0,0 +1,209 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package event
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pingcap/tidb/parser/charset"
+	"strings"
+)
+
+// query event中status vars的类型，见mysql的log_event.h
+const (
+	qFlags2Code                   = 0
+	qSqlModeCode                  = 1
+	qCatalogCode                  = 2
+	qAutoIncrement                = 3
+	qCharsetCode                  = 4
+	qTimeZoneCode                 = 5
+	qCatalogNzCode                = 6
+	qLcTimeNamesCode              = 7
+	qCharsetDatabaseCode          = 8
+	qTableMapForUpdateCode        = 9
+	qMasterDataWrittenCode        = 10
+	qInvoker                      = 11
+	qUpdatedDbNames               = 12
+	qMicroseconds                 = 13
+	qExplicitDefaultsForTimestamp = 16
+	qDdlLoggedWithXid             = 17
+	qDefaultCollationForUtf8mb4   = 18
+	qSqlRequirePrimaryKey         = 19
+	qDefaultTableEncryption       = 20
+)
+
+// sqlModes sql_mode每一位对应的名称
+var sqlModes = []string{
+	"REAL_AS_FLOAT", "PIPES_AS_CONCAT", "ANSI_QUOTES", "IGNORE_SPACE", "NOT_USED", "ONLY_FULL_GROUP_BY",
+	"NO_UNSIGNED_SUBTRACTION", "NO_DIR_IN_CREATE", "POSTGRESQL", "ORACLE", "MSSQL", "DB2", "MAXDB",
+	"NO_KEY_OPTIONS", "NO_TABLE_OPTIONS", "NO_FIELD_OPTIONS", "MYSQL323", "MYSQL40", "ANSI",
+	"NO_AUTO_VALUE_ON_ZERO", "NO_BACKSLASH_ESCAPES", "STRICT_TRANS_TABLES", "STRICT_ALL_TABLES",
+	"NO_ZERO_IN_DATE", "NO_ZERO_DATE", "ALLOW_INVALID_DATES", "ERROR_FOR_DIVISION_BY_ZERO", "TRADITIONAL",
+	"NO_AUTO_CREATE_USER", "HIGH_NOT_PRECEDENCE", "NO_ENGINE_SUBSTITUTION", "PAD_CHAR_TO_FULL_LENGTH",
+	"TIME_TRUNCATE_FRACTIONAL",
+}
+
+// removedSqlModes mysql 8.0删除的sql_mode，设置时报错ER_WRONG_VALUE_FOR_VAR，输出时去掉。
+// 5.7中这些sql_mode只影响GRANT和SHOW CREATE的输出，不影响ddl的执行
+var removedSqlModes = map[string]bool{
+	"POSTGRESQL": true, "ORACLE": true, "MSSQL": true, "DB2": true, "MAXDB": true,
+	"NO_KEY_OPTIONS": true, "NO_TABLE_OPTIONS": true, "NO_FIELD_OPTIONS": true,
+	"MYSQL323": true, "MYSQL40": true, "NO_AUTO_CREATE_USER": true,
+}
+
+// Session ddl执行时的会话变量，来自query event的status vars，binlog中没有记录的变量为空
+type Session struct {
+	// SqlMode 逗号隔开的sql_mode，HasSqlMode为false时没有记录
+	SqlMode    string
+	HasSqlMode bool
+	// CharsetClient、CollationConnection、CollationServer为名称，未知的collation id保留数字
+	CharsetClient       string
+	CollationConnection string
+	CollationServer     string
+	TimeZone            string
+}
+
+// Statements 设置会话变量的sql，不带分号
+func (s *Session) Statements() []string {
+	var stmts []string
+	if s.HasSqlMode {
+		stmts = append(stmts, fmt.Sprintf("SET @@session.sql_mode='%s'", s.SqlMode))
+	}
+	if s.CharsetClient != "" {
+		stmts = append(stmts, fmt.Sprintf("SET @@session.character_set_client=%s, @@session.collation_connection=%s, @@session.collation_server=%s",
+			s.CharsetClient, s.CollationConnection, s.CollationServer))
+	}
+	if s.TimeZone != "" {
+		stmts = append(stmts, fmt.Sprintf("SET @@session.time_zone='%s'", strings.ReplaceAll(s.TimeZone, "'", "''")))
+	}
+	return stmts
+}
+
+// newSession 解析status vars，遇到未知的类型时停止解析，已解析的变量仍然有效
+func newSession(vars []byte) Session {
+	var s Session
+	for pos := 0; pos < len(vars); {
+		code := vars[pos]
+		pos++
+		n := 0
+		switch code {
+		case qFlags2Code:
+			n = 4
+		case qSqlModeCode:
+			if pos+8 > len(vars) {
+				return s
+			}
+			s.SqlMode = sqlModeString(binary.LittleEndian.Uint64(vars[pos:]))
+			s.HasSqlMode = true
+			n = 8
+		case qCatalogCode:
+			if pos >= len(vars) {
+				return s
+			}
+			// 长度 + 字符串 + \0
+			n = 1 + int(vars[pos]) + 1
+		case qAutoIncrement:
+			n = 4
+		case qCharsetCode:
+			if pos+6 > len(vars) {
+				return s
+			}
+			client := binary.LittleEndian.Uint16(vars[pos:])
+			s.CharsetClient = charsetName(client)
+			s.CollationConnection = collationName(binary.LittleEndian.Uint16(vars[pos+2:]))
+			s.CollationServer = collationName(binary.LittleEndian.Uint16(vars[pos+4:]))
+			n = 6
+		case qTimeZoneCode:
+			if pos >= len(vars) || pos+1+int(vars[pos]) > len(vars) {
+				return s
+			}
+			s.TimeZone = string(vars[pos+1 : pos+1+int(vars[pos])])
+			n = 1 + int(vars[pos])
+		case qCatalogNzCode:
+			if pos >= len(vars) {
+				return s
+			}
+			n = 1 + int(vars[pos])
+		case qLcTimeNamesCode, qCharsetDatabaseCode, qDefaultCollationForUtf8mb4:
+			n = 2
+		case qTableMapForUpdateCode, qDdlLoggedWithXid:
+			n = 8
+		case qMasterDataWrittenCode:
+			n = 4
+		case qInvoker:
+			// user和host，长度 + 字符串
+			if pos >= len(vars) {
+				return s
+			}
+			n = 1 + int(vars[pos])
+			if pos+n >= len(vars) {
+				return s
+			}
+			n += 1 + int(vars[pos+n])
+		case qUpdatedDbNames:
+			if pos >= len(vars) {
+				return s
+			}
+			count := int(vars[pos])
+			n = 1
+			// 超过16个库时为254，后面没有库名
+			if count != 254 {
+				for i := 0; i < count; i++ {
+					end := pos + n
+					for end < len(vars) && vars[end] != 0 {
+						end++
+					}
+					n = end - pos + 1
+				}
+			}
+		case qMicroseconds:
+			n = 3
+		case qExplicitDefaultsForTimestamp, qSqlRequirePrimaryKey, qDefaultTableEncryption:
+			n = 1
+		default:
+			return s
+		}
+		pos += n
+	}
+	return s
+}
+
+func sqlModeString(mode uint64) string {
+	var names []string
+	for i, name := range sqlModes {
+		if mode&(1<<uint(i)) != 0 && name != "NOT_USED" && !removedSqlModes[name] {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func charsetName(id uint16) string {
+	collation, err := charset.GetCollationByID(int(id))
+	if err != nil {
+		return fmt.Sprint(id)
+	}
+	return collation.CharsetName
+}
+
+func collationName(id uint16) string {
+	collation, err := charset.GetCollationByID(int(id))
+	if err != nil {
+		return fmt.Sprint(id)
+	}
+	return collation.Name
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package event
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestNewSession(t *testing.T) {
+	u16 := func(v uint16) []byte {
+		return binary.LittleEndian.AppendUint16(nil, v)
+	}
+	u64 := func(v uint64) []byte {
+		return binary.LittleEndian.AppendUint64(nil, v)
+	}
+	join := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+	sqlMode := join([]byte{qSqlModeCode}, u64(1<<5|1<<4|1<<21))
+	charset := join([]byte{qCharsetCode}, u16(45), u16(45), u16(8))
+	timeZone := join([]byte{qTimeZoneCode, 6}, []byte("+08:00"))
+
+	tests := []struct {
+		name string
+		vars []byte
+		want Session
+	}{
+		{name: "没有status vars"},
+		{
+			name: "sql_mode跳过NOT_USED",
+			vars: sqlMode,
+			want: Session{SqlMode: "ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES", HasSqlMode: true},
+		},
+		{
+			name: "sql_mode去掉8.0删除的模式",
+			vars: join([]byte{qSqlModeCode}, u64(1<<21|1<<28|1<<30|1<<8|1<<15)),
+			want: Session{SqlMode: "STRICT_TRANS_TABLES,NO_ENGINE_SUBSTITUTION", HasSqlMode: true},
+		},
+		{name: "sql_mode为空", vars: join([]byte{qSqlModeCode}, u64(0)), want: Session{HasSqlMode: true}},
+		{
+			name: "字符集",
+			vars: charset,
+			want: Session{CharsetClient: "utf8mb4", CollationConnection: "utf8mb4_general_ci", CollationServer: "latin1_swedish_ci"},
+		},
+		{
+			name: "未知的collation保留数字",
+			vars: join([]byte{qCharsetCode}, u16(1000), u16(1000), u16(1000)),
+			want: Session{CharsetClient: "1000", CollationConnection: "1000", CollationServer: "1000"},
+		},
+		{name: "时区", vars: timeZone, want: Session{TimeZone: "+08:00"}},
+		{
+			name: "跳过其它变量",
+			vars: join(
+				[]byte{qFlags2Code, 0, 0, 0, 0},
+				sqlMode,
+				[]byte{qCatalogCode, 3}, []byte("std"), []byte{0},
+				[]byte{qAutoIncrement, 1, 0, 1, 0},
+				charset,
+				[]byte{qCatalogNzCode, 3}, []byte("std"),
+				[]byte{qInvoker, 4}, []byte("root"), []byte{9}, []byte("localhost"),
+				[]byte{qUpdatedDbNames, 2}, []byte("db1\x00db2\x00"),
+				[]byte{qMicroseconds, 0, 0, 0},
+				[]byte{qDdlLoggedWithXid}, u64(1),
+				[]byte{qDefaultCollationForUtf8mb4}, u16(255),
+				[]byte{qSqlRequirePrimaryKey, 0},
+				timeZone,
+			),
+			want: Session{
+				SqlMode: "ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES", HasSqlMode: true,
+				CharsetClient: "utf8mb4", CollationConnection: "utf8mb4_general_ci", CollationServer: "latin1_swedish_ci",
+				TimeZone: "+08:00",
+			},
+		},
+		{
+			name: "超过16个库时没有库名",
+			vars: join([]byte{qUpdatedDbNames, 254}, timeZone),
+			want: Session{TimeZone: "+08:00"},
+		},
+		{
+			name: "未知的类型停止解析",
+			vars: join(sqlMode, []byte{100, 1, 2}, timeZone),
+			want: Session{SqlMode: "ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES", HasSqlMode: true},
+		},
+		{
+			name: "sql_mode不完整",
+			vars: []byte{qSqlModeCode, 1, 2},
+		},
+		{
+			name: "字符集不完整",
+			vars: join(sqlMode, []byte{qCharsetCode, 45, 0}),
+			want: Session{SqlMode: "ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES", HasSqlMode: true},
+		},
+		{
+			name: "时区不完整",
+			vars: []byte{qTimeZoneCode, 6, '+'},
+		},
+		{
+			name: "invoker不完整",
+			vars: join([]byte{qInvoker, 4}, []byte("root")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newSession(tt.vars); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newSession() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		session Session
+		want    []string
+	}{
+		{name: "没有记录"},
+		{name: "sql_mode为空", session: Session{HasSqlMode: true}, want: []string{"SET @@session.sql_mode=''"}},
+		{
+			name: "全部变量",
+			session: Session{
+				SqlMode: "STRICT_TRANS_TABLES", HasSqlMode: true,
+				CharsetClient: "utf8mb4", CollationConnection: "utf8mb4_general_ci", CollationServer: "latin1_swedish_ci",
+				TimeZone: "Asia/Shanghai",
+			},
+			want: []string{
+				"SET @@session.sql_mode='STRICT_TRANS_TABLES'",
+				"SET @@session.character_set_client=utf8mb4, @@session.collation_connection=utf8mb4_general_ci, @@session.collation_server=latin1_swedish_ci",
+				"SET @@session.time_zone='Asia/Shanghai'",
+			},
+		},
+		{name: "时区转义", session: Session{TimeZone: "a'b"}, want: []string{"SET @@session.time_zone='a''b'"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.session.Statements(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Statements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package event
+
+import (
+	"github.com/dhbin/ra/binlog/ddl"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+// Sink 接收结构化的变更记录，sql、jsonl、csv等输出格式都是Sink的实现
+type Sink interface {
+	// Begin 事务开始，在事务的第一行变更之前调用
+	Begin(tx *Transaction) error
+	// Row 行变更
+	Row(change *RowChange) error
+	// DDL ddl语句
+	DDL(ddl *DDLChange) error
+	// Commit 事务结束，此时Transaction.XID已经填充
+	Commit(tx *Transaction) error
+	// Close 输出剩余数据并释放资源
+	Close() error
+}
+
+// Position 变更在binlog中的位置
+type Position struct {
+	// File binlog文件名
+	File string
+	// Pos 事件的结束位置，与sql注释中的pos一致
+	Pos uint32
+	// EventPos 事件的起始位置
+	EventPos  uint32
+	Timestamp uint32
+	ServerId  uint32
+}
+
+// Transaction 事务边界
+type Transaction struct {
+	Position
+	GTID string
+	// XID 本地解析时可以获取，Commit之前为0
+	XID    uint64
+	Thread uint32
+}
+
+// RowChange 单行数据变更，flashback时为反向变更
+type RowChange struct {
+	Position
+	Tx     *Transaction
+	Table  *schema.Table
+	Action string
+	// Before 变更前的行数据，insert时为nil
+	Before []interface{}
+	// After 变更后的行数据，delete时为nil
+	After []interface{}
+	// Row 在所属行事件中的序号
+	Row int
+}
+
+// DDLChange ddl语句
+type DDLChange struct {
+	Position
+	// Schema 执行ddl时的默认库，可能为空
+	Schema string
+	Query  string
+	GTID   string
+	// Session 执行ddl时的会话变量
+	Session Session
+	// Flashback 闪回时为true，Query为原始ddl
+	Flashback bool
+	// Reverse 闪回时的反向ddl，没有要求输出ddl时为nil
+	Reverse *ddl.Reverse
+}
+
+// Discarder Sink可选实现，解析中断或出错时丢弃未完成事务中已接收的行变更
+type Discarder interface {
+	Discard(tx *Transaction) error
+}
+
+// Checkpointer Sink可选实现，每个事务、ddl处理完成后调用，被过滤条件排除、没有写入Sink的事务也会调用。
+// gtid为该事务的gtid，没有开启gtid时为空
+type Checkpointer interface {
+	Checkpoint(pos Position, gtid string) error
+}
+
+// XIDSetter 可以接收事务xid的handler，本地解析时在OnXID之前调用
+type XIDSetter interface {
+	SetXID(xid uint64)
+}
+
+func newPosition(file string, header *replication.EventHeader) Position {
+	return Position{
+		File:      file,
+		Pos:       header.LogPos,
+		EventPos:  header.LogPos - header.EventSize,
+		Timestamp: header.Timestamp,
+		ServerId:  header.ServerID,
+	}
+}
+
+// newRowChanges 将行事件转换为行变更，flashback为true时生成反向变更
+func newRowChanges(tx *Transaction, pos Position, e *canal.RowsEvent, flashback bool) []*RowChange {
+	var changes []*RowChange
+	add := func(action string, before []interface{}, after []interface{}) {
+		changes = append(changes, &RowChange{
+			Position: pos,
+			Tx:       tx,
+			Table:    e.Table,
+			Action:   action,
+			Before:   before,
+			After:    after,
+			Row:      len(changes),
+		})
+	}
+
+	switch e.Action {
+	case canal.InsertAction:
+		for _, row := range e.Rows {
+			if flashback {
+				add(canal.DeleteAction, row, nil)
+			} else {
+				add(canal.InsertAction, nil, row)
+			}
+		}
+	case canal.UpdateAction:
+		for i := 0; i+1 < len(e.Rows); i += 2 {
+			if flashback {
+				add(canal.UpdateAction, e.Rows[i+1], e.Rows[i])
+			} else {
+				add(canal.UpdateAction, e.Rows[i], e.Rows[i+1])
+			}
+		}
+	case canal.DeleteAction:
+		for _, row := range e.Rows {
+			if flashback {
+				add(canal.InsertAction, nil, row)
+			} else {
+				add(canal.DeleteAction, row, nil)
+			}
+		}
+	}
+	return changes
+}
+
+// BeforeImage 以字段名为key的变更前数据
+func (c *RowChange) BeforeImage() map[string]interface{} {
+	return image(c.Table, c.Before)
+}
+
+// AfterImage 以字段名为key的变更后数据
+func (c *RowChange) AfterImage() map[string]interface{} {
+	return image(c.Table, c.After)
+}
+
+func image(table *schema.Table, row []interface{}) map[string]interface{} {
+	if row == nil || len(row) != len(table.Columns) {
+		return nil
+	}
+	m := make(map[string]interface{}, len(row))
+	for i := range table.Columns {
+		m[table.Columns[i].Name] = row[i]
+	}
+	return m
+}
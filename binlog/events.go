@@ -0,0 +1,201 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dhbin/ra/binlog/ddl"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// FormatTable 表格输出
+	FormatTable = "table"
+	// FormatJson 每个事件输出一个json对象
+	FormatJson = "json"
+)
+
+// EventInfo binlog事件概要，与SHOW BINLOG EVENTS类似
+type EventInfo struct {
+	File      string `json:"file"`
+	Type      string `json:"type"`
+	Pos       uint32 `json:"pos"`
+	EndPos    uint32 `json:"end_pos"`
+	Timestamp uint32 `json:"timestamp"`
+	ServerId  uint32 `json:"server_id"`
+	// GTID 事件所属事务的gtid
+	GTID  string `json:"gtid,omitempty"`
+	Table string `json:"table,omitempty"`
+	Rows  int    `json:"rows,omitempty"`
+	Info  string `json:"info,omitempty"`
+}
+
+// tableFlushRows 表格输出每多少行对齐输出一次
+const tableFlushRows = 1000
+
+// Events 列出解析范围内的binlog事件，过滤条件与tosql相同，表过滤只作用于table map、行事件和ddl
+func Events(ctx context.Context, config *config.BinlogConfig) error {
+	var write func(info *EventInfo) error
+	var flush func() error
+	out, err := config.GetOut()
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	switch config.Format {
+	case FormatTable:
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "FILE\tPOS\tEND_POS\tTYPE\tSERVER_ID\tTIME\tGTID\tTABLE\tROWS\tINFO")
+		n := 0
+		write = func(info *EventInfo) error {
+			rows := ""
+			if info.Rows > 0 {
+				rows = fmt.Sprint(info.Rows)
+			}
+			_, err := fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", info.File, info.Pos, info.EndPos, info.Type, info.ServerId,
+				time.Unix(int64(info.Timestamp), 0).Format("2006-01-02 15:04:05"), info.GTID, info.Table, rows, shorten(info.Info, 80))
+			if err != nil {
+				return err
+			}
+			if n++; n%tableFlushRows == 0 {
+				return w.Flush()
+			}
+			return nil
+		}
+		flush = w.Flush
+	case FormatJson:
+		encoder := json.NewEncoder(out)
+		encoder.SetEscapeHTML(false)
+		write = func(info *EventInfo) error {
+			return encoder.Encode(info)
+		}
+		flush = func() error { return nil }
+	default:
+		return fmt.Errorf("不支持的输出格式: %s，支持%s,%s", config.Format, FormatTable, FormatJson)
+	}
+
+	filter, err := newTableFilter(config)
+	if err != nil {
+		return err
+	}
+	lister := &eventLister{filter: filter, write: write}
+	err = eachEvent(ctx, config, lister.event)
+	if flushErr := flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// eventLister 输出满足过滤条件的事件，并填充事件所属事务的gtid
+type eventLister struct {
+	filter *tableFilter
+	write  func(info *EventInfo) error
+	// gtid 当前事务的gtid，事务结束后清空，之后的rotate等事件不属于该事务
+	gtid string
+}
+
+func (l *eventLister) event(file string, ev *replication.BinlogEvent) error {
+	if g, ok := gtidString(ev); ok {
+		l.gtid = g
+	}
+	if info, ok := newEventInfo(file, ev, l.filter); ok {
+		info.GTID = l.gtid
+		if err := l.write(info); err != nil {
+			return err
+		}
+	}
+	if transactionEnd(ev) {
+		l.gtid = ""
+	}
+	return nil
+}
+
+// transactionEnd xid事件、XA PREPARE、ddl和COMMIT语句结束事务，BEGIN、SAVEPOINT、XA END等语句不结束事务
+func transactionEnd(ev *replication.BinlogEvent) bool {
+	switch e := ev.Event.(type) {
+	case *replication.XIDEvent:
+		return true
+	case *replication.QueryEvent:
+		query := string(e.Query)
+		if strings.EqualFold(strings.TrimSpace(query), "BEGIN") {
+			return false
+		}
+		return !ddl.IsTransactionControl(query) || ddl.EndsTransaction(query)
+	}
+	return ev.Header.EventType == replication.XA_PREPARE_LOG_EVENT
+}
+
+// newEventInfo 事件不满足过滤条件时返回false
+func newEventInfo(file string, ev *replication.BinlogEvent, filter *tableFilter) (*EventInfo, bool) {
+	header := ev.Header
+	info := &EventInfo{
+		File:      file,
+		Type:      header.EventType.String(),
+		Pos:       header.LogPos - header.EventSize,
+		EndPos:    header.LogPos,
+		Timestamp: header.Timestamp,
+		ServerId:  header.ServerID,
+	}
+	switch e := ev.Event.(type) {
+	case *replication.FormatDescriptionEvent:
+		info.Info = fmt.Sprintf("Server ver: %s, Binlog ver: %d", strings.TrimRight(string(e.ServerVersion), "\x00"), e.Version)
+	case *replication.RotateEvent:
+		info.Info = fmt.Sprintf("%s;pos=%d", e.NextLogName, e.Position)
+	case *replication.QueryEvent:
+		query := string(e.Query)
+		if !strings.EqualFold(query, "BEGIN") && !strings.EqualFold(query, "COMMIT") &&
+			filter.cfg.Database != "" && len(e.Schema) != 0 && string(e.Schema) != filter.cfg.Database {
+			return nil, false
+		}
+		info.Info = query
+	case *replication.XIDEvent:
+		info.Info = fmt.Sprintf("COMMIT /* xid=%d */", e.XID)
+	case *replication.GTIDEvent, *replication.MariadbGTIDEvent:
+		gtid, _ := gtidString(ev)
+		info.Info = gtid
+	case *replication.TableMapEvent:
+		if !filter.Table(string(e.Schema), string(e.Table)) {
+			return nil, false
+		}
+		info.Table = string(e.Schema) + "." + string(e.Table)
+		info.Info = fmt.Sprintf("table_id: %d", e.TableID)
+	case *replication.RowsEvent:
+		if !filter.Rows(ev) {
+			return nil, false
+		}
+		info.Table = string(e.Table.Schema) + "." + string(e.Table.Table)
+		info.Rows = rowCount(ev)
+		info.Info = fmt.Sprintf("table_id: %d", e.TableID)
+	}
+	return info, true
+}
+
+// shorten 表格输出时截断过长的内容，换行替换为空格
+func shorten(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"reflect"
+	"testing"
+)
+
+func testEvent(eventType replication.EventType, pos uint32, e replication.Event) *replication.BinlogEvent {
+	return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: eventType, LogPos: pos, EventSize: 10}, Event: e}
+}
+
+func testRowsEvent(eventType replication.EventType, pos uint32, db string, table string, rows int) *replication.BinlogEvent {
+	e := &replication.RowsEvent{Table: &replication.TableMapEvent{Schema: []byte(db), Table: []byte(table)}, Rows: make([][]interface{}, rows)}
+	return testEvent(eventType, pos, e)
+}
+
+func TestNewEventInfo(t *testing.T) {
+	cfg := &config.BinlogConfig{Database: "test", Tables: []string{"user"}, SqlTypes: []string{canal.InsertAction, canal.UpdateAction}}
+	filter, err := newTableFilter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		ev    *replication.BinlogEvent
+		want  bool
+		table string
+		rows  int
+		info  string
+	}{
+		{name: "BEGIN不过滤", ev: testEvent(replication.QUERY_EVENT, 100, &replication.QueryEvent{Schema: []byte("other"), Query: []byte("BEGIN")}), want: true, info: "BEGIN"},
+		{name: "其它库的ddl", ev: testEvent(replication.QUERY_EVENT, 100, &replication.QueryEvent{Schema: []byte("other"), Query: []byte("DROP TABLE t")})},
+		{name: "没有默认库的ddl", ev: testEvent(replication.QUERY_EVENT, 100, &replication.QueryEvent{Query: []byte("DROP TABLE other.t")}), want: true, info: "DROP TABLE other.t"},
+		{name: "xid", ev: testEvent(replication.XID_EVENT, 100, &replication.XIDEvent{XID: 7}), want: true, info: "COMMIT /* xid=7 */"},
+		{
+			name: "选中的table map",
+			ev:   testEvent(replication.TABLE_MAP_EVENT, 100, &replication.TableMapEvent{TableID: 5, Schema: []byte("test"), Table: []byte("user")}),
+			want: true, table: "test.user", info: "table_id: 5",
+		},
+		{name: "其它表的table map", ev: testEvent(replication.TABLE_MAP_EVENT, 100, &replication.TableMapEvent{Schema: []byte("test"), Table: []byte("user2")})},
+		{name: "insert", ev: testRowsEvent(replication.WRITE_ROWS_EVENTv2, 100, "test", "user", 3), want: true, table: "test.user", rows: 3, info: "table_id: 0"},
+		{name: "update按行对计数", ev: testRowsEvent(replication.UPDATE_ROWS_EVENTv2, 100, "test", "user", 4), want: true, table: "test.user", rows: 2, info: "table_id: 0"},
+		{name: "没有选中的sql类型", ev: testRowsEvent(replication.DELETE_ROWS_EVENTv2, 100, "test", "user", 1)},
+		{name: "其它表的行事件", ev: testRowsEvent(replication.WRITE_ROWS_EVENTv2, 100, "other", "user", 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, ok := newEventInfo("mysql-bin.000001", tt.ev, filter)
+			if ok != tt.want {
+				t.Fatalf("newEventInfo() ok = %v, want %v", ok, tt.want)
+			}
+			if !ok {
+				return
+			}
+			if info.Pos != 90 || info.EndPos != 100 || info.Table != tt.table || info.Rows != tt.rows || info.Info != tt.info {
+				t.Errorf("newEventInfo() = %+v", info)
+			}
+		})
+	}
+}
+
+func TestEventListerGTID(t *testing.T) {
+	filter, err := newTableFilter(&config.BinlogConfig{SqlTypes: []string{canal.InsertAction}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	l := &eventLister{filter: filter, write: func(info *EventInfo) error {
+		got = append(got, info.Type+"="+info.GTID)
+		return nil
+	}}
+	gtid := &replication.MariadbGTIDEvent{GTID: mysql.MariadbGTID{DomainID: 0, ServerID: 1, SequenceNumber: 5}}
+	ddlGTID := &replication.MariadbGTIDEvent{GTID: mysql.MariadbGTID{DomainID: 0, ServerID: 1, SequenceNumber: 6}}
+	events := []*replication.BinlogEvent{
+		testEvent(replication.MARIADB_GTID_EVENT, 100, gtid),
+		testEvent(replication.QUERY_EVENT, 200, &replication.QueryEvent{Query: []byte("BEGIN")}),
+		testRowsEvent(replication.WRITE_ROWS_EVENTv1, 300, "test", "t", 1),
+		// 事务中的savepoint不结束事务
+		testEvent(replication.QUERY_EVENT, 330, &replication.QueryEvent{Query: []byte("SAVEPOINT sp1")}),
+		testRowsEvent(replication.WRITE_ROWS_EVENTv1, 360, "test", "t", 1),
+		testEvent(replication.XID_EVENT, 400, &replication.XIDEvent{}),
+		testEvent(replication.ROTATE_EVENT, 500, &replication.RotateEvent{NextLogName: []byte("mysql-bin.000002")}),
+		testEvent(replication.MARIADB_GTID_EVENT, 600, ddlGTID),
+		testEvent(replication.QUERY_EVENT, 700, &replication.QueryEvent{Query: []byte("CREATE TABLE t (id int)")}),
+		testEvent(replication.FORMAT_DESCRIPTION_EVENT, 800, &replication.FormatDescriptionEvent{}),
+	}
+	for _, ev := range events {
+		if err := l.event("mysql-bin.000001", ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"MariadbGTIDEvent=0-1-5", "QueryEvent=0-1-5", "WriteRowsEventV1=0-1-5", "QueryEvent=0-1-5", "WriteRowsEventV1=0-1-5", "XIDEvent=0-1-5", "RotateEvent=",
+		"MariadbGTIDEvent=0-1-6", "QueryEvent=0-1-6", "FormatDescriptionEvent=",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
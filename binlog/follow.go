@@ -0,0 +1,104 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"context"
+	"errors"
+	"github.com/dhbin/ra/binlog/parse"
+	"github.com/dhbin/ra/binlog/sink"
+	"github.com/dhbin/ra/config"
+	"io"
+	"os"
+)
+
+// follow 持续解析数据库新写入的binlog，每个事务后记录位置到状态文件，重启后从状态文件的位置继续
+func follow(ctx context.Context, config *config.BinlogConfig) error {
+	if config.Local {
+		return errors.New("follow不支持解析本地binlog")
+	}
+	if config.StopPosition != 0 || config.StopDatetime != nil {
+		return errors.New("follow不支持指定终止位置、终止时间")
+	}
+	checkpoint, err := resume(config)
+	if err != nil {
+		return err
+	}
+
+	var out io.WriteCloser
+	var rotate *sink.RotateWriter
+	if config.RotateSize > 0 || config.RotateInterval > 0 {
+		if config.Out == "" {
+			return errors.New("切换输出文件需要指定out参数")
+		}
+		rotate, err = sink.NewRotateWriter(config.Out, config.RotateSize*1024*1024, config.RotateInterval)
+		if err != nil {
+			return err
+		}
+		out = rotate
+	} else if checkpoint != nil && config.Out != "" {
+		// 从状态文件继续时追加到之前的输出
+		out, err = os.OpenFile(config.Out, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+	} else {
+		out, err = config.GetOut()
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := newSink(config, out, false)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	flavor, err := parse.Flavor(config)
+	if err != nil {
+		_ = s.Close()
+		_ = out.Close()
+		return err
+	}
+	checkpointSink, err := sink.NewCheckpointSink(s, config.StateFile, flavor, checkpoint, rotate)
+	if err != nil {
+		_ = s.Close()
+		_ = out.Close()
+		return err
+	}
+	if rotate != nil {
+		// 由CheckpointSink关闭
+		out = nil
+	}
+	return runReader(ctx, newReader(config, false), checkpointSink, out)
+}
+
+// resume 状态文件存在时从记录的gtid集合之后开始解析，没有记录gtid集合时从file:pos开始
+func resume(config *config.BinlogConfig) (*sink.Checkpoint, error) {
+	if config.StateFile == "" {
+		return nil, nil
+	}
+	checkpoint, err := sink.ReadCheckpoint(config.StateFile)
+	if err != nil || checkpoint == nil {
+		return nil, err
+	}
+	config.StartBinlogName = checkpoint.File
+	config.StopBinlogName = checkpoint.File
+	config.StartPosition = checkpoint.Pos
+	config.StartGTIDSet = checkpoint.GTIDSet
+	return checkpoint, nil
+}
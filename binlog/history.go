@@ -0,0 +1,250 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// FormatText 文本输出
+const FormatText = "text"
+
+// HistoryOptions 查询单行数据的变更历史
+type HistoryOptions struct {
+	// Schema、Table 目标表
+	Schema string
+	Table  string
+	// PK 主键值，联合主键按主键字段顺序
+	PK []string
+	// At 不为nil时输出该时间的行数据
+	At *time.Time
+	// Format 支持text、json
+	Format string
+}
+
+// ColumnDiff update变更的字段
+type ColumnDiff struct {
+	Column string      `json:"column"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// HistoryChange 行的一次变更
+type HistoryChange struct {
+	File      string                 `json:"file"`
+	Pos       uint32                 `json:"pos"`
+	Timestamp uint32                 `json:"timestamp"`
+	GTID      string                 `json:"gtid,omitempty"`
+	Action    string                 `json:"action"`
+	Before    map[string]interface{} `json:"before"`
+	After     map[string]interface{} `json:"after"`
+	Diff      []*ColumnDiff          `json:"diff,omitempty"`
+
+	columns []string
+}
+
+// RowState 指定时间的行数据
+type RowState struct {
+	At int64 `json:"at"`
+	// Known 解析范围内是否有足够的变更确定该时间的数据
+	Known bool `json:"known"`
+	// Row 为nil时行不存在
+	Row map[string]interface{} `json:"row"`
+
+	columns []string
+}
+
+// History 按时间顺序输出单行数据在解析范围内的变更，以及指定时间的行数据
+func History(ctx context.Context, config *config.BinlogConfig, options *HistoryOptions) error {
+	if options.Format != FormatText && options.Format != FormatJson {
+		return fmt.Errorf("不支持的输出格式: %s，支持%s,%s", options.Format, FormatText, FormatJson)
+	}
+	config.Database = options.Schema
+	config.Tables = []string{options.Table}
+
+	out, err := config.GetOut()
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var changes []*HistoryChange
+	err = newReader(config, false).Each(ctx, func(c *event.RowChange) error {
+		if c.Table.Schema != options.Schema || c.Table.Name != options.Table {
+			return nil
+		}
+		match, err := matchPK(c, options.PK)
+		if err != nil || !match {
+			return err
+		}
+		change := newHistoryChange(c)
+		changes = append(changes, change)
+		return writeHistoryChange(out, change, options.Format)
+	})
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 && options.Format == FormatText {
+		_, _ = fmt.Fprintln(out, "# 解析范围内没有该行的变更")
+	}
+	if options.At == nil {
+		return nil
+	}
+	return writeRowState(out, rowStateAt(changes, *options.At), options.Format)
+}
+
+// matchPK 行变更是否为目标行，update修改了主键时变更前后任一匹配即可
+func matchPK(c *event.RowChange, pk []string) (bool, error) {
+	if len(c.Table.PKColumns) == 0 {
+		return false, fmt.Errorf("表%s.%s没有主键", c.Table.Schema, c.Table.Name)
+	}
+	if len(c.Table.PKColumns) != len(pk) {
+		return false, fmt.Errorf("表%s.%s的主键有%d个字段，pk指定了%d个值", c.Table.Schema, c.Table.Name, len(c.Table.PKColumns), len(pk))
+	}
+	return pkEqual(c.Table, c.Before, pk) || pkEqual(c.Table, c.After, pk), nil
+}
+
+func pkEqual(table *schema.Table, row []interface{}, pk []string) bool {
+	if row == nil {
+		return false
+	}
+	for i, idx := range table.PKColumns {
+		if idx >= len(row) || displayValue(row[idx]) != pk[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func newHistoryChange(c *event.RowChange) *HistoryChange {
+	change := &HistoryChange{
+		File:      c.File,
+		Pos:       c.Pos,
+		Timestamp: c.Timestamp,
+		GTID:      c.Tx.GTID,
+		Action:    c.Action,
+		Before:    c.BeforeImage(),
+		After:     c.AfterImage(),
+		columns:   make([]string, len(c.Table.Columns)),
+	}
+	for i := range c.Table.Columns {
+		change.columns[i] = c.Table.Columns[i].Name
+	}
+	if c.Before != nil && c.After != nil && len(c.Before) == len(c.After) {
+		for i := range c.Table.Columns {
+			if i < len(c.Before) && !reflect.DeepEqual(c.Before[i], c.After[i]) {
+				change.Diff = append(change.Diff, &ColumnDiff{Column: c.Table.Columns[i].Name, Before: c.Before[i], After: c.After[i]})
+			}
+		}
+	}
+	return change
+}
+
+// rowStateAt 指定时间的行数据为该时间之前最后一次变更后的数据，
+// 没有该时间之前的变更时为之后第一次变更前的数据
+func rowStateAt(changes []*HistoryChange, at time.Time) *RowState {
+	state := &RowState{At: at.Unix()}
+	for i := len(changes) - 1; i >= 0; i-- {
+		if int64(changes[i].Timestamp) <= at.Unix() {
+			state.Known = true
+			state.Row = changes[i].After
+			state.columns = changes[i].columns
+			return state
+		}
+	}
+	if len(changes) > 0 {
+		state.Known = true
+		state.Row = changes[0].Before
+		state.columns = changes[0].columns
+	}
+	return state
+}
+
+func writeHistoryChange(out io.Writer, change *HistoryChange, format string) error {
+	if format == FormatJson {
+		return writeJson(out, change)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s pos %d %s", change.File, change.Pos, time.Unix(int64(change.Timestamp), 0).Format("2006-01-02 15:04:05"))
+	if change.GTID != "" {
+		fmt.Fprintf(&b, " gtid %s", change.GTID)
+	}
+	fmt.Fprintf(&b, "\n%s\n", change.Action)
+	for _, diff := range change.Diff {
+		fmt.Fprintf(&b, "  %s: %s -> %s\n", diff.Column, displayValue(diff.Before), displayValue(diff.After))
+	}
+	if change.Before != nil {
+		fmt.Fprintf(&b, "  before: %s\n", displayImage(change.columns, change.Before))
+	}
+	if change.After != nil {
+		fmt.Fprintf(&b, "  after:  %s\n", displayImage(change.columns, change.After))
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func writeRowState(out io.Writer, state *RowState, format string) error {
+	if format == FormatJson {
+		return writeJson(out, state)
+	}
+	at := time.Unix(state.At, 0).Format("2006-01-02 15:04:05")
+	var err error
+	switch {
+	case !state.Known:
+		_, err = fmt.Fprintf(out, "# %s 时的数据: 无法确定，解析范围内没有该行的变更\n", at)
+	case state.Row == nil:
+		_, err = fmt.Fprintf(out, "# %s 时的数据: 不存在\n", at)
+	default:
+		_, err = fmt.Fprintf(out, "# %s 时的数据: %s\n", at, displayImage(state.columns, state.Row))
+	}
+	return err
+}
+
+func writeJson(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(v)
+}
+
+// displayImage 按字段顺序输出
+func displayImage(columns []string, image map[string]interface{}) string {
+	values := make([]string, len(columns))
+	for i, name := range columns {
+		values[i] = name + "=" + displayValue(image[name])
+	}
+	return strings.Join(values, ", ")
+}
+
+func displayValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(t)
+	default:
+		return fmt.Sprint(t)
+	}
+}
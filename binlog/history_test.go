@@ -0,0 +1,178 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"bytes"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func historyTable(pk ...int) *schema.Table {
+	return &schema.Table{
+		Schema:    "test",
+		Name:      "user",
+		Columns:   []schema.TableColumn{{Name: "id"}, {Name: "tenant"}, {Name: "name"}},
+		PKColumns: pk,
+	}
+}
+
+func TestMatchPK(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   *schema.Table
+		before  []interface{}
+		after   []interface{}
+		pk      []string
+		want    bool
+		wantErr bool
+	}{
+		{name: "insert", table: historyTable(0), after: []interface{}{int32(1), "a", "x"}, pk: []string{"1"}, want: true},
+		{name: "delete", table: historyTable(0), before: []interface{}{int32(1), "a", "x"}, pk: []string{"1"}, want: true},
+		{name: "其它行", table: historyTable(0), after: []interface{}{int32(2), "a", "x"}, pk: []string{"1"}},
+		{name: "update修改主键匹配变更前", table: historyTable(0), before: []interface{}{int32(1), "a", "x"}, after: []interface{}{int32(2), "a", "x"}, pk: []string{"1"}, want: true},
+		{name: "update修改主键匹配变更后", table: historyTable(0), before: []interface{}{int32(1), "a", "x"}, after: []interface{}{int32(2), "a", "x"}, pk: []string{"2"}, want: true},
+		{name: "联合主键", table: historyTable(1, 0), after: []interface{}{int32(1), "a", "x"}, pk: []string{"a", "1"}, want: true},
+		{name: "联合主键顺序", table: historyTable(1, 0), after: []interface{}{int32(1), "a", "x"}, pk: []string{"1", "a"}},
+		{name: "二进制主键", table: historyTable(1), after: []interface{}{int32(1), []byte("a"), "x"}, pk: []string{"a"}, want: true},
+		{name: "主键值个数不一致", table: historyTable(0, 1), after: []interface{}{int32(1), "a", "x"}, pk: []string{"1"}, wantErr: true},
+		{name: "没有主键", table: historyTable(), after: []interface{}{int32(1), "a", "x"}, pk: []string{"1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &event.RowChange{Table: tt.table, Before: tt.before, After: tt.after}
+			got, err := matchPK(c, tt.pk)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("matchPK() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("matchPK() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHistoryChange(t *testing.T) {
+	table := historyTable(0)
+	tx := &event.Transaction{GTID: "uuid:1"}
+	tests := []struct {
+		name   string
+		change *event.RowChange
+		want   []*ColumnDiff
+	}{
+		{
+			name:   "update只输出修改的字段",
+			change: &event.RowChange{Tx: tx, Table: table, Action: canal.UpdateAction, Before: []interface{}{int32(1), "a", "x"}, After: []interface{}{int32(1), "b", "x"}},
+			want:   []*ColumnDiff{{Column: "tenant", Before: "a", After: "b"}},
+		},
+		{
+			name:   "修改为NULL",
+			change: &event.RowChange{Tx: tx, Table: table, Action: canal.UpdateAction, Before: []interface{}{int32(1), "a", []byte("x")}, After: []interface{}{int32(1), "a", nil}},
+			want:   []*ColumnDiff{{Column: "name", Before: []byte("x"), After: nil}},
+		},
+		{
+			name:   "没有修改",
+			change: &event.RowChange{Tx: tx, Table: table, Action: canal.UpdateAction, Before: []interface{}{int32(1), "a", []byte("x")}, After: []interface{}{int32(1), "a", []byte("x")}},
+		},
+		{name: "insert没有diff", change: &event.RowChange{Tx: tx, Table: table, Action: canal.InsertAction, After: []interface{}{int32(1), "a", "x"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change.Position = event.Position{File: "mysql-bin.000001", Pos: 100, Timestamp: 1000}
+			got := newHistoryChange(tt.change)
+			if !reflect.DeepEqual(got.Diff, tt.want) {
+				t.Errorf("Diff = %+v, want %+v", got.Diff, tt.want)
+			}
+			if got.File != "mysql-bin.000001" || got.Pos != 100 || got.Timestamp != 1000 || got.GTID != "uuid:1" || got.Action != tt.change.Action {
+				t.Errorf("newHistoryChange() = %+v", got)
+			}
+			if !reflect.DeepEqual(got.columns, []string{"id", "tenant", "name"}) {
+				t.Errorf("columns = %v", got.columns)
+			}
+		})
+	}
+}
+
+func TestRowStateAt(t *testing.T) {
+	row := func(name string) map[string]interface{} {
+		return map[string]interface{}{"id": 1, "name": name}
+	}
+	// 100插入，200修改，300删除
+	changes := []*HistoryChange{
+		{Timestamp: 100, Action: canal.InsertAction, After: row("a")},
+		{Timestamp: 200, Action: canal.UpdateAction, Before: row("a"), After: row("b")},
+		{Timestamp: 300, Action: canal.DeleteAction, Before: row("b")},
+	}
+	// 之前已存在，200修改
+	updated := []*HistoryChange{{Timestamp: 200, Action: canal.UpdateAction, Before: row("a"), After: row("b")}}
+	tests := []struct {
+		name    string
+		changes []*HistoryChange
+		at      int64
+		known   bool
+		want    map[string]interface{}
+	}{
+		{name: "没有变更", at: 100},
+		{name: "插入之前不存在", changes: changes, at: 50, known: true},
+		{name: "插入时", changes: changes, at: 100, known: true, want: row("a")},
+		{name: "修改之前", changes: changes, at: 199, known: true, want: row("a")},
+		{name: "修改之后", changes: changes, at: 250, known: true, want: row("b")},
+		{name: "删除之后不存在", changes: changes, at: 1000, known: true},
+		{name: "第一次变更之前为变更前的数据", changes: updated, at: 100, known: true, want: row("a")},
+		{name: "最后一次变更之后", changes: updated, at: 1000, known: true, want: row("b")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rowStateAt(tt.changes, time.Unix(tt.at, 0))
+			if got.At != tt.at || got.Known != tt.known || !reflect.DeepEqual(got.Row, tt.want) {
+				t.Errorf("rowStateAt() = %+v, want known %v row %v", got, tt.known, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteRowState(t *testing.T) {
+	at := time.Date(2023, 4, 23, 16, 0, 0, 0, time.Local).Unix()
+	tests := []struct {
+		name  string
+		state *RowState
+		want  string
+	}{
+		{name: "无法确定", state: &RowState{At: at}, want: "# 2023-04-23 16:00:00 时的数据: 无法确定，解析范围内没有该行的变更\n"},
+		{name: "不存在", state: &RowState{At: at, Known: true}, want: "# 2023-04-23 16:00:00 时的数据: 不存在\n"},
+		{
+			name:  "按字段顺序",
+			state: &RowState{At: at, Known: true, Row: map[string]interface{}{"id": 1, "name": nil}, columns: []string{"id", "name"}},
+			want:  "# 2023-04-23 16:00:00 时的数据: id=1, name=NULL\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeRowState(&out, tt.state, FormatText); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("writeRowState() = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
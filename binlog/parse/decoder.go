@@ -0,0 +1,190 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// decodeBatchBytes 解码后的事件按批次交给回调，每批原始事件的大小
+	decodeBatchBytes = 1 << 20
+	// decodeBatchEvents 每批最多的事件数，被丢弃的事件只保留header
+	decodeBatchEvents = 8192
+	// decodeBufferBatches 每个文件最多提前解码的批次数，解码后的数据约为原始大小的数倍
+	decodeBufferBatches = 64
+)
+
+// EventFunc 处理解码后的事件，file为事件所在的binlog文件路径
+type EventFunc func(file string, ev *replication.BinlogEvent) error
+
+// Decoder 同时解码多个本地binlog文件，按文件顺序依次回调。
+// 每个文件的事件只依赖文件内的format description、table map事件，可以独立解码；
+// 表结构跟踪等依赖之前事件的处理在回调中按binlog顺序执行
+type Decoder struct {
+	// Files 按顺序解析的binlog文件
+	Files []string
+	// Offset 第一个文件的起始位置，之后的文件从头开始
+	Offset int64
+	// Workers 同时解码的文件数，小于1时为1
+	Workers int
+	// Flavor 数据库类型，mysql或mariadb，为空时为mysql
+	Flavor string
+	// Keep 在解码的goroutine中丢弃不需要的事件内容，为nil时保留所有事件。
+	// 被丢弃的事件只保留header，仍然传给回调，用于记录位置和进度。
+	// 只能根据事件本身判断，不能依赖回调的处理结果
+	Keep func(ev *replication.BinlogEvent) bool
+}
+
+// decodedFile 一个文件解码出的事件，batches关闭后err为解码的错误
+type decodedFile struct {
+	path    string
+	batches chan []*replication.BinlogEvent
+	err     error
+}
+
+// Run 解码所有文件直到结束、ctx取消或fn返回错误，fn返回的错误原样返回
+func (d *Decoder) Run(ctx context.Context, fn EventFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	// 正在解码的文件占用一个位置，文件解码结束后释放，同时解码的文件数不超过workers。
+	// 每个文件解码后的事件最多缓存decodeBufferBatches批，缓存满时该文件的解码等待回调处理
+	slots := make(chan struct{}, workers)
+	queue := make(chan *decodedFile, len(d.Files))
+	go func() {
+		defer close(queue)
+		for i, path := range d.Files {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			f := &decodedFile{path: path, batches: make(chan []*replication.BinlogEvent, decodeBufferBatches)}
+			offset := int64(0)
+			if i == 0 {
+				offset = d.Offset
+			}
+			go func() {
+				defer func() { <-slots }()
+				f.decode(ctx, offset, d.Flavor, d.Keep)
+			}()
+			queue <- f
+		}
+	}()
+
+	for f := range queue {
+		for batch := range f.batches {
+			for _, ev := range batch {
+				if err := fn(f.path, ev); err != nil {
+					return err
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if f.err != nil {
+			return fmt.Errorf("解析%s失败: %w", f.path, f.err)
+		}
+	}
+	return ctx.Err()
+}
+
+func (f *decodedFile) decode(ctx context.Context, offset int64, flavor string, keep func(ev *replication.BinlogEvent) bool) {
+	defer close(f.batches)
+	var batch []*replication.BinlogEvent
+	size := 0
+	send := func() error {
+		select {
+		case f.batches <- batch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		batch, size = nil, 0
+		return nil
+	}
+	parser := replication.NewBinlogParser()
+	parser.SetFlavor(flavor)
+	err := parser.ParseFile(f.path, offset, func(ev *replication.BinlogEvent) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if keep != nil && !keep(ev) {
+			ev = &replication.BinlogEvent{Header: ev.Header}
+		}
+		batch = append(batch, ev)
+		size += len(ev.RawData)
+		if size < decodeBatchBytes && len(batch) < decodeBatchEvents {
+			return nil
+		}
+		return send()
+	})
+	if err == nil && len(batch) > 0 {
+		err = send()
+	}
+	f.err = err
+}
+
+// LocalFiles start所在目录中从start到stop的binlog文件，stop只取文件名，为空时只解析start
+func LocalFiles(start string, stop string) ([]string, error) {
+	dir, startName := filepath.Split(start)
+	stopName := filepath.Base(stop)
+	if stop == "" || stopName == startName {
+		return []string{start}, nil
+	}
+	prefix, first, width, ok := splitBinlogName(startName)
+	stopPrefix, last, _, stopOk := splitBinlogName(stopName)
+	if !ok || !stopOk || prefix != stopPrefix {
+		return nil, fmt.Errorf("终止文件%s与起始文件%s不是同一组binlog文件", stopName, startName)
+	}
+	if last < first {
+		return nil, fmt.Errorf("终止文件%s在起始文件%s之前", stopName, startName)
+	}
+	files := []string{start}
+	for seq := first + 1; seq <= last; seq++ {
+		path := filepath.Join(dir, fmt.Sprintf("%s.%0*d", prefix, width, seq))
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("缺少binlog文件: %w", err)
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// splitBinlogName 将mysql-bin.000001拆分为前缀、序号以及序号的位数
+func splitBinlogName(name string) (string, int, int, bool) {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return "", 0, 0, false
+	}
+	seq, err := strconv.Atoi(name[i+1:])
+	if err != nil || seq < 0 {
+		return "", 0, 0, false
+	}
+	return name[:i], seq, len(name) - i - 1, true
+}
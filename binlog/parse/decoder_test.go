@@ -0,0 +1,249 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// binlogWriter 生成测试用的binlog文件，表test.user(id int, name varchar(100))
+type binlogWriter struct {
+	buf       bytes.Buffer
+	timestamp uint32
+}
+
+func (w *binlogWriter) event(eventType replication.EventType, body []byte) {
+	size := uint32(replication.EventHeaderSize + len(body))
+	header := make([]byte, replication.EventHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:], w.timestamp)
+	header[4] = byte(eventType)
+	binary.LittleEndian.PutUint32(header[5:], 1)
+	binary.LittleEndian.PutUint32(header[9:], size)
+	binary.LittleEndian.PutUint32(header[13:], uint32(w.buf.Len())+size)
+	w.buf.Write(header)
+	w.buf.Write(body)
+	w.timestamp++
+}
+
+// formatDescription 5.5版本的format description，不带checksum
+func (w *binlogWriter) formatDescription() {
+	w.buf.Write(replication.BinLogFileHeader)
+	body := binary.LittleEndian.AppendUint16(nil, 4)
+	version := make([]byte, 50)
+	copy(version, "5.5.50-log")
+	body = append(body, version...)
+	body = binary.LittleEndian.AppendUint32(body, 0)
+	body = append(body, replication.EventHeaderSize)
+	// 各类型事件的post header长度
+	postHeader := make([]byte, 27)
+	postHeader[replication.QUERY_EVENT-1] = 13
+	postHeader[replication.ROTATE_EVENT-1] = 8
+	postHeader[replication.FORMAT_DESCRIPTION_EVENT-1] = 84
+	postHeader[replication.TABLE_MAP_EVENT-1] = 8
+	postHeader[replication.WRITE_ROWS_EVENTv1-1] = 8
+	w.event(replication.FORMAT_DESCRIPTION_EVENT, append(body, postHeader...))
+}
+
+func (w *binlogWriter) query(query string) {
+	body := binary.LittleEndian.AppendUint32(nil, 1)
+	body = binary.LittleEndian.AppendUint32(body, 0)
+	body = append(body, 4, 0, 0, 0, 0)
+	body = append(body, "test\x00"+query...)
+	w.event(replication.QUERY_EVENT, body)
+}
+
+// insert 一个事务插入rows行
+func (w *binlogWriter) insert(id uint32, rows int) {
+	w.query("BEGIN")
+	tableMap := []byte{1, 0, 0, 0, 0, 0, 1, 0}
+	tableMap = append(tableMap, "\x04test\x00\x04user\x00"...)
+	tableMap = append(tableMap, 2, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, 2, 100, 0, 0x02)
+	w.event(replication.TABLE_MAP_EVENT, tableMap)
+	body := []byte{1, 0, 0, 0, 0, 0, 1, 0, 2, 0x03}
+	for i := 0; i < rows; i++ {
+		name := fmt.Sprintf("name%d", id)
+		body = append(body, 0)
+		body = binary.LittleEndian.AppendUint32(body, id)
+		body = append(body, byte(len(name)))
+		body = append(body, name...)
+		id++
+	}
+	w.event(replication.WRITE_ROWS_EVENTv1, body)
+	w.event(replication.XID_EVENT, binary.LittleEndian.AppendUint64(nil, uint64(id)))
+}
+
+func (w *binlogWriter) rotate(next string) {
+	w.event(replication.ROTATE_EVENT, append(binary.LittleEndian.AppendUint64(nil, 4), next...))
+}
+
+// writeBinlogFiles 在dir中生成count个binlog文件，每个文件txs个事务，返回文件路径
+func writeBinlogFiles(t testing.TB, dir string, count int, txs int) []string {
+	t.Helper()
+	var files []string
+	for n := 1; n <= count; n++ {
+		w := &binlogWriter{timestamp: uint32(1682237000 + n*100000)}
+		w.formatDescription()
+		for i := 0; i < txs; i++ {
+			w.insert(uint32(n*1000000+i*10), 10)
+		}
+		w.rotate(fmt.Sprintf("mysql-bin.%06d", n+1))
+		path := filepath.Join(dir, fmt.Sprintf("mysql-bin.%06d", n))
+		if err := os.WriteFile(path, w.buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	return files
+}
+
+// TestDecoder 同时解码多个文件时按文件顺序回调
+func TestDecoder(t *testing.T) {
+	files := writeBinlogFiles(t, t.TempDir(), 4, 100)
+	decode := func(workers int) []string {
+		var events []string
+		decoder := &Decoder{Files: files, Offset: 4, Workers: workers}
+		err := decoder.Run(context.Background(), func(file string, ev *replication.BinlogEvent) error {
+			row := ""
+			if rows, ok := ev.Event.(*replication.RowsEvent); ok {
+				row = fmt.Sprint(rows.Rows[0][0])
+			}
+			events = append(events, fmt.Sprintf("%s:%d %s %s", filepath.Base(file), ev.Header.LogPos, ev.Header.EventType, row))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return events
+	}
+	want := decode(1)
+	// 每个文件format description、100个事务各4个事件、rotate
+	if len(want) != 4*(1+100*4+1) {
+		t.Fatalf("events = %d", len(want))
+	}
+	for _, workers := range []int{0, 2, 8} {
+		if got := decode(workers); !reflect.DeepEqual(got, want) {
+			t.Errorf("workers %d: events not in binlog order", workers)
+		}
+	}
+}
+
+// BenchmarkDecoder 比较--parallel 1与多个文件同时解码
+func BenchmarkDecoder(b *testing.B) {
+	files := writeBinlogFiles(b, b.TempDir(), 8, 10000)
+	var size int64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		size += info.Size()
+	}
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("parallel-%d", workers), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				decoder := &Decoder{Files: files, Offset: 4, Workers: workers}
+				err := decoder.Run(context.Background(), func(string, *replication.BinlogEvent) error {
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"mysql-bin.000001", "mysql-bin.000002", "mysql-bin.000003", "mysql-bin.000005", "relay.000002", "mysql-bin.index"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(names ...string) []string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+		return paths
+	}
+	start := filepath.Join(dir, "mysql-bin.000001")
+	tests := []struct {
+		name    string
+		start   string
+		stop    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "没有终止文件", start: start, want: path("mysql-bin.000001")},
+		{name: "终止文件与起始文件相同", start: start, stop: "mysql-bin.000001", want: path("mysql-bin.000001")},
+		{name: "多个文件", start: start, stop: "mysql-bin.000003", want: path("mysql-bin.000001", "mysql-bin.000002", "mysql-bin.000003")},
+		{name: "终止文件只取文件名", start: start, stop: "/other/mysql-bin.000002", want: path("mysql-bin.000001", "mysql-bin.000002")},
+		{name: "缺少中间的文件", start: start, stop: "mysql-bin.000005", wantErr: true},
+		{name: "终止文件在起始文件之前", start: filepath.Join(dir, "mysql-bin.000003"), stop: "mysql-bin.000002", wantErr: true},
+		{name: "不是同一组文件", start: start, stop: "relay.000002", wantErr: true},
+		{name: "没有序号", start: start, stop: "mysql-bin.index", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LocalFiles(tt.start, tt.stop)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LocalFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LocalFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitBinlogName(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		seq    int
+		width  int
+		ok     bool
+	}{
+		{name: "mysql-bin.000001", prefix: "mysql-bin", seq: 1, width: 6, ok: true},
+		{name: "my.bin.1000000", prefix: "my.bin", seq: 1000000, width: 7, ok: true},
+		{name: "binlog.000000", prefix: "binlog", seq: 0, width: 6, ok: true},
+		{name: "mysql-bin"},
+		{name: "mysql-bin.index"},
+		{name: "mysql-bin.-1"},
+		{name: "mysql-bin."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, seq, width, ok := splitBinlogName(tt.name)
+			if prefix != tt.prefix || seq != tt.seq || width != tt.width || ok != tt.ok {
+				t.Errorf("splitBinlogName(%q) = %q, %d, %d, %v, want %q, %d, %d, %v",
+					tt.name, prefix, seq, width, ok, tt.prefix, tt.seq, tt.width, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dhbin/ra/binlog/catalog"
+	"github.com/dhbin/ra/binlog/ddl"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"github.com/google/uuid"
+	"github.com/pingcap/tidb/parser/ast"
+	"os"
+	"regexp"
+)
+
+// Parser 将binlog事件转换为canal.EventHandler的回调，本地解析和远程解析共用。
+// 表结构从provider获取，provider实现了catalog.Observer时传入table map事件和ddl
+type Parser struct {
+	config   *config.BinlogConfig
+	provider catalog.Provider
+	include  []*regexp.Regexp
+	pos      mysql.Position
+	// skipped 找不到表结构被跳过的表，只提示一次
+	skipped map[string]bool
+	// progress 每个事件处理前调用
+	progress func(file string, header *replication.EventHeader)
+}
+
+func newParser(config *config.BinlogConfig, provider catalog.Provider) (*Parser, error) {
+	p := &Parser{config: config, provider: provider, skipped: make(map[string]bool)}
+	for _, expr := range IncludeTableRegex(config) {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("表过滤条件格式错误: %w", err)
+		}
+		p.include = append(p.include, re)
+	}
+	return p, nil
+}
+
+// IncludeTableRegex 需要解析的表，匹配db.table，为nil时解析所有表
+func IncludeTableRegex(config *config.BinlogConfig) []string {
+	if config.Database != "" {
+		if len(config.Tables) == 0 {
+			return []string{config.Database + "\\..*"}
+		}
+		includeTableRegex := make([]string, len(config.Tables))
+		for i, table := range config.Tables {
+			includeTableRegex[i] = config.Database + "\\." + table + "$"
+		}
+		return includeTableRegex
+	}
+	if len(config.Tables) != 0 {
+		includeTableRegex := make([]string, len(config.Tables))
+		for i, table := range config.Tables {
+			includeTableRegex[i] = ".*\\." + table + "$"
+		}
+		return includeTableRegex
+	}
+	return nil
+}
+
+// included 是否解析该表
+func (p *Parser) included(db string, table string) bool {
+	if len(p.include) == 0 {
+		return true
+	}
+	key := db + "." + table
+	for _, re := range p.include {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetProgress 每个事件处理前传入事件所在的文件和header，用于输出解析进度
+func (p *Parser) SetProgress(fn func(file string, header *replication.EventHeader)) {
+	p.progress = fn
+}
+
+// keep 不需要解析的表的行事件在解码时丢弃内容，只根据表名判断，可以在解码的goroutine中执行
+func (p *Parser) keep(ev *replication.BinlogEvent) bool {
+	if e, ok := ev.Event.(*replication.RowsEvent); ok && e.Table != nil {
+		return p.included(string(e.Table.Schema), string(e.Table.Table))
+	}
+	return true
+}
+
+func (p *Parser) handle(ev *replication.BinlogEvent, handler canal.EventHandler) error {
+	if p.progress != nil {
+		p.progress(p.pos.Name, ev.Header)
+	}
+	switch e := ev.Event.(type) {
+	case *replication.RotateEvent:
+		p.pos.Name = string(e.NextLogName)
+		p.pos.Pos = uint32(e.Position)
+		return handler.OnRotate(ev.Header, e)
+	case *replication.TableMapEvent:
+		if observer, ok := p.provider.(catalog.Observer); ok {
+			observer.OnTableMap(e)
+		}
+	case *replication.RowsEvent:
+		return p.handleRowsEvent(ev, handler)
+	case *replication.XIDEvent:
+		if setter, ok := handler.(event.XIDSetter); ok {
+			setter.SetXID(e.XID)
+		}
+		return handler.OnXID(ev.Header, p.pos)
+	case *replication.MariadbGTIDEvent:
+		gtid, err := mysql.ParseMariadbGTIDSet(e.GTID.String())
+		if err != nil {
+			return err
+		}
+		return handler.OnGTID(ev.Header, gtid)
+	case *replication.GTIDEvent:
+		u, _ := uuid.FromBytes(e.SID)
+		gtid, err := mysql.ParseMysqlGTIDSet(fmt.Sprintf("%s:%d", u.String(), e.GNO))
+		if err != nil {
+			return err
+		}
+		return handler.OnGTID(ev.Header, gtid)
+	case *replication.GenericEvent:
+		// XA PREPARE之前的变更属于同一个事务，XA COMMIT在之后单独的事件中
+		if ev.Header.EventType == replication.XA_PREPARE_LOG_EVENT {
+			return handler.OnXID(ev.Header, p.pos)
+		}
+	case *replication.QueryEvent:
+		query := string(e.Query)
+		if ddl.IsTransactionControl(query) {
+			return handler.OnDDL(ev.Header, p.pos, e)
+		}
+		if observer, ok := p.provider.(catalog.Observer); ok {
+			observer.OnDDL(string(e.Schema), query)
+		}
+		if isDDL(query) {
+			return handler.OnDDL(ev.Header, p.pos, e)
+		}
+	}
+	return nil
+}
+
+// isDDL 只有ddl交给handler，statement格式的dml、GRANT、CREATE USER等语句不输出。
+// 与canal一致，无法解析的语句跳过
+func isDDL(query string) bool {
+	stmts, err := ddl.Parse(query)
+	if err != nil {
+		return false
+	}
+	for _, stmt := range stmts {
+		if _, ok := stmt.(ast.DDLNode); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Parser) handleRowsEvent(e *replication.BinlogEvent, handler canal.EventHandler) error {
+	ev := e.Event.(*replication.RowsEvent)
+
+	s := string(ev.Table.Schema)
+	table := string(ev.Table.Table)
+	if !p.included(s, table) {
+		return nil
+	}
+
+	t, err := p.provider.Table(s, table)
+	if err != nil {
+		var notFound *catalog.NotFoundError
+		if errors.As(err, &notFound) {
+			return p.missing(notFound)
+		}
+		return err
+	}
+	if len(t.Columns) != int(ev.Table.ColumnCount) {
+		return fmt.Errorf("表%s.%s的表结构有%d个字段，binlog中有%d个字段，表结构可能在解析范围之后被修改过，"+
+			"可以通过--schema-file提供解析范围开始时的表结构，或者开启binlog_row_metadata=FULL", s, table, len(t.Columns), ev.Table.ColumnCount)
+	}
+	var action string
+	switch e.Header.EventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		action = canal.InsertAction
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		action = canal.DeleteAction
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		action = canal.UpdateAction
+	default:
+		return fmt.Errorf("%s not supported now", e.Header.EventType)
+	}
+	events := newRowsEvent(t, action, ev.Rows, e.Header)
+	return handler.OnRow(events)
+}
+
+// missing 找不到表结构，on-error为fail时返回错误，否则提示后跳过该表的行变更
+func (p *Parser) missing(err *catalog.NotFoundError) error {
+	if p.config.OnError == "" || p.config.OnError == "fail" {
+		return fmt.Errorf("%w，可以通过--schema-file提供解析范围开始时的表结构", err)
+	}
+	key := err.Schema + "." + err.Table
+	if !p.skipped[key] {
+		p.skipped[key] = true
+		_, _ = fmt.Fprintf(os.Stderr, "# 跳过表%s的行变更，%s\n", key, err.Error())
+	}
+	return nil
+}
+
+func newRowsEvent(table *schema.Table, action string, rows [][]interface{}, header *replication.EventHeader) *canal.RowsEvent {
+	e := new(canal.RowsEvent)
+
+	e.Table = table
+	e.Action = action
+	e.Rows = rows
+	e.Header = header
+
+	handleUnsigned(e)
+
+	return e
+}
+
+const maxMediumintUnsigned int32 = 16777215
+
+func handleUnsigned(r *canal.RowsEvent) {
+	// Handle Unsigned Columns here, for binlog replication, we can't know the integer is unsigned or not,
+	// so we use int type but this may cause overflow outside sometimes, so we must convert to the really .
+	// unsigned type
+	if len(r.Table.UnsignedColumns) == 0 {
+		return
+	}
+
+	for i := 0; i < len(r.Rows); i++ {
+		for _, columnIdx := range r.Table.UnsignedColumns {
+			switch value := r.Rows[i][columnIdx].(type) {
+			case int8:
+				r.Rows[i][columnIdx] = uint8(value)
+			case int16:
+				r.Rows[i][columnIdx] = uint16(value)
+			case int32:
+				// problem with mediumint is that it's a 3-byte type. There is no compatible golang type to match that.
+				// So to convert from negative to positive we'd need to convert the value manually
+				if value < 0 && r.Table.Columns[columnIdx].Type == schema.TYPE_MEDIUM_INT {
+					r.Rows[i][columnIdx] = uint32(maxMediumintUnsigned + value + 1)
+				} else {
+					r.Rows[i][columnIdx] = uint32(value)
+				}
+			case int64:
+				r.Rows[i][columnIdx] = uint64(value)
+			case int:
+				r.Rows[i][columnIdx] = uint(value)
+			default:
+				// nothing to do
+			}
+		}
+	}
+}
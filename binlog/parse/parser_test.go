@@ -0,0 +1,84 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"github.com/dhbin/ra/binlog/catalog"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"reflect"
+	"testing"
+)
+
+// queryRecorder 记录传给OnDDL的语句和OnXID的次数
+type queryRecorder struct {
+	canal.DummyEventHandler
+	queries []string
+	xids    int
+}
+
+func (h *queryRecorder) OnDDL(_ *replication.EventHeader, _ mysql.Position, e *replication.QueryEvent) error {
+	h.queries = append(h.queries, string(e.Query))
+	return nil
+}
+
+func (h *queryRecorder) OnXID(*replication.EventHeader, mysql.Position) error {
+	h.xids++
+	return nil
+}
+
+func TestParserQueryEvent(t *testing.T) {
+	p, err := newParser(&config.BinlogConfig{}, catalog.NewMetadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &queryRecorder{}
+	queries := []string{
+		"BEGIN",
+		"insert into test.user values (1, 'a')",
+		"SAVEPOINT sp1",
+		"update test.user set name = 'b' where id = 1",
+		"COMMIT",
+		"GRANT SELECT ON test.* TO 'ra'@'%'",
+		"CREATE USER 'ra'@'%' IDENTIFIED BY 'x'",
+		"CREATE TRIGGER tr BEFORE INSERT ON user FOR EACH ROW SET NEW.id = 1",
+		"create table user2 (id int)",
+		"alter table user add column age int",
+		"drop database test",
+	}
+	for _, q := range queries {
+		ev := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.QUERY_EVENT}, Event: &replication.QueryEvent{Query: []byte(q)}}
+		if err := p.handle(ev, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"BEGIN", "SAVEPOINT sp1", "COMMIT", "create table user2 (id int)", "alter table user add column age int", "drop database test"}
+	if !reflect.DeepEqual(h.queries, want) {
+		t.Errorf("OnDDL = %q, want %q", h.queries, want)
+	}
+
+	// XA PREPARE结束XA事务
+	ev := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.XA_PREPARE_LOG_EVENT}, Event: &replication.GenericEvent{}}
+	if err := p.handle(ev, h); err != nil {
+		t.Fatal(err)
+	}
+	if h.xids != 1 {
+		t.Errorf("XA PREPARE后OnXID调用%d次, want 1", h.xids)
+	}
+}
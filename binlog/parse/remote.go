@@ -0,0 +1,136 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"context"
+	"fmt"
+	"github.com/dhbin/ra/binlog/catalog"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/siddontang/go-log/log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// RemoteParser 作为从库从数据库读取binlog
+type RemoteParser struct {
+	*Parser
+}
+
+// NewRemoteParser 创建RemoteParser，表结构从provider获取
+func NewRemoteParser(config *config.BinlogConfig, provider catalog.Provider) (*RemoteParser, error) {
+	p, err := newParser(config, provider)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteParser{Parser: p}, nil
+}
+
+// Run 从起始位置读取binlog，直到ctx取消或出错
+func (h *RemoteParser) Run(ctx context.Context, eventHandler canal.EventHandler) error {
+	syncer, err := NewSyncer(h.config)
+	if err != nil {
+		return err
+	}
+	defer syncer.Close()
+	streamer, err := h.startSync(syncer)
+	if err != nil {
+		return err
+	}
+	// 开始时服务端发送的rotate事件带有起始文件名
+	h.pos = mysql.Position{}
+	for {
+		ev, err := streamer.GetEvent(ctx)
+		if err != nil {
+			return err
+		}
+		if ev.Header.LogPos == 0 {
+			// 服务端发送的不在binlog文件中的事件，文件名变化的rotate事件需要处理
+			rotate, ok := ev.Event.(*replication.RotateEvent)
+			if !ok || string(rotate.NextLogName) == h.pos.Name {
+				continue
+			}
+		}
+		if err := h.handle(ev, eventHandler); err != nil {
+			return err
+		}
+	}
+}
+
+// startSync 指定了起始gtid集合时按gtid开始，否则从起始文件、位置开始
+func (h *RemoteParser) startSync(syncer *replication.BinlogSyncer) (*replication.BinlogStreamer, error) {
+	if h.config.StartGTIDSet == "" {
+		return syncer.StartSync(mysql.Position{Name: h.config.StartBinlogName, Pos: h.config.StartPosition})
+	}
+	flavor, err := Flavor(h.config)
+	if err != nil {
+		return nil, err
+	}
+	set, err := mysql.ParseGTIDSet(flavor, h.config.StartGTIDSet)
+	if err != nil {
+		return nil, fmt.Errorf("gtid集合%s格式错误: %w", h.config.StartGTIDSet, err)
+	}
+	return syncer.StartSyncGTID(set)
+}
+
+// NewSyncer 按连接参数创建BinlogSyncer，没有指定server id时随机生成
+func NewSyncer(config *config.BinlogConfig) (*replication.BinlogSyncer, error) {
+	flavor, err := Flavor(config)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	serverID := config.ServerID
+	if serverID == 0 {
+		serverID = uint32(rand.New(rand.NewSource(time.Now().UnixNano())).Intn(1000)) + 1001
+	}
+	syncerConfig := replication.BinlogSyncerConfig{
+		ServerID:  serverID,
+		Flavor:    flavor,
+		Host:      config.Host,
+		Port:      uint16(config.Port),
+		User:      config.Username,
+		Password:  config.Password,
+		TLSConfig: tlsConfig,
+		Logger:    log.NewDefault(&event.DiscardLogHandler{}),
+	}
+	if config.Socket != "" {
+		// Port为0时Host作为unix socket路径
+		syncerConfig.Host = config.Socket
+		syncerConfig.Port = 0
+	}
+	return replication.NewBinlogSyncer(syncerConfig), nil
+}
+
+// Flavor 数据库类型，为空时为mysql
+func Flavor(config *config.BinlogConfig) (string, error) {
+	switch strings.ToLower(config.Flavor) {
+	case "", mysql.MySQLFlavor:
+		return mysql.MySQLFlavor, nil
+	case mysql.MariaDBFlavor:
+		return mysql.MariaDBFlavor, nil
+	}
+	return "", fmt.Errorf("不支持的flavor: %s，支持mysql、mariadb", config.Flavor)
+}
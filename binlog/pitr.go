@@ -0,0 +1,418 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dhbin/ra/binlog/catalog"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PitrOptions 基于快照和binlog恢复表在指定时间点的数据
+type PitrOptions struct {
+	// Dumps mysqldump导出的文件
+	Dumps []string
+	// Csv csv导出的文件，key为db.table
+	Csv map[string]string
+	// At 恢复到该时间，包括该时间提交的事务
+	At *time.Time
+	// GTID 恢复到该gtid的事务提交之后
+	GTID string
+}
+
+// errPitrDone 到达目标时间点
+var errPitrDone = errors.New("pitr done")
+
+// timestampLocation 解析器按本机时区格式化binlog中的TIMESTAMP
+var timestampLocation = time.Local
+
+// Pitr 读取快照后在内存中重放解析范围内的行变更，输出表在目标时间点的数据。
+// 解析范围应该从快照的位置开始，表结构优先使用快照中的CREATE TABLE，
+// 其次是表结构文件和数据库。输出的TIMESTAMP值统一为UTC时间，并在开头设置time_zone
+func Pitr(ctx context.Context, config *config.BinlogConfig, options *PitrOptions) error {
+	if options.At == nil && options.GTID == "" {
+		return errors.New("需要指定目标时间或gtid")
+	}
+	provider, err := newProvider(config, options.Dumps...)
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+	filter, err := newTableFilter(config)
+	if err != nil {
+		return err
+	}
+	s := &pitrSink{options: options, tables: make(map[string]*pitrTable), provider: provider, filter: filter}
+	if err := s.load(config.Database); err != nil {
+		return err
+	}
+
+	reader := newReader(config, false)
+	reader.provider = provider
+	err = reader.Run(ctx, s)
+	if err != nil && !s.reached {
+		// 解析返回的错误可能被包装，通过reached判断是否到达目标时间点
+		return err
+	}
+	if options.GTID != "" && !s.reached {
+		return fmt.Errorf("解析范围内没有找到gtid %s", options.GTID)
+	}
+	if s.missing > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "# %d行update、delete在快照中没有对应的数据，快照与解析范围的起始位置可能不一致\n", s.missing)
+	}
+
+	out, err := config.GetOut()
+	if err != nil {
+		return err
+	}
+	err = s.dump(out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// pitrTable 表在内存中的数据，按主键保存
+type pitrTable struct {
+	table *schema.Table
+	rows  map[string][]interface{}
+	// order 行第一次出现的顺序，输出时按该顺序
+	order map[string]int64
+	seq   int64
+	// create 快照中CREATE TABLE表名之后的部分，为空时按表结构生成
+	create string
+}
+
+func (t *pitrTable) key(row []interface{}) string {
+	values := make([]string, len(t.table.PKColumns))
+	for i, idx := range t.table.PKColumns {
+		values[i] = displayValue(row[idx])
+	}
+	return strings.Join(values, "\x00")
+}
+
+func (t *pitrTable) put(row []interface{}) bool {
+	key := t.key(row)
+	_, exists := t.rows[key]
+	if !exists {
+		t.seq++
+		t.order[key] = t.seq
+	}
+	t.rows[key] = row
+	return exists
+}
+
+func (t *pitrTable) remove(row []interface{}) bool {
+	key := t.key(row)
+	_, exists := t.rows[key]
+	delete(t.rows, key)
+	delete(t.order, key)
+	return exists
+}
+
+// pitrSink 按事务重放行变更
+type pitrSink struct {
+	options  *PitrOptions
+	provider catalog.Provider
+	tables   map[string]*pitrTable
+	pending  []*event.RowChange
+	reached  bool
+	missing  int
+	// filter -d、-t过滤条件，快照中没有选中的表不加载
+	filter *tableFilter
+}
+
+// load 读取快照，跳过过滤条件没有选中的表
+func (s *pitrSink) load(database string) error {
+	create := func(c *snapshotCreate) error {
+		if !s.filter.Table(c.schema, c.table) {
+			return nil
+		}
+		// 没有数据的表也需要恢复
+		t, err := s.table(c.schema, c.table)
+		if err != nil {
+			return err
+		}
+		t.create = c.definition
+		return nil
+	}
+	add := func(row *snapshotRow) error {
+		if !s.filter.Table(row.schema, row.table) {
+			return nil
+		}
+		t, err := s.table(row.schema, row.table)
+		if err != nil {
+			return err
+		}
+		values, err := snapshotValues(t.table, row)
+		if err != nil {
+			return err
+		}
+		loc := timestampLocation
+		if row.utc {
+			loc = time.UTC
+		}
+		if err := utcTimestamps(t.table, values, loc); err != nil {
+			return err
+		}
+		t.put(values)
+		return nil
+	}
+	for _, file := range s.options.Dumps {
+		if err := loadDump(file, database, create, add); err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(s.options.Csv))
+	for name := range s.options.Csv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts := strings.SplitN(name, ".", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("csv快照的表名格式错误: %s，格式为db.table", name)
+		}
+		if !s.filter.Table(parts[0], parts[1]) {
+			continue
+		}
+		// 只有表头的csv也需要恢复表
+		if _, err := s.table(parts[0], parts[1]); err != nil {
+			return err
+		}
+		if err := loadCsv(s.options.Csv[name], parts[0], parts[1], add); err != nil {
+			return err
+		}
+	}
+	if len(s.tables) == 0 {
+		return errors.New("快照中没有过滤条件选中的表")
+	}
+	return nil
+}
+
+func (s *pitrSink) table(schemaName string, name string) (*pitrTable, error) {
+	key := schemaName + "." + name
+	if t, ok := s.tables[key]; ok {
+		return t, nil
+	}
+	table, err := s.provider.Table(schemaName, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(table.PKColumns) == 0 {
+		return nil, fmt.Errorf("表%s没有主键，无法重放，可以通过-d、-t只恢复需要的表", key)
+	}
+	t := &pitrTable{table: table, rows: make(map[string][]interface{}), order: make(map[string]int64)}
+	s.tables[key] = t
+	return t, nil
+}
+
+// snapshotValues 按表字段顺序排列快照中的值
+func snapshotValues(table *schema.Table, row *snapshotRow) ([]interface{}, error) {
+	if row.columns == nil {
+		if len(row.values) != len(table.Columns) {
+			return nil, fmt.Errorf("表%s.%s有%d个字段，快照中有%d个值", table.Schema, table.Name, len(table.Columns), len(row.values))
+		}
+		return row.values, nil
+	}
+	if len(row.columns) != len(row.values) {
+		return nil, fmt.Errorf("表%s.%s快照中有%d个字段，%d个值", table.Schema, table.Name, len(row.columns), len(row.values))
+	}
+	values := make([]interface{}, len(table.Columns))
+	for i, name := range row.columns {
+		idx := table.FindColumn(name)
+		if idx < 0 {
+			return nil, fmt.Errorf("表%s.%s没有字段%s", table.Schema, table.Name, name)
+		}
+		values[idx] = row.values[i]
+	}
+	return values, nil
+}
+
+func (s *pitrSink) Begin(*event.Transaction) error {
+	s.pending = s.pending[:0]
+	return nil
+}
+
+func (s *pitrSink) Row(c *event.RowChange) error {
+	if _, ok := s.tables[c.Table.Schema+"."+c.Table.Name]; ok {
+		s.pending = append(s.pending, c)
+	}
+	return nil
+}
+
+func (s *pitrSink) DDL(*event.DDLChange) error {
+	return nil
+}
+
+// Commit 事务提交时间晚于目标时间时停止，否则重放事务
+func (s *pitrSink) Commit(tx *event.Transaction) error {
+	if s.options.At != nil && int64(tx.Timestamp) > s.options.At.Unix() {
+		s.reached = true
+		return errPitrDone
+	}
+	for _, c := range s.pending {
+		s.apply(c)
+	}
+	s.pending = s.pending[:0]
+	if s.options.GTID != "" && tx.GTID == s.options.GTID {
+		s.reached = true
+		return errPitrDone
+	}
+	return nil
+}
+
+// Discard 未提交的事务不重放
+func (s *pitrSink) Discard(*event.Transaction) error {
+	s.pending = s.pending[:0]
+	return nil
+}
+
+func (s *pitrSink) Close() error {
+	return nil
+}
+
+func (s *pitrSink) apply(c *event.RowChange) {
+	t := s.tables[c.Table.Schema+"."+c.Table.Name]
+	if len(c.Table.Columns) != len(t.table.Columns) {
+		s.missing++
+		return
+	}
+	var before, after []interface{}
+	if c.Before != nil {
+		before = canonicalRow(t.table, c.Before)
+	}
+	if c.After != nil {
+		after = canonicalRow(t.table, c.After)
+	}
+	if before != nil && after != nil && t.key(before) == t.key(after) {
+		// 主键未变化的update保持行的顺序
+		if !t.put(after) {
+			s.missing++
+		}
+		return
+	}
+	if before != nil && !t.remove(before) {
+		s.missing++
+	}
+	if after != nil {
+		t.put(after)
+	}
+}
+
+// canonicalRow binlog中的值转换为与快照一致的字符串，TIMESTAMP从本机时区转换为UTC
+func canonicalRow(table *schema.Table, row []interface{}) []interface{} {
+	values := make([]interface{}, len(row))
+	for i, v := range row {
+		if v != nil {
+			values[i] = displayValue(v)
+		}
+	}
+	// binlog中的TIMESTAMP由解析器按本机时区格式化，不会出现格式错误
+	_ = utcTimestamps(table, values, timestampLocation)
+	return values
+}
+
+// utcTimestamps 将loc时区的TIMESTAMP字符串转换为UTC时间，零值保持不变
+func utcTimestamps(table *schema.Table, values []interface{}, loc *time.Location) error {
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok || i >= len(table.Columns) || table.Columns[i].Type != schema.TYPE_TIMESTAMP {
+			continue
+		}
+		if strings.HasPrefix(s, "0000-00-00") {
+			continue
+		}
+		layout := "2006-01-02 15:04:05"
+		if dot := strings.IndexByte(s, '.'); dot >= 0 {
+			layout += "." + strings.Repeat("0", len(s)-dot-1)
+		}
+		t, err := time.ParseInLocation(layout, s, loc)
+		if err != nil {
+			return fmt.Errorf("表%s.%s字段%s的值%s不是TIMESTAMP: %w", table.Schema, table.Name, table.Columns[i].Name, s, err)
+		}
+		values[i] = t.UTC().Format(layout)
+	}
+	return nil
+}
+
+// dump 按表输出insert语句
+func (s *pitrSink) dump(out io.Writer) error {
+	target := s.options.GTID
+	if s.options.At != nil {
+		target = s.options.At.Format("2006-01-02 15:04:05")
+	}
+	// TIMESTAMP值为UTC时间，与mysqldump默认的--tz-utc一致
+	if _, err := fmt.Fprintf(out, "-- ra pitr %s\nSET time_zone = '+00:00';\n", target); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(s.tables))
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	builder := &sql.Builder{}
+	for _, name := range names {
+		t := s.tables[name]
+		keys := make([]string, 0, len(t.rows))
+		for key := range t.rows {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return t.order[keys[i]] < t.order[keys[j]] })
+		if _, err := fmt.Fprintf(out, "\n-- %s %d rows\n%s\n", name, len(keys), t.createTable()); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			stmt, err := builder.BuildInsertSql(t.table, t.rows[key])
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintln(out, stmt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createTable 优先使用快照中的CREATE TABLE，否则按表结构生成只包含字段类型和主键的CREATE TABLE
+func (t *pitrTable) createTable() string {
+	name := fmt.Sprintf("`%s`.`%s`", t.table.Schema, t.table.Name)
+	if t.create != "" {
+		return "CREATE TABLE IF NOT EXISTS " + name + t.create + ";"
+	}
+	lines := make([]string, 0, len(t.table.Columns)+1)
+	for _, c := range t.table.Columns {
+		lines = append(lines, fmt.Sprintf("  `%s` %s", c.Name, c.RawType))
+	}
+	keys := make([]string, len(t.table.PKColumns))
+	for i, idx := range t.table.PKColumns {
+		keys[i] = "`" + t.table.Columns[idx].Name + "`"
+	}
+	lines = append(lines, "  PRIMARY KEY ("+strings.Join(keys, ",")+")")
+	return "-- 快照中没有CREATE TABLE，只包含字段类型和主键\nCREATE TABLE IF NOT EXISTS " + name + " (\n" + strings.Join(lines, ",\n") + "\n);"
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"bytes"
+	"github.com/dhbin/ra/binlog/catalog"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tableProvider 按db.table返回固定的表结构
+type tableProvider map[string]*schema.Table
+
+func (p tableProvider) Table(db string, table string) (*schema.Table, error) {
+	if t, ok := p[db+"."+table]; ok {
+		return t, nil
+	}
+	return nil, &catalog.NotFoundError{Schema: db, Table: table}
+}
+
+func pitrTestProvider() tableProvider {
+	return tableProvider{
+		"test.user": {
+			Schema:    "test",
+			Name:      "user",
+			Columns:   []schema.TableColumn{{Name: "id", Type: schema.TYPE_NUMBER}, {Name: "name", Type: schema.TYPE_STRING}},
+			PKColumns: []int{0},
+		},
+		"test.log": {Schema: "test", Name: "log", Columns: []schema.TableColumn{{Name: "msg", Type: schema.TYPE_STRING}}},
+	}
+}
+
+func newTestPitrSink(t *testing.T, cfg *config.BinlogConfig, options *PitrOptions) (*pitrSink, error) {
+	dump := filepath.Join(t.TempDir(), "dump.sql")
+	data := "USE `test`;\nINSERT INTO `user` VALUES (1,'alice'),(2,'bob');\nINSERT INTO `log` VALUES ('x');\n"
+	if err := os.WriteFile(dump, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	options.Dumps = []string{dump}
+	filter, err := newTableFilter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &pitrSink{options: options, provider: pitrTestProvider(), tables: make(map[string]*pitrTable), filter: filter}
+	return s, s.load(cfg.Database)
+}
+
+func TestPitrSinkLoad(t *testing.T) {
+	if _, err := newTestPitrSink(t, &config.BinlogConfig{}, &PitrOptions{}); err == nil || !strings.Contains(err.Error(), "没有主键") {
+		t.Errorf("没有过滤条件时没有主键的表应返回错误, got %v", err)
+	}
+	s, err := newTestPitrSink(t, &config.BinlogConfig{Database: "test", Tables: []string{"user"}}, &PitrOptions{})
+	if err != nil {
+		t.Fatalf("没有选中的表应被跳过: %v", err)
+	}
+	if len(s.tables) != 1 || len(s.tables["test.user"].rows) != 2 {
+		t.Errorf("tables = %v", s.tables)
+	}
+	if _, err := newTestPitrSink(t, &config.BinlogConfig{Database: "other"}, &PitrOptions{}); err == nil {
+		t.Error("快照中没有选中的表时应返回错误")
+	}
+}
+
+func TestPitrSinkReplay(t *testing.T) {
+	table := pitrTestProvider()["test.user"]
+	type tx struct {
+		timestamp uint32
+		gtid      string
+		rows      []*event.RowChange
+	}
+	row := func(action string, before []interface{}, after []interface{}) *event.RowChange {
+		return &event.RowChange{Table: table, Action: action, Before: before, After: after}
+	}
+	txs := []tx{
+		{timestamp: 100, gtid: "uuid:1", rows: []*event.RowChange{
+			row(canal.UpdateAction, []interface{}{int32(1), "alice"}, []interface{}{int32(1), "alice2"}),
+			row(canal.InsertAction, nil, []interface{}{int32(3), "carol"}),
+		}},
+		{timestamp: 200, gtid: "uuid:2", rows: []*event.RowChange{
+			row(canal.DeleteAction, []interface{}{int32(2), "bob"}, nil),
+			// 主键变化的update
+			row(canal.UpdateAction, []interface{}{int32(3), "carol"}, []interface{}{int32(4), "carol"}),
+		}},
+		{timestamp: 300, gtid: "uuid:3", rows: []*event.RowChange{
+			row(canal.InsertAction, nil, []interface{}{int32(5), "dave"}),
+			// 快照中没有的行
+			row(canal.DeleteAction, []interface{}{int32(9), "x"}, nil),
+		}},
+	}
+	at := func(ts int64) *time.Time {
+		v := time.Unix(ts, 0)
+		return &v
+	}
+	tests := []struct {
+		name    string
+		options *PitrOptions
+		reached bool
+		missing int
+		want    []string
+	}{
+		{name: "目标时间之前", options: &PitrOptions{At: at(50)}, reached: true, want: []string{"1, 'alice'", "2, 'bob'"}},
+		{name: "包括目标时间提交的事务", options: &PitrOptions{At: at(200)}, reached: true, want: []string{"1, 'alice2'", "4, 'carol'"}},
+		{name: "目标时间之后没有事务", options: &PitrOptions{At: at(1000)}, missing: 1, want: []string{"1, 'alice2'", "4, 'carol'", "5, 'dave'"}},
+		{name: "到达gtid", options: &PitrOptions{GTID: "uuid:1"}, reached: true, want: []string{"1, 'alice2'", "2, 'bob'", "3, 'carol'"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newTestPitrSink(t, &config.BinlogConfig{Database: "test", Tables: []string{"user"}}, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tx := range txs {
+				transaction := &event.Transaction{Position: event.Position{Timestamp: tx.timestamp}, GTID: tx.gtid}
+				_ = s.Begin(transaction)
+				for _, c := range tx.rows {
+					c.Tx = transaction
+					_ = s.Row(c)
+				}
+				if err := s.Commit(transaction); err != nil {
+					if err != errPitrDone {
+						t.Fatal(err)
+					}
+					break
+				}
+			}
+			if s.reached != tt.reached || s.missing != tt.missing {
+				t.Errorf("reached = %v, missing = %d, want %v, %d", s.reached, s.missing, tt.reached, tt.missing)
+			}
+			var out bytes.Buffer
+			if err := s.dump(&out); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, line := range strings.Split(out.String(), "\n") {
+				if strings.HasPrefix(line, "insert into") {
+					got = append(got, line[strings.Index(line, "values(")+len("values("):len(line)-2])
+				}
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("dump() = %v, want %v\n%s", got, tt.want, out.String())
+			}
+		})
+	}
+}
+
+func TestPitrSinkDiscard(t *testing.T) {
+	at := time.Now()
+	s, err := newTestPitrSink(t, &config.BinlogConfig{Database: "test", Tables: []string{"user"}}, &PitrOptions{At: &at})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := pitrTestProvider()["test.user"]
+	tx := &event.Transaction{}
+	_ = s.Begin(tx)
+	_ = s.Row(&event.RowChange{Tx: tx, Table: table, Action: canal.DeleteAction, Before: []interface{}{int32(1), "alice"}})
+	_ = s.Discard(tx)
+	if got := len(s.tables["test.user"].rows); got != 2 {
+		t.Errorf("丢弃的事务不应重放, rows = %d", got)
+	}
+}
+
+func TestPitrSinkDump(t *testing.T) {
+	defer func(loc *time.Location) { timestampLocation = loc }(timestampLocation)
+	timestampLocation = time.FixedZone("UTC+8", 8*3600)
+	table := &schema.Table{Schema: "test", Name: "event", PKColumns: []int{0}}
+	table.AddColumn("id", "int", "", "")
+	table.AddColumn("at", "timestamp(3)", "", "")
+	dump := filepath.Join(t.TempDir(), "dump.sql")
+	data := "USE `test`;\n/*!40103 SET TIME_ZONE='+00:00' */;\nCREATE TABLE `event` (\n  `id` int NOT NULL,\n  `at` timestamp(3) NULL,\n  PRIMARY KEY (`id`)\n);\n" +
+		"INSERT INTO `event` VALUES (1,'2023-01-01 00:00:00.500');\n"
+	if err := os.WriteFile(dump, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := newTableFilter(&config.BinlogConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &pitrSink{options: &PitrOptions{GTID: "uuid:1", Dumps: []string{dump}}, provider: tableProvider{"test.event": table},
+		tables: make(map[string]*pitrTable), filter: filter}
+	if err := s.load(""); err != nil {
+		t.Fatal(err)
+	}
+	tx := &event.Transaction{GTID: "uuid:1"}
+	_ = s.Begin(tx)
+	// binlog中的TIMESTAMP为本机时区
+	_ = s.Row(&event.RowChange{Tx: tx, Table: table, Action: canal.InsertAction, After: []interface{}{int32(2), "2023-01-01 08:00:01.000"}})
+	if err := s.Commit(tx); err != errPitrDone {
+		t.Fatalf("Commit() = %v", err)
+	}
+	var out bytes.Buffer
+	if err := s.dump(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"SET time_zone = '+00:00';",
+		"CREATE TABLE IF NOT EXISTS `test`.`event` (\n`id` int NOT NULL,\n`at` timestamp(3) NULL,\nPRIMARY KEY (`id`)\n);",
+		"'2023-01-01 00:00:00.500');",
+		"'2023-01-01 00:00:01.000');",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("dump() 缺少 %q\n%s", want, out.String())
+		}
+	}
+
+	// 快照中没有CREATE TABLE时按表结构生成
+	generated := &pitrTable{table: table}
+	want := "CREATE TABLE IF NOT EXISTS `test`.`event` (\n  `id` int,\n  `at` timestamp(3),\n  PRIMARY KEY (`id`)\n);"
+	if got := generated.createTable(); !strings.HasSuffix(got, want) {
+		t.Errorf("createTable() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,322 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/parse"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"golang.org/x/term"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 进度输出格式
+const (
+	// ProgressText 终端中在同一行刷新，否则每次输出一行
+	ProgressText = "text"
+	// ProgressJSON 每次输出一行json，便于其它程序读取
+	ProgressJSON = "json"
+)
+
+// progress 在stderr定时输出解析进度：当前位置、解析范围的百分比、每秒事件数、输出的行数以及预计剩余时间。
+// 解析范围的大小来自本地文件大小或SHOW BINARY LOGS，获取不到时不输出百分比和剩余时间
+type progress struct {
+	format   string
+	out      io.Writer
+	terminal bool
+	interval time.Duration
+	// offsets 解析范围内每个文件开始之前已解析的字节数，total为解析范围的字节数，为0时不知道总量
+	offsets map[string]int64
+	total   int64
+	// countRows 是否统计输出的行数，只统计写入Sink的行变更
+	countRows bool
+	begin     time.Time
+	// reported 是否已经输出过进度，text格式在解析很快结束时不输出最后的进度
+	reported bool
+
+	mu     sync.Mutex
+	file   string
+	pos    uint32
+	events int64
+	rows   int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// progressReport json格式的进度，不知道解析范围大小时没有percent、eta_seconds
+type progressReport struct {
+	File           string   `json:"file"`
+	Pos            uint32   `json:"pos"`
+	Percent        *float64 `json:"percent,omitempty"`
+	Events         int64    `json:"events"`
+	EventsPerSec   float64  `json:"events_per_sec"`
+	Rows           *int64   `json:"rows,omitempty"`
+	ElapsedSeconds float64  `json:"elapsed_seconds"`
+	ETASeconds     *float64 `json:"eta_seconds,omitempty"`
+	// Final 解析结束时的最后一次输出
+	Final bool `json:"final"`
+}
+
+// binlogSize 解析范围内的binlog文件大小
+type binlogSize struct {
+	name string
+	size int64
+}
+
+// newProgress 按ProgressFormat创建progress并开始定时输出，为空时返回nil，nil可以直接使用
+func newProgress(config *config.BinlogConfig, countRows bool) *progress {
+	if config.ProgressFormat == "" {
+		return nil
+	}
+	p := &progress{
+		format:    config.ProgressFormat,
+		out:       os.Stderr,
+		interval:  config.ProgressInterval,
+		countRows: countRows,
+		begin:     time.Now(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	// 输出到终端的stdout时进度与输出混在一起，不在同一行刷新
+	p.terminal = term.IsTerminal(int(os.Stderr.Fd())) && (config.Out != "" || !term.IsTerminal(int(os.Stdout.Fd())))
+	if p.interval <= 0 {
+		p.interval = time.Second
+		if p.format == ProgressText && !p.terminal {
+			p.interval = 10 * time.Second
+		}
+	}
+	if !config.Follow {
+		// 获取不到文件大小时只输出位置和速度
+		if sizes, err := binlogSizes(config); err == nil {
+			p.setRange(config, sizes)
+		}
+	}
+	go p.loop()
+	return p
+}
+
+// binlogSizes 解析范围内每个文件的大小，本地解析时为文件大小，远程解析时来自SHOW BINARY LOGS
+func binlogSizes(config *config.BinlogConfig) ([]binlogSize, error) {
+	var sizes []binlogSize
+	if config.Local {
+		files, err := parse.LocalFiles(config.StartBinlogName, config.StopBinlogName)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				return nil, err
+			}
+			sizes = append(sizes, binlogSize{name: filepath.Base(file), size: info.Size()})
+		}
+		return sizes, nil
+	}
+	conn, err := connect(config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	result, err := conn.Execute("SHOW BINARY LOGS")
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < result.RowNumber(); i++ {
+		name, _ := result.GetString(i, 0)
+		size, _ := result.GetInt(i, 1)
+		if binlogInRange(name, config.StartBinlogName, config.StopBinlogName) {
+			sizes = append(sizes, binlogSize{name: name, size: size})
+		}
+	}
+	return sizes, nil
+}
+
+// binlogInRange 按文件序号比较，序号位数变化时（如mysql-bin.999999之后的mysql-bin.1000000）也能正确比较
+func binlogInRange(name string, start string, stop string) bool {
+	return mysql.CompareBinlogFileName(name, start) >= 0 && mysql.CompareBinlogFileName(name, stop) <= 0
+}
+
+// setRange 起始文件从起始位置开始，终止文件到终止位置为止
+func (p *progress) setRange(config *config.BinlogConfig, sizes []binlogSize) {
+	if len(sizes) == 0 {
+		return
+	}
+	p.offsets = make(map[string]int64, len(sizes))
+	var total int64
+	for i, f := range sizes {
+		p.offsets[f.name] = total - int64(config.StartPosition)
+		if i == len(sizes)-1 && config.StopPosition != 0 && int64(config.StopPosition) < f.size {
+			total += int64(config.StopPosition)
+		} else {
+			total += f.size
+		}
+	}
+	p.total = total - int64(config.StartPosition)
+}
+
+// event 记录处理到的位置，不在binlog文件中的事件不记录
+func (p *progress) event(file string, header *replication.EventHeader) {
+	if p == nil || header.LogPos == 0 {
+		return
+	}
+	p.mu.Lock()
+	p.file, p.pos = file, header.LogPos
+	p.events++
+	p.mu.Unlock()
+}
+
+// wrap 统计写入s的行数
+func (p *progress) wrap(s event.Sink) event.Sink {
+	if p == nil {
+		return s
+	}
+	return &progressSink{Sink: s, progress: p}
+}
+
+// Close 停止定时输出并输出最后的进度
+func (p *progress) Close() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+}
+
+func (p *progress) loop() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.report(false)
+		case <-p.stop:
+			p.report(true)
+			return
+		}
+	}
+}
+
+func (p *progress) report(final bool) {
+	p.mu.Lock()
+	r := progressReport{File: p.file, Pos: p.pos, Events: p.events, Final: final}
+	rows := p.rows
+	p.mu.Unlock()
+	if r.File == "" {
+		// 还没有处理任何事件
+		return
+	}
+
+	elapsed := time.Since(p.begin).Seconds()
+	r.ElapsedSeconds = math.Round(elapsed*10) / 10
+	if elapsed > 0 {
+		r.EventsPerSec = math.Round(float64(r.Events) / elapsed)
+	}
+	if p.countRows {
+		r.Rows = &rows
+	}
+	if offset, ok := p.offsets[r.File]; ok && p.total > 0 {
+		processed := offset + int64(r.Pos)
+		percent := math.Min(math.Max(float64(processed)/float64(p.total)*100, 0), 100)
+		percent = math.Round(percent*10) / 10
+		r.Percent = &percent
+		if processed > 0 && !final {
+			eta := math.Round(math.Max(elapsed*float64(p.total-processed)/float64(processed), 0))
+			r.ETASeconds = &eta
+		}
+	}
+
+	if final && !p.reported && p.format == ProgressText {
+		return
+	}
+	p.reported = true
+	if p.format == ProgressJSON {
+		data, _ := json.Marshal(r)
+		_, _ = fmt.Fprintln(p.out, string(data))
+		return
+	}
+	line := progressLine(&r)
+	if !p.terminal {
+		_, _ = fmt.Fprintln(p.out, line)
+		return
+	}
+	// 在同一行刷新，结束时换行
+	_, _ = fmt.Fprintf(p.out, "\r\033[K%s", line)
+	if final {
+		_, _ = fmt.Fprintln(p.out)
+	}
+}
+
+// progressLine text格式的进度，如：进度 mysql-bin.000012:1024 45.2% 12000事件/s 输出300行 剩余1m30s
+func progressLine(r *progressReport) string {
+	parts := []string{"进度", fmt.Sprintf("%s:%d", r.File, r.Pos)}
+	if r.Percent != nil {
+		parts = append(parts, fmt.Sprintf("%.1f%%", *r.Percent))
+	}
+	parts = append(parts, fmt.Sprintf("%.0f事件/s", r.EventsPerSec))
+	if r.Rows != nil {
+		parts = append(parts, fmt.Sprintf("输出%d行", *r.Rows))
+	}
+	if r.ETASeconds != nil {
+		parts = append(parts, "剩余"+(time.Duration(*r.ETASeconds)*time.Second).String())
+	}
+	if r.Final {
+		parts = append(parts, fmt.Sprintf("用时%s", (time.Duration(r.ElapsedSeconds*10)*time.Second/10).String()))
+	}
+	return strings.Join(parts, " ")
+}
+
+// progressSink 统计写入下游Sink的行数
+type progressSink struct {
+	event.Sink
+	progress *progress
+}
+
+func (s *progressSink) Row(change *event.RowChange) error {
+	if err := s.Sink.Row(change); err != nil {
+		return err
+	}
+	s.progress.mu.Lock()
+	s.progress.rows++
+	s.progress.mu.Unlock()
+	return nil
+}
+
+func (s *progressSink) Discard(tx *event.Transaction) error {
+	if discarder, ok := s.Sink.(event.Discarder); ok {
+		return discarder.Discard(tx)
+	}
+	return nil
+}
+
+func (s *progressSink) Checkpoint(pos event.Position, gtid string) error {
+	if checkpointer, ok := s.Sink.(event.Checkpointer); ok {
+		return checkpointer.Checkpoint(pos, gtid)
+	}
+	return nil
+}
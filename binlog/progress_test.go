@@ -0,0 +1,138 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"github.com/dhbin/ra/config"
+	"reflect"
+	"testing"
+)
+
+func TestProgressSetRange(t *testing.T) {
+	sizes := []binlogSize{{name: "mysql-bin.000001", size: 1000}, {name: "mysql-bin.000002", size: 2000}, {name: "mysql-bin.000003", size: 3000}}
+	tests := []struct {
+		name    string
+		config  config.BinlogConfig
+		sizes   []binlogSize
+		offsets map[string]int64
+		total   int64
+	}{
+		{name: "没有文件大小", config: config.BinlogConfig{StartPosition: 4}},
+		{
+			name:    "单个文件",
+			config:  config.BinlogConfig{StartPosition: 4},
+			sizes:   sizes[:1],
+			offsets: map[string]int64{"mysql-bin.000001": -4},
+			total:   996,
+		},
+		{
+			name:    "起始文件从起始位置开始",
+			config:  config.BinlogConfig{StartPosition: 400},
+			sizes:   sizes,
+			offsets: map[string]int64{"mysql-bin.000001": -400, "mysql-bin.000002": 600, "mysql-bin.000003": 2600},
+			total:   5600,
+		},
+		{
+			name:    "终止文件到终止位置为止",
+			config:  config.BinlogConfig{StartPosition: 4, StopPosition: 500},
+			sizes:   sizes,
+			offsets: map[string]int64{"mysql-bin.000001": -4, "mysql-bin.000002": 996, "mysql-bin.000003": 2996},
+			total:   3496,
+		},
+		{
+			name:    "终止位置超过文件大小",
+			config:  config.BinlogConfig{StartPosition: 4, StopPosition: 5000},
+			sizes:   sizes,
+			offsets: map[string]int64{"mysql-bin.000001": -4, "mysql-bin.000002": 996, "mysql-bin.000003": 2996},
+			total:   5996,
+		},
+		{
+			name:    "起始位置和终止位置在同一个文件",
+			config:  config.BinlogConfig{StartPosition: 100, StopPosition: 600},
+			sizes:   sizes[:1],
+			offsets: map[string]int64{"mysql-bin.000001": -100},
+			total:   500,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &progress{}
+			p.setRange(&tt.config, tt.sizes)
+			if !reflect.DeepEqual(p.offsets, tt.offsets) || p.total != tt.total {
+				t.Errorf("setRange() offsets = %v, total = %d, want %v, %d", p.offsets, p.total, tt.offsets, tt.total)
+			}
+		})
+	}
+}
+
+func TestProgressLine(t *testing.T) {
+	percent := 45.2
+	eta := 90.0
+	rows := int64(300)
+	tests := []struct {
+		name   string
+		report progressReport
+		want   string
+	}{
+		{
+			name:   "不知道解析范围大小",
+			report: progressReport{File: "mysql-bin.000012", Pos: 1024, EventsPerSec: 12000},
+			want:   "进度 mysql-bin.000012:1024 12000事件/s",
+		},
+		{
+			name:   "百分比、行数和剩余时间",
+			report: progressReport{File: "mysql-bin.000012", Pos: 1024, Percent: &percent, EventsPerSec: 12000, Rows: &rows, ETASeconds: &eta},
+			want:   "进度 mysql-bin.000012:1024 45.2% 12000事件/s 输出300行 剩余1m30s",
+		},
+		{
+			name:   "结束时输出用时",
+			report: progressReport{File: "mysql-bin.000012", Pos: 1024, Percent: &percent, EventsPerSec: 12000, ElapsedSeconds: 61.5, Final: true},
+			want:   "进度 mysql-bin.000012:1024 45.2% 12000事件/s 用时1m1.5s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := progressLine(&tt.report); got != tt.want {
+				t.Errorf("progressLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinlogInRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		start string
+		stop  string
+		want  bool
+	}{
+		{name: "mysql-bin.000002", start: "mysql-bin.000001", stop: "mysql-bin.000003", want: true},
+		{name: "mysql-bin.000001", start: "mysql-bin.000001", stop: "mysql-bin.000001", want: true},
+		{name: "mysql-bin.000004", start: "mysql-bin.000001", stop: "mysql-bin.000003"},
+		{name: "mysql-bin.1000000", start: "mysql-bin.999999", stop: "mysql-bin.1000001", want: true},
+		{name: "mysql-bin.999999", start: "mysql-bin.999998", stop: "mysql-bin.1000000", want: true},
+		{name: "mysql-bin.1000002", start: "mysql-bin.999999", stop: "mysql-bin.1000001"},
+		{name: "mysql-bin.999998", start: "mysql-bin.999999", stop: "mysql-bin.1000001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.start+"-"+tt.stop, func(t *testing.T) {
+			if got := binlogInRange(tt.name, tt.start, tt.stop); got != tt.want {
+				t.Errorf("binlogInRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
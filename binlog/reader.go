@@ -0,0 +1,362 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"context"
+	"fmt"
+	"github.com/dhbin/ra/binlog/catalog"
+	"github.com/dhbin/ra/binlog/ddl"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/parse"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/client"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// Options Reader参数
+type Options struct {
+	// 数据库连接，解析本地binlog时也需要用于获取表结构
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Socket 指定时通过unix socket连接
+	Socket string
+	// SSLMode 支持disabled、required、verify_ca、verify_identity，见config.BinlogConfig.TLSConfig
+	SSLMode string
+	SSLCA   string
+	SSLCert string
+	SSLKey  string
+	// Flavor 支持mysql、mariadb，为空时为mysql
+	Flavor string
+	// ServerID 读取远程binlog时使用的server id，不能与其它从库重复，为0时随机生成
+	ServerID uint32
+
+	// Local 为true时StartFile为本地binlog文件路径，StopFile为同一目录中的文件名
+	Local bool
+	// Parallel 本地解析多个文件时同时解码的文件数，为0时依次解码
+	Parallel int
+
+	// 解析范围
+	StartFile     string
+	StopFile      string
+	StartPosition uint32
+	StopPosition  uint32
+	StartTime     *time.Time
+	StopTime      *time.Time
+
+	// 过滤条件
+	Database string
+	Tables   []string
+	// Actions 只解析指定类型，支持insert、update、delete，为空时全部解析
+	Actions []string
+	// DDL 是否输出ddl语句
+	DDL bool
+	// SchemaFile 表结构文件，如mysqldump --no-data的输出，优先于数据库中的表结构，闪回ddl时用于生成反向ddl
+	SchemaFile string
+
+	// ProgressFormat 解析进度在stderr的输出格式，支持ProgressText、ProgressJSON，为空时不输出
+	ProgressFormat string
+	// ProgressInterval 输出进度的间隔，为0时终端中text格式、json格式为1s，其它为10s
+	ProgressInterval time.Duration
+
+	// Flashback 为true时输出反向变更
+	Flashback bool
+
+	// 以下参数只用于Write
+	// Format 输出格式，支持sink.Names()中的格式，为空时为sql
+	Format string
+	// OutDir csv、parquet等按表输出的格式的输出目录
+	OutDir string
+	// MaskRules 脱敏规则，格式见sql.NewMasker
+	MaskRules []string
+	MaskSalt  string
+	// OnError sql格式无法生成sql时的处理方式，支持fail、skip、comment，默认fail
+	OnError string
+}
+
+// Reader 解析binlog，将结构化的变更写入Sink
+type Reader struct {
+	config    *config.BinlogConfig
+	flashback bool
+	position  event.Position
+	// provider 表结构，为nil时Run按config创建
+	provider catalog.Provider
+}
+
+// NewReader 创建Reader
+func NewReader(options *Options) *Reader {
+	actions := options.Actions
+	if len(actions) == 0 {
+		actions = []string{canal.InsertAction, canal.UpdateAction, canal.DeleteAction}
+	}
+	c := &config.BinlogConfig{
+		Host:     options.Host,
+		Port:     options.Port,
+		Username: options.Username,
+		Password: options.Password,
+		Socket:   options.Socket,
+		SSLMode:  options.SSLMode,
+		SSLCA:    options.SSLCA,
+		SSLCert:  options.SSLCert,
+		SSLKey:   options.SSLKey,
+		Flavor:   options.Flavor,
+		ServerID: options.ServerID,
+
+		StartBinlogName: options.StartFile,
+		StopBinlogName:  options.StopFile,
+		StartPosition:   options.StartPosition,
+		StopPosition:    options.StopPosition,
+		StartDatetime:   options.StartTime,
+		StopDatetime:    options.StopTime,
+
+		Database: options.Database,
+		Tables:   options.Tables,
+		SqlTypes: actions,
+		DDL:      options.DDL,
+
+		SchemaFile: options.SchemaFile,
+
+		ProgressFormat:   options.ProgressFormat,
+		ProgressInterval: options.ProgressInterval,
+
+		Local:    options.Local,
+		Parallel: options.Parallel,
+
+		Format:    options.Format,
+		OutDir:    options.OutDir,
+		MaskRules: options.MaskRules,
+		MaskSalt:  options.MaskSalt,
+		OnError:   options.OnError,
+	}
+	if c.Format == "" {
+		c.Format = "sql"
+	}
+	if c.StopBinlogName == "" {
+		c.StopBinlogName = c.StartBinlogName
+	}
+	if c.StartPosition == 0 {
+		c.StartPosition = 4
+	}
+	return newReader(c, options.Flashback)
+}
+
+func newReader(config *config.BinlogConfig, flashback bool) *Reader {
+	return &Reader{config: config, flashback: flashback}
+}
+
+// Run 解析binlog直到终止位置、ctx取消或出错，变更依次写入sink，结束时关闭sink。
+// 到达终止位置时返回nil，ctx取消或出错时丢弃未完成的事务
+func (r *Reader) Run(ctx context.Context, sink event.Sink) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	provider := r.provider
+	if provider == nil {
+		chain, err := newProvider(r.config)
+		if err != nil {
+			_ = sink.Close()
+			return err
+		}
+		defer chain.Close()
+		provider = chain
+	}
+	progress := newProgress(r.config, true)
+	defer progress.Close()
+	sink = progress.wrap(sink)
+
+	done := make(chan interface{}, 1)
+	var handler interface {
+		canal.EventHandler
+		Discard() error
+		Close() error
+		Position() event.Position
+	}
+	if r.flashback {
+		h := &event.FlashbackHandler{}
+		h.Config, h.Done, h.Sink = r.config, done, sink
+		if r.config.DDL && r.config.SchemaFile != "" {
+			h.Tracker = ddl.NewTracker()
+			if err := h.Tracker.LoadFile(r.config.SchemaFile); err != nil {
+				_ = sink.Close()
+				return fmt.Errorf("加载表结构文件失败: %w", err)
+			}
+		}
+		handler = h
+	} else {
+		h := &event.ToSqlHandler{}
+		h.Config, h.Done, h.Sink = r.config, done, sink
+		handler = h
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if r.config.Local {
+			errCh <- r.runLocal(ctx, handler, provider, progress)
+		} else {
+			errCh <- r.runRemote(ctx, handler, provider, progress)
+		}
+	}()
+
+	var err error
+	select {
+	case <-done:
+		// 到达终止位置
+		cancel()
+		<-errCh
+	case err = <-errCh:
+	}
+	if err != nil {
+		_ = handler.Discard()
+	}
+	if closeErr := handler.Close(); err == nil {
+		err = closeErr
+	}
+	r.position = handler.Position()
+	if r.position.File != "" && r.config.Local {
+		// 本地解析时继续解析需要文件路径
+		r.position.File = filepath.Join(filepath.Dir(r.config.StartBinlogName), r.position.File)
+	}
+	return err
+}
+
+// Position Run结束后最后处理完成的位置，从该位置继续解析不会重复输出已完成的事务。
+// 还没有处理完成任何事务时File为空
+func (r *Reader) Position() event.Position {
+	return r.position
+}
+
+// Each 以回调方式获取行变更，fn返回错误时停止解析
+func (r *Reader) Each(ctx context.Context, fn func(change *event.RowChange) error) error {
+	return r.Handle(ctx, Callbacks{Row: fn})
+}
+
+// Handle 以回调方式获取事务边界、行变更和ddl，回调返回错误时停止解析
+func (r *Reader) Handle(ctx context.Context, callbacks Callbacks) error {
+	return r.Run(ctx, &callbackSink{callbacks: callbacks})
+}
+
+// Write 按Options.Format输出变更，sql、jsonl等格式写入out，csv、parquet写入Options.OutDir，
+// 按Options.MaskRules脱敏。闪回时与命令行一样倒序输出
+func (r *Reader) Write(ctx context.Context, out io.Writer) error {
+	s, err := newSink(r.config, out, r.flashback)
+	if err != nil {
+		return err
+	}
+	return r.Run(ctx, s)
+}
+
+func (r *Reader) runLocal(ctx context.Context, handler canal.EventHandler, provider catalog.Provider, progress *progress) error {
+	parser, err := parse.NewLocalFileParser(r.config, provider)
+	if err != nil {
+		return err
+	}
+	if progress != nil {
+		parser.SetProgress(progress.event)
+	}
+	return parser.Run(ctx, handler)
+}
+
+func (r *Reader) runRemote(ctx context.Context, handler canal.EventHandler, provider catalog.Provider, progress *progress) error {
+	parser, err := parse.NewRemoteParser(r.config, provider)
+	if err != nil {
+		return err
+	}
+	if progress != nil {
+		parser.SetProgress(progress.event)
+	}
+	return parser.Run(ctx, handler)
+}
+
+// newProvider 表结构依次从binlog元数据、表结构文件、数据库获取，snapshots为额外的表结构文件
+func newProvider(config *config.BinlogConfig, snapshots ...string) (catalog.Chain, error) {
+	var providers catalog.Chain
+	files := snapshots
+	if config.SchemaFile != "" {
+		files = append([]string{config.SchemaFile}, snapshots...)
+	}
+	if len(files) > 0 {
+		file, err := catalog.NewFile(config.Database, files...)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, file)
+	}
+	providers = append(providers, catalog.NewDB(func() (*client.Conn, error) {
+		return connect(config)
+	}))
+	return catalog.Chain{catalog.NewMetadata(), catalog.NewCache(providers)}, nil
+}
+
+// Callbacks Handle的回调，为nil的回调被忽略
+type Callbacks struct {
+	// Begin 事务开始，在事务的第一行变更之前调用
+	Begin func(tx *event.Transaction) error
+	Row   func(change *event.RowChange) error
+	// DDL Options.DDL为true时才会回调
+	DDL func(ddl *event.DDLChange) error
+	// Commit 事务结束，此时Transaction.XID已经填充
+	Commit func(tx *event.Transaction) error
+	// Discard 解析中断或出错时调用，已回调的未完成事务的行变更需要丢弃
+	Discard func(tx *event.Transaction) error
+}
+
+// callbackSink 将变更交给回调函数
+type callbackSink struct {
+	callbacks Callbacks
+}
+
+func (s *callbackSink) Begin(tx *event.Transaction) error {
+	if s.callbacks.Begin == nil {
+		return nil
+	}
+	return s.callbacks.Begin(tx)
+}
+
+func (s *callbackSink) Row(change *event.RowChange) error {
+	if s.callbacks.Row == nil {
+		return nil
+	}
+	return s.callbacks.Row(change)
+}
+
+func (s *callbackSink) DDL(change *event.DDLChange) error {
+	if s.callbacks.DDL == nil {
+		return nil
+	}
+	return s.callbacks.DDL(change)
+}
+
+func (s *callbackSink) Commit(tx *event.Transaction) error {
+	if s.callbacks.Commit == nil {
+		return nil
+	}
+	return s.callbacks.Commit(tx)
+}
+
+func (s *callbackSink) Discard(tx *event.Transaction) error {
+	if s.callbacks.Discard == nil {
+		return nil
+	}
+	return s.callbacks.Discard(tx)
+}
+
+func (s *callbackSink) Close() error { return nil }
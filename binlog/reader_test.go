@@ -0,0 +1,38 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewReader(t *testing.T) {
+	r := NewReader(&Options{
+		StartFile:        "mysql-bin.000001",
+		SchemaFile:       "schema.sql",
+		ProgressFormat:   ProgressJSON,
+		ProgressInterval: 5 * time.Second,
+	})
+	c := r.config
+	if c.SchemaFile != "schema.sql" || c.ProgressFormat != ProgressJSON || c.ProgressInterval != 5*time.Second {
+		t.Errorf("SchemaFile、ProgressFormat、ProgressInterval没有传递到config: %+v", c)
+	}
+	if c.Format != "sql" || c.StopBinlogName != "mysql-bin.000001" || c.StartPosition != 4 || len(c.SqlTypes) != 3 {
+		t.Errorf("默认值错误: %+v", c)
+	}
+}
@@ -0,0 +1,296 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	dbsql "database/sql"
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"github.com/go-mysql-org/go-mysql/canal"
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-sql-driver/mysql"
+	"io"
+	"os"
+)
+
+const (
+	// ApplyCheckAbort 影响行数不为1时回滚当前批次并停止
+	ApplyCheckAbort = "abort"
+	// ApplyCheckReport 影响行数不为1时输出到ErrOut并继续
+	ApplyCheckReport = "report"
+)
+
+// ApplyOptions 直接在目标库执行生成的sql
+type ApplyOptions struct {
+	// DSN 目标库，格式为user:password@tcp(host:port)/
+	DSN string
+	// DryRun 只输出将要执行的sql和事务边界，不连接目标库
+	DryRun bool
+	// Batch 每多少个原始事务提交一次，默认1，即保持原始事务边界
+	Batch int
+	// Check 影响行数不为1时的处理方式，支持abort、report，默认abort
+	Check string
+	// ProgressFile 记录已提交的位置，重新执行时跳过已执行的事务
+	ProgressFile string
+	// Reverse 事务按binlog位置倒序执行，如闪回，之前执行时已提交的是位置在进度之后的事务
+	Reverse bool
+}
+
+// ApplyProgress 已提交的位置
+type ApplyProgress struct {
+	File string `json:"file"`
+	Pos  uint32 `json:"pos"`
+	GTID string `json:"gtid,omitempty"`
+}
+
+// ApplySink 按原始事务边界在目标库执行sql
+type ApplySink struct {
+	Base
+	options *ApplyOptions
+	out     io.Writer
+	errOut  io.Writer
+	builder *sql.Builder
+	db      *dbsql.DB
+
+	progress *ApplyProgress
+	tx       *dbsql.Tx
+	pending  int
+	last     *event.Transaction
+	skip     bool
+}
+
+// NewApplySink 创建ApplySink，dry run时sql输出到options.Out。
+// 脱敏后的数据不能写入目标库，options.Masker有脱敏规则时返回错误
+func NewApplySink(options *Options, apply *ApplyOptions) (*ApplySink, error) {
+	if options.Masker.Enabled() {
+		return nil, fmt.Errorf("apply-to不能与mask同时使用，脱敏后的数据不能写入目标库")
+	}
+	if apply.Batch <= 0 {
+		apply.Batch = 1
+	}
+	switch apply.Check {
+	case "":
+		apply.Check = ApplyCheckAbort
+	case ApplyCheckAbort, ApplyCheckReport:
+	default:
+		return nil, fmt.Errorf("不支持的影响行数检查方式: %s，支持abort,report", apply.Check)
+	}
+	errOut := options.ErrOut
+	if errOut == nil {
+		errOut = os.Stderr
+	}
+	s := &ApplySink{
+		options: apply,
+		out:     options.Out,
+		errOut:  errOut,
+		builder: &sql.Builder{},
+	}
+	if apply.ProgressFile != "" {
+		progress := &ApplyProgress{}
+		ok, err := readStateFile(apply.ProgressFile, progress)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.progress = progress
+		}
+	}
+	if !apply.DryRun {
+		cfg, err := mysql.ParseDSN(apply.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("目标库DSN格式错误: %w", err)
+		}
+		// update影响行数按匹配行数计算，值未变化时也为1
+		cfg.ClientFoundRows = true
+		db, err := dbsql.Open("mysql", cfg.FormatDSN())
+		if err != nil {
+			return nil, err
+		}
+		db.SetMaxOpenConns(1)
+		if err := db.Ping(); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		s.db = db
+	}
+	return s, nil
+}
+
+func (s *ApplySink) Begin(tx *event.Transaction) error {
+	if s.applied(tx) {
+		s.skip = true
+		return nil
+	}
+	if s.pending > 0 {
+		return nil
+	}
+	if s.options.DryRun {
+		_, err := fmt.Fprintln(s.out, "BEGIN;")
+		return err
+	}
+	t, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	s.tx = t
+	return nil
+}
+
+func (s *ApplySink) Row(c *event.RowChange) error {
+	if s.skip {
+		return nil
+	}
+	var stmt string
+	var err error
+	switch c.Action {
+	case canal.InsertAction:
+		stmt, err = s.builder.BuildInsertSql(c.Table, c.After)
+	case canal.UpdateAction:
+		stmt, err = s.builder.BuildUpdateSql(c.Table, c.Before, c.After)
+	case canal.DeleteAction:
+		stmt, err = s.builder.BuildDeleteSql(c.Table, c.Before)
+	default:
+		return nil
+	}
+	if err != nil {
+		return s.abort(fmt.Errorf("%s pos %d: %w", c.File, c.Pos, err))
+	}
+	if s.options.DryRun {
+		_, err = fmt.Fprintf(s.out, "%s # pos %d timestamp %d\n", stmt, c.Pos, c.Timestamp)
+		return err
+	}
+	result, err := s.tx.Exec(stmt)
+	if err != nil {
+		return s.abort(fmt.Errorf("%s pos %d 执行失败: %w", c.File, c.Pos, err))
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return s.abort(err)
+	}
+	if affected != 1 {
+		msg := fmt.Sprintf("%s pos %d %s.%s %s影响行数为%d: %s", c.File, c.Pos, c.Table.Schema, c.Table.Name, c.Action, affected, stmt)
+		if s.options.Check == ApplyCheckAbort {
+			return s.abort(fmt.Errorf("%s", msg))
+		}
+		_, _ = fmt.Fprintln(s.errOut, msg)
+	}
+	return nil
+}
+
+func (s *ApplySink) Commit(tx *event.Transaction) error {
+	if s.skip {
+		s.skip = false
+		return nil
+	}
+	s.pending++
+	s.last = tx
+	if s.pending < s.options.Batch {
+		return nil
+	}
+	return s.commit()
+}
+
+// DDL 不执行ddl，只有闪回时恢复被删除数据产生的ddl事件（没有反向ddl）可以忽略
+func (s *ApplySink) DDL(c *event.DDLChange) error {
+	if c.Flashback && c.Reverse == nil {
+		return nil
+	}
+	return fmt.Errorf("%s pos %d: apply-to不支持执行ddl: %s", c.File, c.Pos, c.Query)
+}
+
+// Discard 回滚当前批次，已提交的位置保存在进度文件中，重新执行时当前批次会再次执行
+func (s *ApplySink) Discard(*event.Transaction) error {
+	if s.skip && s.pending == 0 {
+		// 已执行过的事务，没有开始批次
+		s.skip = false
+		return nil
+	}
+	s.skip = false
+	s.pending = 0
+	if s.options.DryRun {
+		_, err := fmt.Fprintln(s.out, "ROLLBACK;")
+		return err
+	}
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Rollback()
+	s.tx = nil
+	return err
+}
+
+// Close 提交最后一个批次
+func (s *ApplySink) Close() error {
+	var err error
+	if s.pending > 0 {
+		err = s.commit()
+	} else if s.tx != nil {
+		// 未结束的事务不提交
+		err = s.tx.Rollback()
+		s.tx = nil
+	}
+	if s.db != nil {
+		if closeErr := s.db.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (s *ApplySink) commit() error {
+	if s.options.DryRun {
+		if _, err := fmt.Fprintln(s.out, "COMMIT;"); err != nil {
+			return err
+		}
+	} else {
+		err := s.tx.Commit()
+		s.tx = nil
+		if err != nil {
+			return err
+		}
+	}
+	s.pending = 0
+	s.progress = &ApplyProgress{File: s.last.File, Pos: s.last.Pos, GTID: s.last.GTID}
+	if s.options.ProgressFile == "" || s.options.DryRun {
+		return nil
+	}
+	return writeStateFile(s.options.ProgressFile, s.progress)
+}
+
+// abort 回滚当前批次，已提交的位置保存在进度文件中
+func (s *ApplySink) abort(err error) error {
+	if s.tx != nil {
+		_ = s.tx.Rollback()
+		s.tx = nil
+	}
+	s.pending = 0
+	return err
+}
+
+// applied 事务是否已在之前的执行中提交，文件名按序号比较
+func (s *ApplySink) applied(tx *event.Transaction) bool {
+	if s.progress == nil {
+		return false
+	}
+	committed := gomysql.Position{Name: s.progress.File, Pos: s.progress.Pos}
+	cmp := gomysql.Position{Name: tx.File, Pos: tx.Pos}.Compare(committed)
+	if s.options.Reverse {
+		return cmp >= 0
+	}
+	return cmp <= 0
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bytes"
+	"github.com/dhbin/ra/binlog/ddl"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"testing"
+)
+
+func TestApplySinkApplied(t *testing.T) {
+	progress := &ApplyProgress{File: "mysql-bin.999999", Pos: 1000}
+	tests := []struct {
+		name    string
+		reverse bool
+		file    string
+		pos     uint32
+		want    bool
+	}{
+		{name: "之前的文件", file: "mysql-bin.999998", pos: 5000, want: true},
+		{name: "同一文件之前的位置", file: "mysql-bin.999999", pos: 500, want: true},
+		{name: "已提交的位置", file: "mysql-bin.999999", pos: 1000, want: true},
+		{name: "同一文件之后的位置", file: "mysql-bin.999999", pos: 1500, want: false},
+		{name: "序号位数增加", file: "mysql-bin.1000000", pos: 4, want: false},
+		{name: "倒序时之前的位置", reverse: true, file: "mysql-bin.999999", pos: 500, want: false},
+		{name: "倒序时已提交的位置", reverse: true, file: "mysql-bin.999999", pos: 1000, want: true},
+		{name: "倒序时之后的文件", reverse: true, file: "mysql-bin.1000000", pos: 4, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &ApplySink{options: &ApplyOptions{Reverse: tt.reverse}, progress: progress}
+			tx := &event.Transaction{Position: event.Position{File: tt.file, Pos: tt.pos}}
+			if got := s.applied(tx); got != tt.want {
+				t.Errorf("applied(%s:%d) = %v, want %v", tt.file, tt.pos, got, tt.want)
+			}
+		})
+	}
+	if (&ApplySink{options: &ApplyOptions{}}).applied(&event.Transaction{}) {
+		t.Error("没有进度时不应跳过事务")
+	}
+}
+
+func TestNewApplySinkMask(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []string
+		wantErr bool
+	}{
+		{name: "不脱敏"},
+		{name: "脱敏", rules: []string{"test.user.phone:redact"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			masker, err := sql.NewMasker(tt.rules, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = NewApplySink(&Options{Out: &bytes.Buffer{}, Masker: masker}, &ApplyOptions{DryRun: true})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewApplySink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplySinkDDL(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  *event.DDLChange
+		wantErr bool
+	}{
+		{name: "ddl", change: &event.DDLChange{Query: "alter table t add column c int"}, wantErr: true},
+		{name: "反向ddl", change: &event.DDLChange{Query: "alter table t add column c int", Flashback: true, Reverse: &ddl.Reverse{}}, wantErr: true},
+		{name: "只恢复数据", change: &event.DDLChange{Query: "drop table t", Flashback: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewApplySink(&Options{Out: &bytes.Buffer{}}, &ApplyOptions{DryRun: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.DDL(tt.change); (err != nil) != tt.wantErr {
+				t.Errorf("DDL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
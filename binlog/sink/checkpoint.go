@@ -0,0 +1,115 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// Checkpoint 已处理完成的位置
+type Checkpoint struct {
+	File string `json:"file"`
+	Pos  uint32 `json:"pos"`
+	// GTIDSet 已处理的gtid集合，没有开启gtid时为空
+	GTIDSet string `json:"gtid_set,omitempty"`
+}
+
+// ReadCheckpoint 读取状态文件，文件不存在时返回nil
+func ReadCheckpoint(file string) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{}
+	ok, err := readStateFile(file, checkpoint)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// CheckpointSink 每个事务、ddl处理完成后记录位置和已处理的gtid集合到状态文件，并在事务边界切换输出文件。
+// 被过滤条件排除的事务也会记录位置
+type CheckpointSink struct {
+	sink    event.Sink
+	file    string
+	rotate  *RotateWriter
+	gtidSet mysql.GTIDSet
+}
+
+// NewCheckpointSink 创建CheckpointSink，checkpoint为上次记录的位置，file为空时不记录位置，rotate为nil时不切换输出文件
+func NewCheckpointSink(s event.Sink, file string, flavor string, checkpoint *Checkpoint, rotate *RotateWriter) (*CheckpointSink, error) {
+	gtidSet := ""
+	if checkpoint != nil {
+		gtidSet = checkpoint.GTIDSet
+	}
+	set, err := mysql.ParseGTIDSet(flavor, gtidSet)
+	if err != nil {
+		return nil, fmt.Errorf("状态文件%s gtid_set格式错误: %w", file, err)
+	}
+	return &CheckpointSink{sink: s, file: file, rotate: rotate, gtidSet: set}, nil
+}
+
+func (s *CheckpointSink) Begin(tx *event.Transaction) error {
+	return s.sink.Begin(tx)
+}
+
+func (s *CheckpointSink) Row(c *event.RowChange) error {
+	return s.sink.Row(c)
+}
+
+func (s *CheckpointSink) DDL(c *event.DDLChange) error {
+	return s.sink.DDL(c)
+}
+
+func (s *CheckpointSink) Commit(tx *event.Transaction) error {
+	if err := s.sink.Commit(tx); err != nil {
+		return err
+	}
+	if s.rotate != nil {
+		return s.rotate.Rotate()
+	}
+	return nil
+}
+
+func (s *CheckpointSink) Discard(tx *event.Transaction) error {
+	if discarder, ok := s.sink.(event.Discarder); ok {
+		return discarder.Discard(tx)
+	}
+	return nil
+}
+
+func (s *CheckpointSink) Close() error {
+	err := s.sink.Close()
+	if s.rotate != nil {
+		if closeErr := s.rotate.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Checkpoint 事务、ddl处理完成后记录位置，gtid加入已处理的gtid集合
+func (s *CheckpointSink) Checkpoint(pos event.Position, gtid string) error {
+	if gtid != "" {
+		if err := s.gtidSet.Update(gtid); err != nil {
+			return fmt.Errorf("%s pos %d gtid格式错误: %w", pos.File, pos.Pos, err)
+		}
+	}
+	if s.file == "" {
+		return nil
+	}
+	return writeStateFile(s.file, &Checkpoint{File: pos.File, Pos: pos.Pos, GTIDSet: s.gtidSet.String()})
+}
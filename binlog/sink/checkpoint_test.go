@@ -0,0 +1,133 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"github.com/dhbin/ra/binlog/event"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointSink(t *testing.T) {
+	pos := func(file string, p uint32) event.Position {
+		return event.Position{File: file, Pos: p}
+	}
+	const uuid = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	tests := []struct {
+		name       string
+		flavor     string
+		checkpoint *Checkpoint
+		run        func(s *CheckpointSink) error
+		want       *Checkpoint
+		wantErr    bool
+	}{
+		{name: "处理完成后记录", run: func(s *CheckpointSink) error {
+			tx := &event.Transaction{Position: pos("mysql-bin.000001", 100)}
+			_ = s.Begin(tx)
+			_ = s.Commit(tx)
+			return s.Checkpoint(tx.Position, "")
+		}, want: &Checkpoint{File: "mysql-bin.000001", Pos: 100}},
+		{name: "被过滤的事务也记录", run: func(s *CheckpointSink) error {
+			return s.Checkpoint(pos("mysql-bin.000002", 200), uuid+":3")
+		}, want: &Checkpoint{File: "mysql-bin.000002", Pos: 200, GTIDSet: uuid + ":3"}},
+		{name: "提交时不记录", run: func(s *CheckpointSink) error {
+			tx := &event.Transaction{Position: pos("mysql-bin.000001", 100)}
+			_ = s.Begin(tx)
+			return s.Commit(tx)
+		}, want: nil},
+		{name: "丢弃的事务不记录", run: func(s *CheckpointSink) error {
+			tx := &event.Transaction{Position: pos("mysql-bin.000001", 100)}
+			_ = s.Begin(tx)
+			return s.Discard(tx)
+		}, want: nil},
+		{name: "合并gtid集合", checkpoint: &Checkpoint{GTIDSet: uuid + ":1-5"}, run: func(s *CheckpointSink) error {
+			if err := s.Checkpoint(pos("mysql-bin.000003", 300), uuid+":6"); err != nil {
+				return err
+			}
+			return s.Checkpoint(pos("mysql-bin.000003", 400), "")
+		}, want: &Checkpoint{File: "mysql-bin.000003", Pos: 400, GTIDSet: uuid + ":1-6"}},
+		{name: "mariadb", flavor: "mariadb", run: func(s *CheckpointSink) error {
+			_ = s.Checkpoint(pos("mysql-bin.000001", 100), "0-1-7")
+			return s.Checkpoint(pos("mysql-bin.000001", 200), "0-1-8")
+		}, want: &Checkpoint{File: "mysql-bin.000001", Pos: 200, GTIDSet: "0-1-8"}},
+		{name: "gtid格式错误", run: func(s *CheckpointSink) error {
+			return s.Checkpoint(pos("mysql-bin.000001", 100), "abc")
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flavor := tt.flavor
+			if flavor == "" {
+				flavor = "mysql"
+			}
+			file := filepath.Join(t.TempDir(), "state")
+			s, err := NewCheckpointSink(&Base{}, file, flavor, tt.checkpoint, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.run(s); (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := ReadCheckpoint(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadCheckpoint() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if _, err := NewCheckpointSink(&Base{}, "", "mysql", &Checkpoint{GTIDSet: "abc"}, nil); err == nil {
+		t.Error("状态文件gtid_set格式错误时NewCheckpointSink()应返回错误")
+	}
+}
+
+func TestReadCheckpoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    *Checkpoint
+		wantErr bool
+	}{
+		{name: "位置", data: `{"file":"mysql-bin.000003","pos":4}`, want: &Checkpoint{File: "mysql-bin.000003", Pos: 4}},
+		{name: "gtid集合", data: `{"file":"mysql-bin.000003","pos":4,"gtid_set":"a:1-2"}`, want: &Checkpoint{File: "mysql-bin.000003", Pos: 4, GTIDSet: "a:1-2"}},
+		{name: "格式错误", data: `{`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "state")
+			if err := os.WriteFile(file, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadCheckpoint(file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadCheckpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadCheckpoint() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if got, err := ReadCheckpoint(filepath.Join(t.TempDir(), "missing")); got != nil || err != nil {
+		t.Errorf("状态文件不存在时ReadCheckpoint() = %v, %v", got, err)
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+)
+
+const (
+	// Csv 输出format
+	Csv = "csv"
+	// Tsv 输出format
+	Tsv = "tsv"
+)
+
+func init() {
+	Register(Csv, func(options *Options) (event.Sink, error) {
+		return NewCsvSink(options.OutDir, false, options.Masker)
+	})
+	Register(Tsv, func(options *Options) (event.Sink, error) {
+		return NewCsvSink(options.OutDir, true, options.Masker)
+	})
+}
+
+var csvMetaColumns = []string{"action", "pos", "timestamp", "gtid", "image"}
+
+// CsvSink 按表输出csv文件，每张表一个文件。事务中的记录在提交时写入文件，中断时不会写入不完整的事务。
+// 表的字段变化后（如ALTER TABLE）写入新的文件db.table.N，新文件使用新的表头
+type CsvSink struct {
+	Base
+	dir    string
+	comma  rune
+	ext    string
+	masker *sql.Masker
+	tabs   map[string]*csvTable
+	// rolled 字段变化前的文件，关闭时才关闭
+	rolled []*csvTable
+}
+
+// csvTable 单张表的csv文件，writer写入pending，提交时写入file
+type csvTable struct {
+	file    *os.File
+	writer  *csv.Writer
+	pending spool
+	columns []string
+	// seq 字段变化的次数，用于生成文件名
+	seq int
+}
+
+// NewCsvSink tsv为true时输出tsv文件
+func NewCsvSink(dir string, tsv bool, masker *sql.Masker) (*CsvSink, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("csv、tsv格式需要指定输出目录out-dir")
+	}
+	w := &CsvSink{
+		dir:    dir,
+		comma:  ',',
+		ext:    ".csv",
+		masker: masker,
+		tabs:   make(map[string]*csvTable),
+	}
+	if tsv {
+		w.comma = '\t'
+		w.ext = ".tsv"
+	}
+	return w, nil
+}
+
+// Row 写入行变更，update会写入before、after两行
+func (w *CsvSink) Row(c *event.RowChange) error {
+	if (c.Before != nil && len(c.Before) != len(c.Table.Columns)) || (c.After != nil && len(c.After) != len(c.Table.Columns)) {
+		return fmt.Errorf("表%s.%s有%d个字段，行变更中的值数量不一致，表结构与binlog不匹配", c.Table.Schema, c.Table.Name, len(c.Table.Columns))
+	}
+	writer, err := w.writer(c)
+	if err != nil {
+		return err
+	}
+	if c.Before != nil {
+		if err := writer.Write(csvRecord(c, "before", rowImage(w.masker, c.Table, c.Before))); err != nil {
+			return err
+		}
+	}
+	if c.After != nil {
+		if err := writer.Write(csvRecord(c, "after", rowImage(w.masker, c.Table, c.After))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commit 事务结束时将缓存写入文件
+func (w *CsvSink) Commit(*event.Transaction) error {
+	return w.Flush()
+}
+
+// Discard 丢弃未完成事务的记录
+func (w *CsvSink) Discard(*event.Transaction) error {
+	for _, t := range w.files() {
+		t.writer.Flush()
+		t.pending.Reset()
+	}
+	return nil
+}
+
+// files 当前的文件以及字段变化前的文件
+func (w *CsvSink) files() []*csvTable {
+	files := append([]*csvTable{}, w.rolled...)
+	for _, t := range w.tabs {
+		files = append(files, t)
+	}
+	return files
+}
+
+func (w *CsvSink) writer(c *event.RowChange) (*csv.Writer, error) {
+	key := c.Table.Schema + "." + c.Table.Name
+	columns := columnNames(c.Table)
+	seq := 0
+	if t, ok := w.tabs[key]; ok {
+		if reflect.DeepEqual(t.columns, columns) {
+			return t.writer, nil
+		}
+		// 字段变化，之前的文件保留到关闭，未提交的记录仍然写入之前的文件
+		w.rolled = append(w.rolled, t)
+		seq = t.seq + 1
+	}
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return nil, err
+	}
+	name := key + w.ext
+	if seq > 0 {
+		name = fmt.Sprintf("%s.%d%s", key, seq, w.ext)
+	}
+	file, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	// 表头直接写入文件
+	header := csv.NewWriter(file)
+	header.Comma = w.comma
+	if err := header.Write(append(append([]string{}, csvMetaColumns...), columns...)); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	header.Flush()
+	if err := header.Error(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	t := &csvTable{file: file, columns: columns, seq: seq}
+	t.writer = csv.NewWriter(&t.pending)
+	t.writer.Comma = w.comma
+	w.tabs[key] = t
+	return t.writer, nil
+}
+
+// Flush 将缓存写入文件
+func (w *CsvSink) Flush() error {
+	for _, t := range w.files() {
+		t.writer.Flush()
+		if err := t.writer.Error(); err != nil {
+			return err
+		}
+		if _, err := t.pending.WriteTo(t.file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 关闭所有文件
+func (w *CsvSink) Close() error {
+	err := w.Flush()
+	for _, t := range w.files() {
+		t.pending.Reset()
+		if closeErr := t.file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func csvRecord(c *event.RowChange, image string, values map[string]interface{}) []string {
+	record := make([]string, 0, len(csvMetaColumns)+len(c.Table.Columns))
+	record = append(record, c.Action, strconv.FormatUint(uint64(c.Pos), 10), strconv.FormatUint(uint64(c.Timestamp), 10), c.Tx.GTID, image)
+	for i := range c.Table.Columns {
+		record = append(record, csvValue(values[c.Table.Columns[i].Name]))
+	}
+	return record
+}
+
+// csvNull csv中的NULL，与mysql LOAD DATA、pitr读取csv时一致
+const csvNull = `\N`
+
+// csvValue null输出为\N，二进制数据为base64
+func csvValue(val interface{}) string {
+	switch t := val.(type) {
+	case nil:
+		return csvNull
+	case string:
+		return t
+	case []byte:
+		return base64.StdEncoding.EncodeToString(t)
+	case json.RawMessage:
+		return string(t)
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCsvValue(t *testing.T) {
+	tests := []struct {
+		name string
+		val  interface{}
+		want string
+	}{
+		{name: "null", val: nil, want: `\N`},
+		{name: "空字符串", val: "", want: ""},
+		{name: "字符串", val: "a,b", want: "a,b"},
+		{name: "二进制", val: []byte{0, 1, 0xff}, want: "AAH/"},
+		{name: "json", val: json.RawMessage(`{"a":1}`), want: `{"a":1}`},
+		{name: "数字", val: int64(-1), want: "-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := csvValue(tt.val); got != tt.want {
+				t.Errorf("csvValue(%#v) = %q, want %q", tt.val, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCsvSink(t *testing.T) {
+	table := &schema.Table{
+		Schema: "test",
+		Name:   "t",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER, RawType: "int"},
+			{Name: "name", Type: schema.TYPE_STRING, RawType: "varchar(100)"},
+			{Name: "data", Type: schema.TYPE_BINARY, RawType: "varbinary(10)"},
+		},
+		PKColumns: []int{0},
+	}
+	tests := []struct {
+		name string
+		tsv  bool
+		ext  string
+	}{
+		{name: "csv", ext: ".csv"},
+		{name: "tsv", tsv: true, ext: ".tsv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := NewCsvSink(dir, tt.tsv, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx := &event.Transaction{GTID: "uuid:1"}
+			rows := []*event.RowChange{
+				{Position: event.Position{Pos: 100, Timestamp: 1}, Tx: tx, Table: table, Action: canal.InsertAction,
+					After: []interface{}{int32(1), nil, nil}},
+				{Position: event.Position{Pos: 200, Timestamp: 2}, Tx: tx, Table: table, Action: canal.UpdateAction,
+					Before: []interface{}{int32(1), "", []byte("ab")}, After: []interface{}{int32(1), "a \"b\",\n\tc", []byte{0xff}}},
+			}
+			for _, row := range rows {
+				if err := w.Row(row); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Commit(tx); err != nil {
+				t.Fatal(err)
+			}
+			// 丢弃的事务不写入文件
+			discarded := &event.Transaction{}
+			if err := w.Row(&event.RowChange{Tx: discarded, Table: table, Action: canal.DeleteAction, Before: []interface{}{int32(2), "x", nil}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Discard(discarded); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(filepath.Join(dir, "test.t"+tt.ext))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			reader := csv.NewReader(f)
+			if tt.tsv {
+				reader.Comma = '\t'
+			}
+			got, err := reader.ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := [][]string{
+				{"action", "pos", "timestamp", "gtid", "image", "id", "name", "data"},
+				{"insert", "100", "1", "uuid:1", "after", "1", `\N`, `\N`},
+				{"update", "200", "2", "uuid:1", "before", "1", "", "YWI="},
+				{"update", "200", "2", "uuid:1", "after", "1", "a \"b\",\n\tc", "/w=="},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("csv = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCsvSinkColumnsChanged(t *testing.T) {
+	before := &schema.Table{Schema: "test", Name: "t", Columns: []schema.TableColumn{{Name: "id", Type: schema.TYPE_NUMBER}}}
+	after := &schema.Table{Schema: "test", Name: "t", Columns: []schema.TableColumn{{Name: "id", Type: schema.TYPE_NUMBER}, {Name: "name", Type: schema.TYPE_STRING}}}
+	dir := t.TempDir()
+	w, err := NewCsvSink(dir, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &event.Transaction{GTID: "uuid:1"}
+	rows := []*event.RowChange{
+		{Tx: tx, Table: before, Action: canal.InsertAction, After: []interface{}{int32(1)}},
+		{Tx: tx, Table: after, Action: canal.InsertAction, After: []interface{}{int32(2), "b"}},
+	}
+	for _, row := range rows {
+		if err := w.Row(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 值的数量与表字段不一致
+	if err := w.Row(&event.RowChange{Tx: tx, Table: after, Action: canal.InsertAction, After: []interface{}{int32(3)}}); err == nil {
+		t.Error("值的数量与表字段不一致时应返回错误")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file string
+		want [][]string
+	}{
+		{file: "test.t.csv", want: [][]string{
+			{"action", "pos", "timestamp", "gtid", "image", "id"},
+			{"insert", "0", "0", "uuid:1", "after", "1"},
+		}},
+		{file: "test.t.1.csv", want: [][]string{
+			{"action", "pos", "timestamp", "gtid", "image", "id", "name"},
+			{"insert", "0", "0", "uuid:1", "after", "2", "b"},
+		}},
+	}
+	for _, tt := range tests {
+		f, err := os.Open(filepath.Join(dir, tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := csv.NewReader(f).ReadAll()
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
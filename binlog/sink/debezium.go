@@ -0,0 +1,230 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Debezium 输出format
+const Debezium = "debezium"
+
+func init() {
+	Register(Debezium, func(options *Options) (event.Sink, error) {
+		return NewJsonlSink(options.Out, options.Masker, func(c *event.RowChange, masker *sql.Masker) interface{} {
+			return newDebeziumEnvelope(c, masker)
+		}), nil
+	})
+}
+
+// DebeziumEnvelope Debezium MySQL connector格式的变更事件
+type DebeziumEnvelope struct {
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+	Source DebeziumSource         `json:"source"`
+	Op     string                 `json:"op"`
+	TsMs   int64                  `json:"ts_ms"`
+}
+
+// DebeziumSource 变更事件的来源信息
+type DebeziumSource struct {
+	Version   string  `json:"version"`
+	Connector string  `json:"connector"`
+	Name      string  `json:"name"`
+	TsMs      int64   `json:"ts_ms"`
+	Snapshot  string  `json:"snapshot"`
+	Db        string  `json:"db"`
+	Table     string  `json:"table"`
+	ServerId  uint32  `json:"server_id"`
+	Gtid      *string `json:"gtid"`
+	File      string  `json:"file"`
+	Pos       uint32  `json:"pos"`
+	Row       int     `json:"row"`
+	Thread    *uint32 `json:"thread"`
+	Query     *string `json:"query"`
+}
+
+var debeziumOps = map[string]string{
+	canal.InsertAction: "c",
+	canal.UpdateAction: "u",
+	canal.DeleteAction: "d",
+}
+
+func newDebeziumEnvelope(c *event.RowChange, masker *sql.Masker) interface{} {
+	e := &DebeziumEnvelope{
+		Before: debeziumImage(masker, c.Table, c.Before),
+		After:  debeziumImage(masker, c.Table, c.After),
+		Source: DebeziumSource{
+			Version:   config.Version,
+			Connector: "mysql",
+			Name:      "ra",
+			TsMs:      int64(c.Timestamp) * 1000,
+			Snapshot:  "false",
+			Db:        c.Table.Schema,
+			Table:     c.Table.Name,
+			ServerId:  c.ServerId,
+			File:      c.File,
+			Pos:       c.EventPos,
+			Row:       c.Row,
+		},
+		Op:   debeziumOps[c.Action],
+		TsMs: time.Now().UnixMilli(),
+	}
+	if c.Tx.GTID != "" {
+		gtid := c.Tx.GTID
+		e.Source.Gtid = &gtid
+	}
+	if c.Tx.Thread != 0 {
+		thread := c.Tx.Thread
+		e.Source.Thread = &thread
+	}
+	return e
+}
+
+// debeziumImage 与Debezium MySQL connector默认配置（time.precision.mode=adaptive_time_microseconds）输出的值一致
+func debeziumImage(masker *sql.Masker, table *schema.Table, row []interface{}) map[string]interface{} {
+	image := rowImage(masker, table, row)
+	if image == nil {
+		return nil
+	}
+	for i := range table.Columns {
+		column := &table.Columns[i]
+		if row[i] == nil || masker.Rule(table, column.Name) != nil {
+			continue
+		}
+		if v, ok := debeziumValue(column, row[i]); ok {
+			image[column.Name] = v
+		}
+	}
+	return image
+}
+
+// debeziumValue enum、set转为字符串，date为1970-01-01以来的天数，datetime为毫秒或微秒时间戳（精度大于3时），
+// timestamp为UTC的ISO-8601字符串，time为微秒数。不需要转换或无法解析时返回false，使用typedValue的值
+func debeziumValue(column *schema.TableColumn, val interface{}) (interface{}, bool) {
+	s := fmt.Sprint(val)
+	switch column.Type {
+	case schema.TYPE_ENUM:
+		idx, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		// 0为插入无效值时的空字符串
+		if idx == 0 {
+			return "", true
+		}
+		if idx > int64(len(column.EnumValues)) {
+			return nil, false
+		}
+		return column.EnumValues[idx-1], true
+	case schema.TYPE_SET:
+		bits, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		var values []string
+		for i, v := range column.SetValues {
+			if bits&(1<<uint(i)) != 0 {
+				values = append(values, v)
+			}
+		}
+		return strings.Join(values, ","), true
+	case schema.TYPE_DATE:
+		t, err := parseTime("2006-01-02", s, time.UTC)
+		if err != nil {
+			return nil, false
+		}
+		if t == nil {
+			return nil, true
+		}
+		return int32(t.Unix() / 86400), true
+	case schema.TYPE_DATETIME:
+		t, err := parseTime("2006-01-02 15:04:05", s, time.UTC)
+		if err != nil {
+			return nil, false
+		}
+		if t == nil {
+			return nil, true
+		}
+		if fsp(s) > 3 {
+			return t.UnixMicro(), true
+		}
+		return t.UnixMilli(), true
+	case schema.TYPE_TIMESTAMP:
+		t, err := parseTime("2006-01-02 15:04:05", s, timestampLocation)
+		if err != nil {
+			return nil, false
+		}
+		if t == nil {
+			return nil, true
+		}
+		layout := "2006-01-02T15:04:05"
+		if n := fsp(s); n > 0 {
+			layout += "." + strings.Repeat("0", n)
+		}
+		return t.UTC().Format(layout) + "Z", true
+	case schema.TYPE_TIME:
+		return microTime(s)
+	}
+	return nil, false
+}
+
+// fsp 时间字符串中秒的小数位数
+func fsp(s string) int {
+	if i := strings.LastIndex(s, "."); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+// microTime time转为微秒数，可以为负数或超过24小时
+func microTime(s string) (interface{}, bool) {
+	neg := strings.HasPrefix(s, "-")
+	parts := strings.Split(strings.TrimPrefix(s, "-"), ":")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	sec, frac, _ := strings.Cut(parts[2], ".")
+	var micros int64
+	for _, part := range []string{parts[0], parts[1], sec} {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		micros = micros*60 + n
+	}
+	micros *= 1000000
+	if frac != "" {
+		n, err := strconv.ParseInt((frac + "000000")[:6], 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		micros += n
+	}
+	if neg {
+		micros = -micros
+	}
+	return micros, true
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDebeziumEnvelope(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		tx     *event.Transaction
+		before []interface{}
+		after  []interface{}
+		op     string
+		gtid   *string
+		thread *uint32
+	}{
+		{name: "insert", action: canal.InsertAction, tx: &event.Transaction{},
+			after: []interface{}{int32(1), "a", "b"}, op: "c"},
+		{name: "update", action: canal.UpdateAction, tx: &event.Transaction{GTID: "uuid:1", Thread: 9},
+			before: []interface{}{int32(1), "a", "b"}, after: []interface{}{int32(1), "c", "b"}, op: "u",
+			gtid: strPtr("uuid:1"), thread: uint32Ptr(9)},
+		{name: "delete", action: canal.DeleteAction, tx: &event.Transaction{},
+			before: []interface{}{int32(1), "a", "b"}, op: "d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &event.RowChange{
+				Position: event.Position{File: "mysql-bin.000001", Pos: 200, EventPos: 150, Timestamp: 10, ServerId: 1},
+				Tx:       tt.tx, Table: testTable(), Action: tt.action, Before: tt.before, After: tt.after, Row: 2,
+			}
+			e := newDebeziumEnvelope(c, nil).(*DebeziumEnvelope)
+			if e.Op != tt.op {
+				t.Errorf("Op = %s, want %s", e.Op, tt.op)
+			}
+			if (e.Before == nil) != (tt.before == nil) || (e.After == nil) != (tt.after == nil) {
+				t.Errorf("Before = %v, After = %v", e.Before, e.After)
+			}
+			// source中的pos为事件的起始位置，与Debezium一致
+			if e.Source.Pos != 150 || e.Source.Row != 2 || e.Source.TsMs != 10000 || e.Source.ServerId != 1 ||
+				e.Source.Db != "test" || e.Source.Table != "user" || e.Source.File != "mysql-bin.000001" {
+				t.Errorf("Source = %+v", e.Source)
+			}
+			if !reflect.DeepEqual(e.Source.Gtid, tt.gtid) || !reflect.DeepEqual(e.Source.Thread, tt.thread) {
+				t.Errorf("Gtid = %v, Thread = %v", e.Source.Gtid, e.Source.Thread)
+			}
+		})
+	}
+}
+
+// TestDebeziumValues 值与Debezium MySQL connector一致
+func TestDebeziumValues(t *testing.T) {
+	defer func(loc *time.Location) { timestampLocation = loc }(timestampLocation)
+	timestampLocation = time.FixedZone("UTC+8", 8*3600)
+	table := &schema.Table{Schema: "test", Name: "t"}
+	for _, c := range [][2]string{
+		{"id", "int"}, {"status", "enum('new','done')"}, {"tags", "set('a','b','c')"}, {"d", "date"},
+		{"dt", "datetime"}, {"dt6", "datetime(6)"}, {"ts", "timestamp"}, {"ts3", "timestamp(3)"}, {"t", "time(6)"},
+	} {
+		table.AddColumn(c[0], c[1], "", "")
+	}
+	c := &event.RowChange{Tx: &event.Transaction{}, Table: table, Action: canal.InsertAction, After: []interface{}{
+		int32(1), int64(2), int64(5), "2023-01-02", "2023-01-02 03:04:05", "2023-01-02 03:04:05.123456",
+		"2023-01-02 08:00:00", "2023-01-02 08:00:00.500", "-01:02:03.5",
+	}}
+	e := newDebeziumEnvelope(c, nil).(*DebeziumEnvelope)
+	want := map[string]interface{}{
+		"id":     int32(1),
+		"status": "done",
+		"tags":   "a,c",
+		"d":      int32(19359),
+		"dt":     int64(1672628645000),
+		"dt6":    int64(1672628645123456),
+		"ts":     "2023-01-02T00:00:00Z",
+		"ts3":    "2023-01-02T00:00:00.500Z",
+		"t":      int64(-3723500000),
+	}
+	if !reflect.DeepEqual(e.After, want) {
+		t.Errorf("After = %v, want %v", e.After, want)
+	}
+
+	// 零值时间为null，enum的0为空字符串
+	c.After = []interface{}{int32(1), int64(0), int64(0), "0000-00-00", "0000-00-00 00:00:00", nil, "0000-00-00 00:00:00", nil, "00:00:00"}
+	e = newDebeziumEnvelope(c, nil).(*DebeziumEnvelope)
+	want = map[string]interface{}{"id": int32(1), "status": "", "tags": "", "d": nil, "dt": nil, "dt6": nil, "ts": nil, "ts3": nil, "t": int64(0)}
+	if !reflect.DeepEqual(e.After, want) {
+		t.Errorf("After = %v, want %v", e.After, want)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func uint32Ptr(v uint32) *uint32 {
+	return &v
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bytes"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDiscard 中断时丢弃未完成事务，已提交的事务保留
+func TestDiscard(t *testing.T) {
+	insert := func(id int32) *event.RowChange {
+		return &event.RowChange{Tx: &event.Transaction{}, Table: testTable(), Action: canal.InsertAction,
+			After: []interface{}{id, "a", "b"}}
+	}
+	// run 提交id为1的事务，丢弃id为2的事务
+	run := func(t *testing.T, s event.Sink) {
+		tx := &event.Transaction{}
+		for _, step := range []func() error{
+			func() error { return s.Begin(tx) },
+			func() error { return s.Row(insert(1)) },
+			func() error { return s.Commit(tx) },
+			func() error { return s.Begin(tx) },
+			func() error { return s.Row(insert(2)) },
+			func() error { return s.(event.Discarder).Discard(tx) },
+			s.Close,
+		} {
+			if err := step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tests := []struct {
+		name string
+		// output 创建Sink，返回读取输出的函数
+		output func(t *testing.T) (event.Sink, func() string)
+		// committed 已提交事务的输出，discarded 被丢弃事务的输出
+		committed string
+		discarded string
+	}{
+		{name: "sql", output: func(t *testing.T) (event.Sink, func() string) {
+			var out bytes.Buffer
+			s, err := NewSqlSink(&Options{Out: &out})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s, out.String
+		}, committed: "values(1,", discarded: "values(2,"},
+		{name: "csv", output: func(t *testing.T) (event.Sink, func() string) {
+			dir := t.TempDir()
+			s, err := NewCsvSink(dir, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s, func() string {
+				data, _ := os.ReadFile(filepath.Join(dir, "test.user.csv"))
+				return string(data)
+			}
+		}, committed: "after,1,a,b", discarded: "after,2,a,b"},
+		{name: "jsonl", output: func(t *testing.T) (event.Sink, func() string) {
+			var out bytes.Buffer
+			return NewJsonlSink(&out, nil, func(c *event.RowChange, _ *sql.Masker) interface{} {
+				return newJsonRecord(c, nil)
+			}), out.String
+		}, committed: `"id":1`, discarded: `"id":2`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, output := tt.output(t)
+			run(t, s)
+			got := output()
+			if !strings.Contains(got, tt.committed) || strings.Contains(got, tt.discarded) {
+				t.Errorf("输出 = %q, want包含%q不包含%q", got, tt.committed, tt.discarded)
+			}
+		})
+	}
+}
+
+func TestParquetDiscard(t *testing.T) {
+	s, err := NewParquetSink(t.TempDir(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &event.Transaction{}
+	change := &event.RowChange{Tx: tx, Table: testTable(), Action: canal.UpdateAction,
+		Before: []interface{}{int32(1), "a", "b"}, After: []interface{}{int32(1), "c", "b"}}
+	if err := s.Row(change); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.pending) != 2 {
+		t.Fatalf("update缓存%d条记录, want 2", len(s.pending))
+	}
+	if err := s.Discard(tx); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("Discard后缓存%d条记录", len(s.pending))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"encoding/json"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"io"
+)
+
+// Jsonl 输出format
+const Jsonl = "jsonl"
+
+func init() {
+	Register(Jsonl, func(options *Options) (event.Sink, error) {
+		return NewJsonlSink(options.Out, options.Masker, func(c *event.RowChange, masker *sql.Masker) interface{} {
+			return newJsonRecord(c, masker)
+		}), nil
+	})
+}
+
+// JsonRecord jsonl格式的单行变更
+type JsonRecord struct {
+	Database   string                 `json:"database"`
+	Table      string                 `json:"table"`
+	Action     string                 `json:"action"`
+	PrimaryKey map[string]interface{} `json:"primary_key,omitempty"`
+	Before     map[string]interface{} `json:"before"`
+	After      map[string]interface{} `json:"after"`
+	File       string                 `json:"file"`
+	Pos        uint32                 `json:"pos"`
+	Timestamp  uint32                 `json:"timestamp"`
+	GTID       string                 `json:"gtid,omitempty"`
+	XID        uint64                 `json:"xid,omitempty"`
+}
+
+func newJsonRecord(c *event.RowChange, masker *sql.Masker) interface{} {
+	r := &JsonRecord{
+		Database:  c.Table.Schema,
+		Table:     c.Table.Name,
+		Action:    c.Action,
+		Before:    rowImage(masker, c.Table, c.Before),
+		After:     rowImage(masker, c.Table, c.After),
+		File:      c.File,
+		Pos:       c.Pos,
+		Timestamp: c.Timestamp,
+		GTID:      c.Tx.GTID,
+		XID:       c.Tx.XID,
+	}
+	r.PrimaryKey = primaryKey(c.Table, r.Before, r.After)
+	return r
+}
+
+// JsonlSink 每行变更输出一个json对象，事务提交时输出，以便带上xid
+type JsonlSink struct {
+	Base
+	encoder *json.Encoder
+	masker  *sql.Masker
+	record  func(c *event.RowChange, masker *sql.Masker) interface{}
+	pending []*event.RowChange
+}
+
+// NewJsonlSink record将行变更转换为需要输出的json对象
+func NewJsonlSink(out io.Writer, masker *sql.Masker, record func(c *event.RowChange, masker *sql.Masker) interface{}) *JsonlSink {
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	return &JsonlSink{encoder: encoder, masker: masker, record: record}
+}
+
+func (s *JsonlSink) Row(c *event.RowChange) error {
+	s.pending = append(s.pending, c)
+	return nil
+}
+
+func (s *JsonlSink) Commit(*event.Transaction) error {
+	defer func() {
+		s.pending = s.pending[:0]
+	}()
+	for _, c := range s.pending {
+		if err := s.encoder.Encode(s.record(c, s.masker)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard 丢弃未完成事务的行变更
+func (s *JsonlSink) Discard(*event.Transaction) error {
+	s.pending = s.pending[:0]
+	return nil
+}
+
+func (s *JsonlSink) Close() error {
+	return s.Commit(nil)
+}
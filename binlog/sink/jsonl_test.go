@@ -0,0 +1,148 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testTable() *schema.Table {
+	return &schema.Table{
+		Schema: "test",
+		Name:   "user",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER, RawType: "int"},
+			{Name: "name", Type: schema.TYPE_STRING, RawType: "varchar(100)"},
+			{Name: "phone", Type: schema.TYPE_STRING, RawType: "varchar(20)"},
+		},
+		PKColumns: []int{0},
+	}
+}
+
+func TestTypedValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		column schema.TableColumn
+		val    interface{}
+		want   interface{}
+	}{
+		{name: "null", column: schema.TableColumn{Type: schema.TYPE_NUMBER}, val: nil, want: nil},
+		{name: "数字", column: schema.TableColumn{Type: schema.TYPE_NUMBER}, val: int32(1), want: int32(1)},
+		{name: "decimal保留精度", column: schema.TableColumn{Type: schema.TYPE_DECIMAL}, val: "1.10", want: json.Number("1.10")},
+		{name: "json内嵌", column: schema.TableColumn{Type: schema.TYPE_JSON}, val: []byte(`{"a":1}`), want: json.RawMessage(`{"a":1}`)},
+		{name: "无效json", column: schema.TableColumn{Type: schema.TYPE_JSON}, val: "{", want: "{"},
+		{name: "binary", column: schema.TableColumn{Type: schema.TYPE_BINARY}, val: "ab", want: []byte("ab")},
+		{name: "text转字符串", column: schema.TableColumn{Type: schema.TYPE_STRING, RawType: "text"}, val: []byte("ab"), want: "ab"},
+		{name: "blob保持二进制", column: schema.TableColumn{Type: schema.TYPE_STRING, RawType: "blob"}, val: []byte("ab"), want: []byte("ab")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := typedValue(&tt.column, tt.val); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("typedValue(%v) = %#v, want %#v", tt.val, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJsonRecord(t *testing.T) {
+	masker, err := sql.NewMasker([]string{"test.user.phone:prefix:3"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &event.Transaction{GTID: "uuid:1", XID: 7}
+	tests := []struct {
+		name   string
+		change *event.RowChange
+		want   string
+	}{
+		{
+			name: "insert",
+			change: &event.RowChange{Tx: tx, Action: canal.InsertAction,
+				After: []interface{}{int32(1), "a", "13812345678"}},
+			want: `{"database":"test","table":"user","action":"insert","primary_key":{"id":1},"before":null,` +
+				`"after":{"id":1,"name":"a","phone":"138********"},"file":"mysql-bin.000001","pos":100,"timestamp":1,"gtid":"uuid:1","xid":7}`,
+		},
+		{
+			name: "delete",
+			change: &event.RowChange{Tx: tx, Action: canal.DeleteAction,
+				Before: []interface{}{int32(2), nil, nil}},
+			want: `{"database":"test","table":"user","action":"delete","primary_key":{"id":2},"before":{"id":2,"name":null,"phone":null},` +
+				`"after":null,"file":"mysql-bin.000001","pos":100,"timestamp":1,"gtid":"uuid:1","xid":7}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change.Table = testTable()
+			tt.change.Position = event.Position{File: "mysql-bin.000001", Pos: 100, Timestamp: 1}
+			data, err := json.Marshal(newJsonRecord(tt.change, masker))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("newJsonRecord() = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestJsonlSink(t *testing.T) {
+	row := func(id int32) *event.RowChange {
+		return &event.RowChange{Tx: &event.Transaction{}, Table: testTable(), Action: canal.InsertAction,
+			After: []interface{}{id, "a", "b"}}
+	}
+	tests := []struct {
+		name string
+		run  func(s *JsonlSink) error
+		want int
+	}{
+		{name: "提交时输出", run: func(s *JsonlSink) error {
+			_ = s.Row(row(1))
+			_ = s.Row(row(2))
+			return s.Commit(nil)
+		}, want: 2},
+		{name: "丢弃未完成事务", run: func(s *JsonlSink) error {
+			_ = s.Row(row(1))
+			return s.Discard(nil)
+		}, want: 0},
+		{name: "关闭时输出剩余变更", run: func(s *JsonlSink) error {
+			_ = s.Row(row(1))
+			return s.Close()
+		}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			s := NewJsonlSink(&out, nil, func(c *event.RowChange, masker *sql.Masker) interface{} {
+				return newJsonRecord(c, masker)
+			})
+			if err := tt.run(s); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Count(out.String(), "\n"); got != tt.want {
+				t.Errorf("输出%d行, want %d: %s", got, tt.want, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"github.com/xitongsys/parquet-go/types"
+	"github.com/xitongsys/parquet-go/writer"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parquet 输出format
+const Parquet = "parquet"
+
+func init() {
+	Register(Parquet, func(options *Options) (event.Sink, error) {
+		return NewParquetSink(options.OutDir, options.RowGroupSize, options.Masker)
+	})
+}
+
+var parquetMetaColumns = []string{
+	"name=action, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED",
+	"name=pos, type=INT64, repetitiontype=REQUIRED",
+	"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=REQUIRED",
+	"name=gtid, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL",
+	"name=image, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED",
+}
+
+var decimalTypeRegexp = regexp.MustCompile(`decimal\((\d+),(\d+)\)`)
+
+// timestampLocation binlog中的timestamp是UTC时间戳，go-mysql按本地时区格式化为字符串，按同一时区解析还原为原来的时刻
+var timestampLocation = time.Local
+
+// errInvalidTime 无法解析的时间，写入NULL并提示
+var errInvalidTime = errors.New("无效的时间")
+
+// parquetColumn 表字段对应的parquet字段
+type parquetColumn struct {
+	name      string
+	kind      parquetKind
+	precision int
+	scale     int
+}
+
+type parquetKind int
+
+const (
+	parquetString parquetKind = iota
+	parquetBinary
+	parquetInt64
+	// parquetUint64 无符号整数，按位保存在INT64中并标记为UINT_64
+	parquetUint64
+	parquetDouble
+	parquetDecimal
+	// parquetDatetime datetime没有时区，按UTC保存原始的时间
+	parquetDatetime
+	parquetTimestamp
+	parquetDate
+)
+
+// parquetTable 单张表的parquet文件
+type parquetTable struct {
+	file    *os.File
+	writer  *writer.CSVWriter
+	columns []parquetColumn
+	// invalid 出现过无效时间的字段，每个字段只提示一次
+	invalid map[string]bool
+	// seq 字段变化的次数，用于生成文件名
+	seq int
+	// source 生成columns的表结构，表结构不变时不需要重新比较字段
+	source *schema.Table
+}
+
+// ParquetSink 按表输出parquet文件，每张表一个文件。
+// parquet文件写入后不能撤销，事务中的记录缓存在内存中，提交时写入。
+// 表的字段或类型变化后写入新的文件db.table.N.parquet
+type ParquetSink struct {
+	Base
+	dir          string
+	rowGroupSize int64
+	masker       *sql.Masker
+	tables       map[string]*parquetTable
+	pending      []parquetRecord
+	// rolled 字段变化前的文件，关闭时才写入文件尾
+	rolled []*parquetTable
+}
+
+// parquetRecord 未提交事务中的一条记录
+type parquetRecord struct {
+	table *parquetTable
+	rec   []interface{}
+}
+
+// NewParquetSink rowGroupSize单位为MB
+func NewParquetSink(dir string, rowGroupSize int64, masker *sql.Masker) (*ParquetSink, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("parquet格式需要指定输出目录out-dir")
+	}
+	return &ParquetSink{
+		dir:          dir,
+		rowGroupSize: rowGroupSize,
+		masker:       masker,
+		tables:       make(map[string]*parquetTable),
+	}, nil
+}
+
+// Row 写入行变更，update会写入before、after两行
+func (w *ParquetSink) Row(c *event.RowChange) error {
+	if (c.Before != nil && len(c.Before) != len(c.Table.Columns)) || (c.After != nil && len(c.After) != len(c.Table.Columns)) {
+		return fmt.Errorf("表%s.%s有%d个字段，行变更中的值数量不一致，表结构与binlog不匹配", c.Table.Schema, c.Table.Name, len(c.Table.Columns))
+	}
+	t, err := w.table(c)
+	if err != nil {
+		return err
+	}
+	if c.Before != nil {
+		rec, err := t.record(c, "before", rowImage(w.masker, c.Table, c.Before))
+		if err != nil {
+			return err
+		}
+		w.pending = append(w.pending, parquetRecord{table: t, rec: rec})
+	}
+	if c.After != nil {
+		rec, err := t.record(c, "after", rowImage(w.masker, c.Table, c.After))
+		if err != nil {
+			return err
+		}
+		w.pending = append(w.pending, parquetRecord{table: t, rec: rec})
+	}
+	return nil
+}
+
+// Commit 将事务中的记录写入文件
+func (w *ParquetSink) Commit(*event.Transaction) error {
+	defer func() {
+		w.pending = w.pending[:0]
+	}()
+	for _, r := range w.pending {
+		if err := r.table.writer.Write(r.rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard 丢弃未完成事务的记录
+func (w *ParquetSink) Discard(*event.Transaction) error {
+	w.pending = w.pending[:0]
+	return nil
+}
+
+func (w *ParquetSink) table(c *event.RowChange) (*parquetTable, error) {
+	key := c.Table.Schema + "." + c.Table.Name
+	t, ok := w.tables[key]
+	if ok && t.source == c.Table {
+		return t, nil
+	}
+	columns, err := parquetColumns(c.Table, w.masker)
+	if err != nil {
+		return nil, err
+	}
+	seq := 0
+	if ok {
+		if reflect.DeepEqual(t.columns, columns) {
+			t.source = c.Table
+			return t, nil
+		}
+		// 字段变化，parquet的schema不能修改，之前的文件保留到关闭
+		w.rolled = append(w.rolled, t)
+		seq = t.seq + 1
+	}
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return nil, err
+	}
+	t = &parquetTable{columns: columns, invalid: make(map[string]bool), seq: seq, source: c.Table}
+	md := append([]string{}, parquetMetaColumns...)
+	for _, col := range t.columns {
+		md = append(md, col.metadata())
+	}
+	name := key + ".parquet"
+	if seq > 0 {
+		name = fmt.Sprintf("%s.%d.parquet", key, seq)
+	}
+	file, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	pw, err := writer.NewCSVWriterFromWriter(md, file, 1)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if w.rowGroupSize > 0 {
+		pw.RowGroupSize = w.rowGroupSize * 1024 * 1024
+	}
+	t.file = file
+	t.writer = pw
+	w.tables[key] = t
+	return t, nil
+}
+
+// Close 写入剩余记录和文件尾并关闭所有文件
+func (w *ParquetSink) Close() error {
+	err := w.Commit(nil)
+	tables := append([]*parquetTable{}, w.rolled...)
+	for _, t := range w.tables {
+		tables = append(tables, t)
+	}
+	for _, t := range tables {
+		if stopErr := t.writer.WriteStop(); stopErr != nil && err == nil {
+			err = stopErr
+		}
+		if closeErr := t.file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// record 转换为parquet的一行，元数据列在前
+func (t *parquetTable) record(c *event.RowChange, image string, values map[string]interface{}) ([]interface{}, error) {
+	rec := make([]interface{}, 0, len(parquetMetaColumns)+len(t.columns))
+	var gtid interface{}
+	if c.Tx.GTID != "" {
+		gtid = c.Tx.GTID
+	}
+	rec = append(rec, c.Action, int64(c.Pos), int64(c.Timestamp)*1000, gtid, image)
+	for _, col := range t.columns {
+		v, err := col.value(values[col.name])
+		if errors.Is(err, errInvalidTime) {
+			if !t.invalid[col.name] {
+				t.invalid[col.name] = true
+				_, _ = fmt.Fprintf(os.Stderr, "# %s.%s字段%s的值%v不是有效的时间，保存为NULL\n", c.Table.Schema, c.Table.Name, col.name, values[col.name])
+			}
+			v, err = nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s字段%s转换失败: %v", c.Table.Schema, c.Table.Name, col.name, err)
+		}
+		rec = append(rec, v)
+	}
+	return rec, nil
+}
+
+// parquetColumns 根据字段类型映射parquet类型，脱敏字段统一为字符串。
+// parquet-go的schema用逗号、等号分隔，不能转义，字段名包含这些字符时返回错误
+func parquetColumns(table *schema.Table, masker *sql.Masker) ([]parquetColumn, error) {
+	columns := make([]parquetColumn, len(table.Columns))
+	for i := range table.Columns {
+		column := &table.Columns[i]
+		if strings.ContainsAny(column.Name, ",=\t") || strings.TrimSpace(column.Name) != column.Name {
+			return nil, fmt.Errorf("表%s.%s的字段名%q包含逗号、等号或首尾空白，不能导出为parquet", table.Schema, table.Name, column.Name)
+		}
+		col := parquetColumn{name: column.Name, kind: parquetString}
+		if masker.Rule(table, column.Name) != nil {
+			columns[i] = col
+			continue
+		}
+		switch column.Type {
+		case schema.TYPE_NUMBER, schema.TYPE_MEDIUM_INT:
+			col.kind = parquetInt64
+			if column.IsUnsigned {
+				col.kind = parquetUint64
+			}
+		case schema.TYPE_BIT:
+			col.kind = parquetUint64
+		case schema.TYPE_FLOAT:
+			col.kind = parquetDouble
+		case schema.TYPE_DECIMAL:
+			col.kind = parquetDecimal
+			col.precision, col.scale = 10, 0
+			if m := decimalTypeRegexp.FindStringSubmatch(column.RawType); m != nil {
+				col.precision, _ = strconv.Atoi(m[1])
+				col.scale, _ = strconv.Atoi(m[2])
+			}
+		case schema.TYPE_DATETIME:
+			col.kind = parquetDatetime
+		case schema.TYPE_TIMESTAMP:
+			col.kind = parquetTimestamp
+		case schema.TYPE_DATE:
+			col.kind = parquetDate
+		case schema.TYPE_BINARY:
+			col.kind = parquetBinary
+		case schema.TYPE_STRING:
+			if strings.Contains(column.RawType, "blob") {
+				col.kind = parquetBinary
+			}
+		}
+		columns[i] = col
+	}
+	return columns, nil
+}
+
+func (col *parquetColumn) metadata() string {
+	switch col.kind {
+	case parquetInt64:
+		return fmt.Sprintf("name=%s, type=INT64, repetitiontype=OPTIONAL", col.name)
+	case parquetUint64:
+		return fmt.Sprintf("name=%s, type=INT64, convertedtype=UINT_64, repetitiontype=OPTIONAL", col.name)
+	case parquetDouble:
+		return fmt.Sprintf("name=%s, type=DOUBLE, repetitiontype=OPTIONAL", col.name)
+	case parquetDecimal:
+		return fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=DECIMAL, precision=%d, scale=%d, repetitiontype=OPTIONAL", col.name, col.precision, col.scale)
+	case parquetDatetime, parquetTimestamp:
+		return fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MICROS, repetitiontype=OPTIONAL", col.name)
+	case parquetDate:
+		return fmt.Sprintf("name=%s, type=INT32, convertedtype=DATE, repetitiontype=OPTIONAL", col.name)
+	case parquetBinary:
+		return fmt.Sprintf("name=%s, type=BYTE_ARRAY, repetitiontype=OPTIONAL", col.name)
+	default:
+		return fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL", col.name)
+	}
+}
+
+// value 将行数据转换为parquet物理类型的值
+func (col *parquetColumn) value(val interface{}) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
+	switch col.kind {
+	case parquetInt64:
+		return strconv.ParseInt(fmt.Sprintf("%v", val), 10, 64)
+	case parquetUint64:
+		s := fmt.Sprintf("%v", val)
+		if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return int64(v), nil
+		}
+		// bit(64)最高位为1时解码为负的int64，按位保存即可
+		return strconv.ParseInt(s, 10, 64)
+	case parquetDouble:
+		return strconv.ParseFloat(fmt.Sprintf("%v", val), 64)
+	case parquetDecimal:
+		return types.StrIntToBinary(unscaledDecimal(fmt.Sprintf("%v", val), col.scale), "BigEndian", 0, true), nil
+	case parquetDatetime, parquetTimestamp:
+		loc := time.UTC
+		if col.kind == parquetTimestamp {
+			loc = timestampLocation
+		}
+		t, err := parseTime("2006-01-02 15:04:05", fmt.Sprintf("%v", val), loc)
+		if t == nil {
+			return nil, err
+		}
+		return t.UnixMicro(), nil
+	case parquetDate:
+		t, err := parseTime("2006-01-02", fmt.Sprintf("%v", val), time.UTC)
+		if t == nil {
+			return nil, err
+		}
+		return int32(t.Unix() / 86400), nil
+	case parquetBinary:
+		if b, ok := val.([]byte); ok {
+			return string(b), nil
+		}
+		return fmt.Sprintf("%v", val), nil
+	default:
+		switch t := val.(type) {
+		case string:
+			return t, nil
+		case []byte:
+			return string(t), nil
+		case json.RawMessage:
+			return string(t), nil
+		default:
+			return fmt.Sprintf("%v", t), nil
+		}
+	}
+}
+
+// parseTime 0000-00-00等零值时间返回nil，其它无法解析的时间返回errInvalidTime
+func parseTime(layout string, s string, loc *time.Location) (*time.Time, error) {
+	if strings.HasPrefix(s, "0000-00-00") {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation(layout, s, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidTime, s)
+	}
+	return &t, nil
+}
+
+// unscaledDecimal 将decimal字符串转为去掉小数点后的整数字符串
+func unscaledDecimal(s string, scale int) string {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	intPart, frac, _ := strings.Cut(s, ".")
+	if len(frac) < scale {
+		frac += strings.Repeat("0", scale-len(frac))
+	} else {
+		frac = frac[:scale]
+	}
+	digits := strings.TrimLeft(intPart+frac, "0")
+	if digits == "" {
+		return "0"
+	}
+	if neg {
+		return "-" + digits
+	}
+	return digits
+}
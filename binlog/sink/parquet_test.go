@@ -0,0 +1,189 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"errors"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"github.com/xitongsys/parquet-go/parquet"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnscaledDecimal(t *testing.T) {
+	tests := []struct {
+		val   string
+		scale int
+		want  string
+	}{
+		{val: "1.23", scale: 2, want: "123"},
+		{val: "1.2", scale: 3, want: "1200"},
+		{val: "1.2345", scale: 2, want: "123"},
+		{val: "-0.05", scale: 2, want: "-5"},
+		{val: "+10", scale: 1, want: "100"},
+		{val: "0.00", scale: 2, want: "0"},
+		{val: "-0", scale: 0, want: "0"},
+		{val: "12345678901234567890.1", scale: 1, want: "123456789012345678901"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.val, func(t *testing.T) {
+			if got := unscaledDecimal(tt.val, tt.scale); got != tt.want {
+				t.Errorf("unscaledDecimal(%q, %d) = %s, want %s", tt.val, tt.scale, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParquetColumnsName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "id"},
+		{name: "中文"},
+		{name: "a,b", wantErr: true},
+		{name: "a=b", wantErr: true},
+		{name: " a", wantErr: true},
+		{name: "a\tb", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &schema.Table{Schema: "test", Name: "t", Columns: []schema.TableColumn{{Name: tt.name, Type: schema.TYPE_NUMBER}}}
+			_, err := parquetColumns(table, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parquetColumns(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParquetTimeValue(t *testing.T) {
+	defer func(loc *time.Location) { timestampLocation = loc }(timestampLocation)
+	timestampLocation = time.FixedZone("UTC+8", 8*3600)
+
+	tests := []struct {
+		name    string
+		kind    parquetKind
+		val     string
+		want    interface{}
+		invalid bool
+	}{
+		{name: "datetime按UTC保存", kind: parquetDatetime, val: "2023-01-01 08:00:00", want: int64(1672560000000000)},
+		{name: "datetime小数秒", kind: parquetDatetime, val: "2023-01-01 08:00:00.5", want: int64(1672560000500000)},
+		{name: "timestamp按格式化的时区解析", kind: parquetTimestamp, val: "2023-01-01 08:00:00", want: int64(1672531200000000)},
+		{name: "date", kind: parquetDate, val: "2023-01-02", want: int32(19359)},
+		{name: "零值datetime", kind: parquetDatetime, val: "0000-00-00 00:00:00", want: nil},
+		{name: "零值date", kind: parquetDate, val: "0000-00-00", want: nil},
+		{name: "无效datetime", kind: parquetDatetime, val: "2023-02-30 00:00:00", want: nil, invalid: true},
+		{name: "无效date", kind: parquetDate, val: "2023-00-01", want: nil, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			col := &parquetColumn{name: "c", kind: tt.kind}
+			got, err := col.value(tt.val)
+			if errors.Is(err, errInvalidTime) != tt.invalid || (err != nil && !tt.invalid) {
+				t.Fatalf("value(%q) error = %v, invalid %v", tt.val, err, tt.invalid)
+			}
+			if got != tt.want {
+				t.Errorf("value(%q) = %v, want %v", tt.val, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParquetUnsigned(t *testing.T) {
+	table := &schema.Table{Schema: "test", Name: "t", Columns: []schema.TableColumn{
+		{Name: "id", Type: schema.TYPE_NUMBER, IsUnsigned: true},
+		{Name: "n", Type: schema.TYPE_NUMBER},
+		{Name: "b", Type: schema.TYPE_BIT},
+	}}
+	w, err := NewParquetSink(t.TempDir(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &event.Transaction{}
+	change := &event.RowChange{Tx: tx, Table: table, Action: "insert", After: []interface{}{uint64(18446744073709551615), int64(-1), int64(-1)}}
+	if err := w.Row(change); err != nil {
+		t.Fatal(err)
+	}
+	pt := w.tables["test.t"]
+	converted := make(map[string]parquet.ConvertedType)
+	for _, element := range pt.writer.SchemaHandler.SchemaElements {
+		if element.ConvertedType != nil {
+			converted[element.Name] = *element.ConvertedType
+		}
+	}
+	if converted["Id"] != parquet.ConvertedType_UINT_64 || converted["B"] != parquet.ConvertedType_UINT_64 {
+		t.Errorf("无符号字段应标记为UINT_64, got %v", converted)
+	}
+	if _, ok := converted["N"]; ok {
+		t.Errorf("有符号字段不应标记converted type, got %v", converted["N"])
+	}
+	rec := w.pending[0].rec[len(parquetMetaColumns):]
+	// 18446744073709551615按位保存为-1，读取时按UINT_64解释
+	if rec[0] != int64(-1) || rec[1] != int64(-1) || rec[2] != int64(-1) {
+		t.Errorf("record = %v", rec)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	col := &parquetColumn{name: "n", kind: parquetInt64}
+	if _, err := col.value(uint64(18446744073709551615)); err == nil {
+		t.Error("有符号字段超出int64范围时应返回错误")
+	}
+}
+
+func TestParquetColumnsChanged(t *testing.T) {
+	columns := []schema.TableColumn{{Name: "id", Type: schema.TYPE_NUMBER}}
+	tables := []*schema.Table{
+		{Schema: "test", Name: "t", Columns: columns},
+		// 表结构重新获取，字段不变
+		{Schema: "test", Name: "t", Columns: columns},
+		{Schema: "test", Name: "t", Columns: append(columns, schema.TableColumn{Name: "name", Type: schema.TYPE_STRING})},
+	}
+	dir := t.TempDir()
+	w, err := NewParquetSink(dir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &event.Transaction{}
+	for i, table := range tables {
+		after := make([]interface{}, len(table.Columns))
+		after[0] = int64(i)
+		if err := w.Row(&event.RowChange{Tx: tx, Table: table, Action: "insert", After: after}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Row(&event.RowChange{Tx: tx, Table: tables[2], Action: "insert", After: []interface{}{int64(3)}}); err == nil {
+		t.Error("值的数量与表字段不一致时应返回错误")
+	}
+	if len(w.rolled) != 1 || len(w.rolled[0].columns) != 1 || len(w.tables["test.t"].columns) != 2 {
+		t.Errorf("字段变化后应写入新的文件, rolled = %d", len(w.rolled))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"test.t.parquet", "test.t.1.parquet"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.Size() == 0 {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"fmt"
+	"github.com/dhbin/ra/binlog/ddl"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"io"
+	"os"
+	"strings"
+)
+
+// recoverRow 行在解析范围内最后的数据
+type recoverRow struct {
+	table *schema.Table
+	// row 为nil时行已被删除
+	row []interface{}
+}
+
+// recoverTable 表中被变更过的行，order为行第一次出现的顺序
+type recoverTable struct {
+	rows  map[string]*recoverRow
+	order []string
+}
+
+// recoverUndo 未提交事务对行状态的修改，Discard时恢复
+type recoverUndo struct {
+	table string
+	key   string
+	prev  *recoverRow
+}
+
+// RecoverSink 闪回时恢复被DROP TABLE、TRUNCATE删除的数据。
+// 记录解析范围内每一行最后的数据，遇到DROP TABLE、TRUNCATE时，
+// 将该表仍然存在的行作为insert写入下游Sink。解析范围内没有变更过的行无法恢复
+type RecoverSink struct {
+	sink    event.Sink
+	errOut  io.Writer
+	builder *sql.Builder
+
+	tables map[string]*recoverTable
+	undo   []recoverUndo
+}
+
+// NewRecoverSink 创建RecoverSink，变更和恢复的数据写入s
+func NewRecoverSink(s event.Sink, options *Options) *RecoverSink {
+	errOut := options.ErrOut
+	if errOut == nil {
+		errOut = os.Stderr
+	}
+	return &RecoverSink{
+		sink:    s,
+		errOut:  errOut,
+		builder: &sql.Builder{},
+		tables:  make(map[string]*recoverTable),
+	}
+}
+
+func (s *RecoverSink) Begin(tx *event.Transaction) error {
+	s.undo = s.undo[:0]
+	return s.sink.Begin(tx)
+}
+
+// Row 闪回变更的After是原始变更的before image，Before是原始变更的after image
+func (s *RecoverSink) Row(c *event.RowChange) error {
+	if c.After != nil {
+		if err := s.track(c.Table, c.After, nil); err != nil {
+			return fmt.Errorf("%s pos %d: %w", c.File, c.Pos, err)
+		}
+	}
+	if c.Before != nil {
+		if err := s.track(c.Table, c.Before, c.Before); err != nil {
+			return fmt.Errorf("%s pos %d: %w", c.File, c.Pos, err)
+		}
+	}
+	return s.sink.Row(c)
+}
+
+// DDL 遇到DROP TABLE、TRUNCATE时先输出恢复的数据，再将ddl写入下游Sink
+func (s *RecoverSink) DDL(c *event.DDLChange) error {
+	tables, err := ddl.DroppedTables(c.Query, c.Schema)
+	if err != nil {
+		_, _ = fmt.Fprintf(s.errOut, "# %s pos %d 无法解析ddl，跳过: %s\n", c.File, c.Pos, c.Query)
+	}
+	for _, table := range tables {
+		if err := s.recover(c, table); err != nil {
+			return err
+		}
+	}
+	return s.sink.DDL(c)
+}
+
+func (s *RecoverSink) Commit(tx *event.Transaction) error {
+	s.undo = s.undo[:0]
+	return s.sink.Commit(tx)
+}
+
+// Discard 恢复未完成事务修改的行状态
+func (s *RecoverSink) Discard(tx *event.Transaction) error {
+	for i := len(s.undo) - 1; i >= 0; i-- {
+		u := s.undo[i]
+		t := s.tables[u.table]
+		if u.prev == nil {
+			t.rows[u.key] = &recoverRow{}
+		} else {
+			t.rows[u.key] = u.prev
+		}
+	}
+	s.undo = s.undo[:0]
+	if discarder, ok := s.sink.(event.Discarder); ok {
+		return discarder.Discard(tx)
+	}
+	return nil
+}
+
+func (s *RecoverSink) Close() error {
+	return s.sink.Close()
+}
+
+// track 记录行最后的数据，row为nil时行已被删除
+func (s *RecoverSink) track(table *schema.Table, image []interface{}, row []interface{}) error {
+	key, err := s.builder.BuildKeyCondition(table, image)
+	if err != nil {
+		return err
+	}
+	name := tableKey(table.Schema, table.Name)
+	t, ok := s.tables[name]
+	if !ok {
+		t = &recoverTable{rows: make(map[string]*recoverRow)}
+		s.tables[name] = t
+	}
+	prev, ok := t.rows[key]
+	if !ok {
+		t.order = append(t.order, key)
+	}
+	s.undo = append(s.undo, recoverUndo{table: name, key: key, prev: prev})
+	t.rows[key] = &recoverRow{table: table, row: row}
+	return nil
+}
+
+// recover 将表中仍然存在的行作为一个事务写入下游Sink，之后该表重新开始记录
+func (s *RecoverSink) recover(c *event.DDLChange, table ddl.Table) error {
+	name := tableKey(table.Schema, table.Name)
+	t, ok := s.tables[name]
+	if !ok {
+		_, _ = fmt.Fprintf(s.errOut, "# %s pos %d %s 被删除或清空，解析范围内没有该表的变更，无法恢复\n", c.File, c.Pos, table)
+		return nil
+	}
+	delete(s.tables, name)
+
+	tx := &event.Transaction{Position: c.Position, GTID: c.GTID}
+	begun := false
+	n := 0
+	for _, key := range t.order {
+		r := t.rows[key]
+		if r.row == nil {
+			continue
+		}
+		if !begun {
+			if err := s.sink.Begin(tx); err != nil {
+				return err
+			}
+			begun = true
+		}
+		err := s.sink.Row(&event.RowChange{
+			Position: c.Position,
+			Tx:       tx,
+			Table:    r.table,
+			Action:   canal.InsertAction,
+			After:    r.row,
+			Row:      n,
+		})
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	if begun {
+		if err := s.sink.Commit(tx); err != nil {
+			return err
+		}
+	}
+	_, _ = fmt.Fprintf(s.errOut, "# %s pos %d %s 被删除或清空，从binlog中恢复了%d行在解析范围内最后的数据，解析范围内没有变更过的行无法恢复\n", c.File, c.Pos, table, n)
+	return nil
+}
+
+func tableKey(schema string, name string) string {
+	return strings.ToLower(schema + "." + name)
+}
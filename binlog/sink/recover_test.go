@@ -0,0 +1,136 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"reflect"
+	"testing"
+)
+
+// recordSink 记录写入的变更，行变更记录为action和id
+type recordSink struct {
+	Base
+	records []string
+}
+
+func (s *recordSink) Row(c *event.RowChange) error {
+	s.records = append(s.records, fmt.Sprintf("%s %s.%s%v", c.Action, c.Table.Schema, c.Table.Name, rowID(c)))
+	return nil
+}
+
+func (s *recordSink) DDL(c *event.DDLChange) error {
+	s.records = append(s.records, c.Query)
+	return nil
+}
+
+func rowID(c *event.RowChange) interface{} {
+	if c.After != nil {
+		return c.After[0]
+	}
+	return c.Before[0]
+}
+
+func TestRecoverSink(t *testing.T) {
+	row := func(id int32, name string) []interface{} {
+		return []interface{}{id, name, ""}
+	}
+	// 闪回的行变更：原始insert为delete，原始delete为insert，原始update的Before、After互换
+	insert := func(id int32, name string) *event.RowChange {
+		return &event.RowChange{Action: canal.DeleteAction, Before: row(id, name)}
+	}
+	del := func(id int32, name string) *event.RowChange {
+		return &event.RowChange{Action: canal.InsertAction, After: row(id, name)}
+	}
+	update := func(oldID int32, newID int32) *event.RowChange {
+		return &event.RowChange{Action: canal.UpdateAction, Before: row(newID, "new"), After: row(oldID, "old")}
+	}
+	drop := &event.DDLChange{Schema: "test", Query: "drop table user"}
+	truncate := &event.DDLChange{Query: "truncate table test.user"}
+
+	type tx struct {
+		changes []*event.RowChange
+		// discard 为true时事务被丢弃
+		discard bool
+	}
+	tests := []struct {
+		name string
+		txs  []tx
+		ddl  *event.DDLChange
+		want []string
+	}{
+		{
+			name: "恢复仍然存在的行",
+			txs:  []tx{{changes: []*event.RowChange{insert(1, "a"), insert(2, "b")}}, {changes: []*event.RowChange{del(2, "b")}}},
+			ddl:  drop,
+			want: []string{"delete test.user1", "delete test.user2", "insert test.user2", "insert test.user1", "drop table user"},
+		},
+		{
+			name: "update修改主键",
+			txs:  []tx{{changes: []*event.RowChange{update(1, 3)}}},
+			ddl:  truncate,
+			want: []string{"update test.user1", "insert test.user3", "truncate table test.user"},
+		},
+		{
+			name: "丢弃未完成事务的修改",
+			txs:  []tx{{changes: []*event.RowChange{insert(1, "a")}}, {changes: []*event.RowChange{del(1, "a")}, discard: true}},
+			ddl:  drop,
+			want: []string{"delete test.user1", "insert test.user1", "insert test.user1", "drop table user"},
+		},
+		{
+			name: "没有变更的表",
+			ddl:  &event.DDLChange{Query: "drop table test.other"},
+			want: []string{"drop table test.other"},
+		},
+		{
+			name: "其它ddl",
+			txs:  []tx{{changes: []*event.RowChange{insert(1, "a")}}},
+			ddl:  &event.DDLChange{Schema: "test", Query: "alter table user add column c int"},
+			want: []string{"delete test.user1", "alter table user add column c int"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &recordSink{}
+			s := NewRecoverSink(out, &Options{ErrOut: &bytes.Buffer{}})
+			for _, x := range tt.txs {
+				transaction := &event.Transaction{}
+				_ = s.Begin(transaction)
+				for _, c := range x.changes {
+					c.Tx, c.Table = transaction, testTable()
+					if err := s.Row(c); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if x.discard {
+					_ = s.Discard(transaction)
+				} else {
+					_ = s.Commit(transaction)
+				}
+			}
+			if err := s.DDL(tt.ddl); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out.records, tt.want) {
+				t.Errorf("records = %q, want %q", out.records, tt.want)
+			}
+		})
+	}
+}
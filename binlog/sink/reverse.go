@@ -0,0 +1,210 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"io"
+	"os"
+)
+
+// reverseKind 临时文件中记录的类型
+type reverseKind uint8
+
+const (
+	reverseBegin reverseKind = iota
+	reverseRow
+	reverseCommit
+	reverseDDL
+)
+
+// reverseRecord 临时文件中的一条记录
+type reverseRecord struct {
+	Kind reverseKind
+	Tx   *event.Transaction
+	Row  *reverseRowChange
+	DDL  *event.DDLChange
+}
+
+// reverseRowChange 行变更，Table为表结构在ReverseSink.list中的序号
+type reverseRowChange struct {
+	Position event.Position
+	Table    int
+	Action   string
+	Before   []interface{}
+	After    []interface{}
+	Row      int
+}
+
+// ReverseSink 闪回时按binlog位置倒序输出：最后的事务、ddl最先输出，事务中的行变更也倒序输出，
+// 反向sql按输出顺序执行时先撤销最后的修改，反向ddl在其之后的行变更撤销之后、之前的行变更撤销之前执行。
+// 变更按顺序写入临时文件，Close时从后往前读取写入下游Sink，内存中只保留表结构
+type ReverseSink struct {
+	sink event.Sink
+	file *os.File
+	// size 已写入的大小，begin 当前事务开始的位置，为-1时不在事务中
+	size  int64
+	begin int64
+
+	tables map[*schema.Table]int
+	list   []*schema.Table
+}
+
+// NewReverseSink 创建ReverseSink，变更倒序写入s
+func NewReverseSink(s event.Sink) (*ReverseSink, error) {
+	file, err := os.CreateTemp("", "ra-flashback-*")
+	if err != nil {
+		return nil, err
+	}
+	return &ReverseSink{sink: s, file: file, begin: -1, tables: make(map[*schema.Table]int)}, nil
+}
+
+func (s *ReverseSink) Begin(*event.Transaction) error {
+	s.begin = s.size
+	return s.write(&reverseRecord{Kind: reverseBegin})
+}
+
+func (s *ReverseSink) Row(c *event.RowChange) error {
+	idx, ok := s.tables[c.Table]
+	if !ok {
+		idx = len(s.list)
+		s.tables[c.Table] = idx
+		s.list = append(s.list, c.Table)
+	}
+	return s.write(&reverseRecord{Kind: reverseRow, Row: &reverseRowChange{
+		Position: c.Position,
+		Table:    idx,
+		Action:   c.Action,
+		Before:   c.Before,
+		After:    c.After,
+		Row:      c.Row,
+	}})
+}
+
+func (s *ReverseSink) DDL(c *event.DDLChange) error {
+	return s.write(&reverseRecord{Kind: reverseDDL, DDL: c})
+}
+
+func (s *ReverseSink) Commit(tx *event.Transaction) error {
+	if tx == nil {
+		return nil
+	}
+	s.begin = -1
+	return s.write(&reverseRecord{Kind: reverseCommit, Tx: tx})
+}
+
+// Discard 丢弃未完成事务已写入的变更
+func (s *ReverseSink) Discard(*event.Transaction) error {
+	if s.begin < 0 {
+		return nil
+	}
+	if err := s.file.Truncate(s.begin); err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(s.begin, io.SeekStart); err != nil {
+		return err
+	}
+	s.size, s.begin = s.begin, -1
+	return nil
+}
+
+// Close 倒序写入下游Sink并关闭，未提交的事务被丢弃
+func (s *ReverseSink) Close() error {
+	defer func() {
+		_ = s.file.Close()
+		_ = os.Remove(s.file.Name())
+	}()
+	err := s.Discard(nil)
+	if err == nil {
+		err = s.replay()
+	}
+	if closeErr := s.sink.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// write 每条记录单独gob编码，后面是4字节的长度，可以从文件末尾向前读取
+func (s *ReverseSink) write(r *reverseRecord) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return fmt.Errorf("缓存闪回变更失败: %w", err)
+	}
+	_ = binary.Write(&buf, binary.BigEndian, uint32(buf.Len()))
+	n, err := s.file.Write(buf.Bytes())
+	s.size += int64(n)
+	return err
+}
+
+func (s *ReverseSink) replay() error {
+	var tx *event.Transaction
+	for end := s.size; end > 0; {
+		r, start, err := s.read(end)
+		if err != nil {
+			return err
+		}
+		end = start
+		switch r.Kind {
+		case reverseCommit:
+			// 倒序时先读到事务结束
+			tx = r.Tx
+			err = s.sink.Begin(tx)
+		case reverseRow:
+			err = s.sink.Row(&event.RowChange{
+				Position: r.Row.Position,
+				Tx:       tx,
+				Table:    s.list[r.Row.Table],
+				Action:   r.Row.Action,
+				Before:   r.Row.Before,
+				After:    r.Row.After,
+				Row:      r.Row.Row,
+			})
+		case reverseBegin:
+			err = s.sink.Commit(tx)
+			tx = nil
+		case reverseDDL:
+			err = s.sink.DDL(r.DDL)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read 读取在end结束的记录，返回记录的开始位置
+func (s *ReverseSink) read(end int64) (*reverseRecord, int64, error) {
+	var size [4]byte
+	if _, err := s.file.ReadAt(size[:], end-4); err != nil {
+		return nil, 0, err
+	}
+	start := end - 4 - int64(binary.BigEndian.Uint32(size[:]))
+	data := make([]byte, end-4-start)
+	if _, err := s.file.ReadAt(data, start); err != nil {
+		return nil, 0, err
+	}
+	r := &reverseRecord{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(r); err != nil {
+		return nil, 0, fmt.Errorf("读取闪回变更失败: %w", err)
+	}
+	return r, start, nil
+}
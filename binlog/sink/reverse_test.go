@@ -0,0 +1,174 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"reflect"
+	"testing"
+)
+
+// orderSink 记录事务边界和变更的顺序
+type orderSink struct {
+	recordSink
+	rows []*event.RowChange
+}
+
+func (s *orderSink) Begin(tx *event.Transaction) error {
+	s.records = append(s.records, fmt.Sprintf("begin %d", tx.Pos))
+	return nil
+}
+
+func (s *orderSink) Row(c *event.RowChange) error {
+	s.rows = append(s.rows, c)
+	return s.recordSink.Row(c)
+}
+
+func (s *orderSink) Commit(tx *event.Transaction) error {
+	s.records = append(s.records, fmt.Sprintf("commit %d", tx.Pos))
+	return nil
+}
+
+func TestReverseSink(t *testing.T) {
+	table := testTable()
+	row := func(id int32) *event.RowChange {
+		return &event.RowChange{Table: table, Action: canal.InsertAction, After: []interface{}{id, "a", nil}}
+	}
+	ddl := func(query string) *event.DDLChange {
+		return &event.DDLChange{Query: query}
+	}
+	// tx 事务，discard 为true时事务被丢弃，open 为true时事务没有提交
+	type tx struct {
+		pos     uint32
+		rows    []*event.RowChange
+		ddl     *event.DDLChange
+		discard bool
+		open    bool
+	}
+	tests := []struct {
+		name string
+		txs  []tx
+		want []string
+	}{
+		{
+			name: "事务和行变更倒序",
+			txs: []tx{
+				{pos: 100, rows: []*event.RowChange{row(1), row(2)}},
+				{pos: 200, rows: []*event.RowChange{row(3)}},
+			},
+			want: []string{"begin 200", "insert test.user3", "commit 200", "begin 100", "insert test.user2", "insert test.user1", "commit 100"},
+		},
+		{
+			name: "ddl在之后的变更之后输出",
+			txs: []tx{
+				{pos: 100, rows: []*event.RowChange{row(1)}},
+				{ddl: ddl("alter table user add column c int")},
+				{pos: 300, rows: []*event.RowChange{row(2)}},
+				{ddl: ddl("alter table user add column d int")},
+			},
+			want: []string{
+				"alter table user add column d int",
+				"begin 300", "insert test.user2", "commit 300",
+				"alter table user add column c int",
+				"begin 100", "insert test.user1", "commit 100",
+			},
+		},
+		{
+			name: "丢弃的事务不输出",
+			txs: []tx{
+				{pos: 100, rows: []*event.RowChange{row(1)}},
+				{pos: 200, rows: []*event.RowChange{row(2)}, discard: true},
+				{pos: 300, rows: []*event.RowChange{row(3)}},
+			},
+			want: []string{"begin 300", "insert test.user3", "commit 300", "begin 100", "insert test.user1", "commit 100"},
+		},
+		{
+			name: "关闭时未提交的事务不输出",
+			txs: []tx{
+				{pos: 100, rows: []*event.RowChange{row(1)}},
+				{pos: 200, rows: []*event.RowChange{row(2)}, open: true},
+			},
+			want: []string{"begin 100", "insert test.user1", "commit 100"},
+		},
+		{name: "没有变更"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &orderSink{}
+			s, err := NewReverseSink(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, x := range tt.txs {
+				if x.ddl != nil {
+					if err := s.DDL(x.ddl); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				transaction := &event.Transaction{Position: event.Position{Pos: x.pos}}
+				_ = s.Begin(transaction)
+				for _, c := range x.rows {
+					c.Tx = transaction
+					_ = s.Row(c)
+				}
+				switch {
+				case x.discard:
+					_ = s.Discard(transaction)
+				case !x.open:
+					_ = s.Commit(transaction)
+				}
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out.records, tt.want) {
+				t.Errorf("records = %q, want %q", out.records, tt.want)
+			}
+		})
+	}
+}
+
+// TestReverseSinkValues 行数据经过临时文件后类型不变，表结构与写入时相同
+func TestReverseSinkValues(t *testing.T) {
+	table := testTable()
+	values := []interface{}{int32(1), nil, []byte{0, 1}, "a", int64(-1), uint64(1), float64(1.5)}
+	out := &orderSink{}
+	s, err := NewReverseSink(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &event.Transaction{}
+	_ = s.Begin(tx)
+	_ = s.Row(&event.RowChange{Tx: tx, Table: table, Action: canal.UpdateAction, Before: values, After: values, Row: 3})
+	_ = s.Commit(tx)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.rows) != 1 {
+		t.Fatalf("rows = %d, want 1", len(out.rows))
+	}
+	c := out.rows[0]
+	if c.Table != table || c.Action != canal.UpdateAction || c.Row != 3 {
+		t.Errorf("row = %+v", c)
+	}
+	if !reflect.DeepEqual(c.Before, values) || !reflect.DeepEqual(c.After, values) {
+		t.Errorf("values = %#v, %#v, want %#v", c.Before, c.After, values)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// RotateWriter 按大小或时间切换的输出文件。
+// 当前文件为path，切换时重命名为path.打开时间，如audit.sql.20230423-150405
+type RotateWriter struct {
+	path     string
+	maxSize  int64
+	interval time.Duration
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// NewRotateWriter 创建RotateWriter，maxSize单位为字节，maxSize、interval为0时不按该条件切换。
+// 已存在的文件追加写入
+func NewRotateWriter(path string, maxSize int64, interval time.Duration) (*RotateWriter, error) {
+	w := &RotateWriter{path: path, maxSize: maxSize, interval: interval}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate 达到切换条件时切换文件，需要在事务边界调用，保证一个事务不会跨文件
+func (w *RotateWriter) Rotate() error {
+	if w.size == 0 {
+		return nil
+	}
+	if !(w.maxSize > 0 && w.size >= w.maxSize) && !(w.interval > 0 && time.Since(w.opened) >= w.interval) {
+		return nil
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(w.path, w.rotatedPath()); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+// rotatedPath 切换后的文件名，同一秒内多次切换时追加序号
+func (w *RotateWriter) rotatedPath() string {
+	base := w.path + "." + w.opened.Format("20060102-150405")
+	path := base
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s.%d", base, i)
+	}
+}
+
+func (w *RotateWriter) Close() error {
+	return w.file.Close()
+}
+
+func (w *RotateWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.opened = time.Now()
+	return nil
+}
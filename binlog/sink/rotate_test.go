@@ -0,0 +1,78 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		maxSize  int64
+		writes   []string
+		// want 当前文件的内容，rotated 切换出的文件数
+		want    string
+		rotated int
+	}{
+		{name: "追加已存在的文件", existing: "a\n", writes: []string{"b\n"}, want: "a\nb\n"},
+		{name: "未达到大小不切换", maxSize: 10, writes: []string{"a\n", "b\n"}, want: "a\nb\n"},
+		{name: "达到大小后切换", maxSize: 2, writes: []string{"a\n", "b\n"}, want: "", rotated: 2},
+		{name: "已存在的文件达到大小", existing: "abc\n", maxSize: 2, writes: []string{"b\n"}, want: "", rotated: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "out.sql")
+			if tt.existing != "" {
+				if err := os.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w, err := NewRotateWriter(path, tt.maxSize, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.writes {
+				if _, err := w.Write([]byte(s)); err != nil {
+					t.Fatal(err)
+				}
+				// 在事务边界切换
+				if err := w.Rotate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("当前文件 = %q, want %q", data, tt.want)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries)-1 != tt.rotated {
+				t.Errorf("切换出%d个文件, want %d", len(entries)-1, tt.rotated)
+			}
+		})
+	}
+}
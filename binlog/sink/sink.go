@@ -0,0 +1,91 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Options 创建Sink的参数
+type Options struct {
+	// Out 输出流，sql、jsonl等单文件格式使用
+	Out io.Writer
+	// OutDir 输出目录，csv、parquet等按表输出的格式使用
+	OutDir string
+	// RowGroupSize parquet的row group大小，单位MB
+	RowGroupSize int64
+	// Masker 敏感字段脱敏，为nil时不脱敏
+	Masker *sql.Masker
+	// OnError sql格式无法生成sql时的处理方式，支持fail、skip、comment，默认fail
+	OnError string
+	// ErrOut 输出汇总等提示信息，默认stderr
+	ErrOut io.Writer
+}
+
+// Factory 创建Sink
+type Factory func(options *Options) (event.Sink, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register 注册输出格式，name重复时覆盖
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[strings.ToLower(name)] = factory
+}
+
+// New 创建指定格式的Sink
+func New(name string, options *Options) (event.Sink, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[strings.ToLower(name)]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("不支持的输出格式: %s，支持%s", name, strings.Join(Names(), ","))
+	}
+	return factory(options)
+}
+
+// Names 已注册的输出格式
+func Names() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Base 空实现，自定义Sink可以嵌入后只实现需要的方法
+type Base struct {
+}
+
+func (s *Base) Begin(*event.Transaction) error  { return nil }
+func (s *Base) Row(*event.RowChange) error      { return nil }
+func (s *Base) DDL(*event.DDLChange) error      { return nil }
+func (s *Base) Commit(*event.Transaction) error { return nil }
+func (s *Base) Close() error                    { return nil }
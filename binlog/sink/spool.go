@@ -0,0 +1,77 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// spoolMemory 事务输出在内存中缓存的上限，超过后写入临时文件
+const spoolMemory = 16 << 20
+
+// spool 缓存未提交事务的输出，超过limit后转存到临时文件，避免大事务占用过多内存
+type spool struct {
+	// limit 内存缓存的上限，为0时为spoolMemory
+	limit int
+	mem   bytes.Buffer
+	file  *os.File
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil {
+		limit := s.limit
+		if limit == 0 {
+			limit = spoolMemory
+		}
+		if s.mem.Len()+len(p) <= limit {
+			return s.mem.Write(p)
+		}
+		file, err := os.CreateTemp("", "ra-tx-*")
+		if err != nil {
+			return 0, err
+		}
+		s.file = file
+		if _, err := s.mem.WriteTo(file); err != nil {
+			return 0, err
+		}
+	}
+	return s.file.Write(p)
+}
+
+// WriteTo 将缓存的内容写入w并清空
+func (s *spool) WriteTo(w io.Writer) (int64, error) {
+	if s.file == nil {
+		return s.mem.WriteTo(w)
+	}
+	defer s.Reset()
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, s.file)
+}
+
+// Reset 丢弃缓存的内容并删除临时文件
+func (s *spool) Reset() {
+	s.mem.Reset()
+	if s.file != nil {
+		_ = s.file.Close()
+		_ = os.Remove(s.file.Name())
+		s.file = nil
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		// spilled 是否转存到临时文件
+		spilled bool
+	}{
+		{name: "内存", writes: []string{"ab", "cd"}},
+		{name: "刚好达到上限", writes: []string{"abcd", "efgh"}},
+		{name: "超过上限", writes: []string{"abcd", "efgh", "i"}, spilled: true},
+		{name: "单次写入超过上限", writes: []string{strings.Repeat("x", 20)}, spilled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &spool{limit: 8}
+			for _, w := range tt.writes {
+				if _, err := s.Write([]byte(w)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if (s.file != nil) != tt.spilled {
+				t.Fatalf("spilled = %v, want %v", s.file != nil, tt.spilled)
+			}
+			var name string
+			if s.file != nil {
+				name = s.file.Name()
+			}
+			var out bytes.Buffer
+			if _, err := s.WriteTo(&out); err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.Join(tt.writes, ""); out.String() != want {
+				t.Errorf("WriteTo() = %q, want %q", out.String(), want)
+			}
+			if name != "" {
+				if _, err := os.Stat(name); !os.IsNotExist(err) {
+					t.Errorf("临时文件%s没有删除", name)
+				}
+			}
+			// 输出后清空，可以继续使用
+			out.Reset()
+			_, _ = s.Write([]byte("z"))
+			_, _ = s.WriteTo(&out)
+			if out.String() != "z" {
+				t.Errorf("再次WriteTo() = %q, want %q", out.String(), "z")
+			}
+		})
+	}
+}
+
+func TestSpoolReset(t *testing.T) {
+	s := &spool{limit: 2}
+	_, _ = s.Write([]byte("abc"))
+	name := s.file.Name()
+	s.Reset()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("临时文件%s没有删除", name)
+	}
+	var out bytes.Buffer
+	_, _ = s.WriteTo(&out)
+	if out.Len() != 0 {
+		t.Errorf("Reset后WriteTo() = %q", out.String())
+	}
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Sql 输出format
+const Sql = "sql"
+
+const (
+	// OnErrorFail 无法生成sql时停止解析并返回错误
+	OnErrorFail = "fail"
+	// OnErrorSkip 无法生成sql时跳过该行
+	OnErrorSkip = "skip"
+	// OnErrorComment 无法生成sql时输出注释说明原因
+	OnErrorComment = "comment"
+)
+
+func init() {
+	Register(Sql, func(options *Options) (event.Sink, error) {
+		return NewSqlSink(options)
+	})
+}
+
+// SqlSink 输出sql语句，每行变更一条。事务中的sql在提交时输出，中断时不会输出不完整的事务
+type SqlSink struct {
+	Base
+	Out     io.Writer
+	Builder *sql.Builder
+	// OnError 无法生成sql时的处理方式
+	OnError string
+	// ErrOut 输出跳过的行数汇总
+	ErrOut io.Writer
+
+	skipped map[string]int
+	// buf 未提交事务的sql，大事务转存到临时文件
+	buf  spool
+	inTx bool
+
+	// schema、sessionVars 上一条ddl的默认库和会话变量
+	schema      string
+	sessionVars map[string]string
+}
+
+// NewSqlSink 创建SqlSink，OnError为空时为fail
+func NewSqlSink(options *Options) (*SqlSink, error) {
+	onError := options.OnError
+	switch onError {
+	case "":
+		onError = OnErrorFail
+	case OnErrorFail, OnErrorSkip, OnErrorComment:
+	default:
+		return nil, fmt.Errorf("不支持的错误处理方式: %s，支持fail,skip,comment", onError)
+	}
+	errOut := options.ErrOut
+	if errOut == nil {
+		errOut = os.Stderr
+	}
+	return &SqlSink{
+		Out:         options.Out,
+		Builder:     &sql.Builder{Masker: options.Masker},
+		OnError:     onError,
+		ErrOut:      errOut,
+		skipped:     make(map[string]int),
+		sessionVars: make(map[string]string),
+	}, nil
+}
+
+func (s *SqlSink) Begin(*event.Transaction) error {
+	s.inTx = true
+	return nil
+}
+
+func (s *SqlSink) Row(c *event.RowChange) error {
+	var stmt string
+	var err error
+	switch c.Action {
+	case canal.InsertAction:
+		stmt, err = s.Builder.BuildInsertSql(c.Table, c.After)
+	case canal.UpdateAction:
+		stmt, err = s.Builder.BuildUpdateSql(c.Table, c.Before, c.After)
+	case canal.DeleteAction:
+		stmt, err = s.Builder.BuildDeleteSql(c.Table, c.Before)
+	default:
+		return nil
+	}
+	if err != nil {
+		return s.onError(c, err)
+	}
+	_, err = fmt.Fprintf(s.out(), "%s # pos %d timestamp %d%s\n", stmt, c.Pos, c.Timestamp, s.maskedNote(c.Table))
+	return err
+}
+
+func (s *SqlSink) onError(c *event.RowChange, err error) error {
+	switch s.OnError {
+	case OnErrorSkip:
+	case OnErrorComment:
+		if _, writeErr := fmt.Fprintf(s.out(), "# error: %s # pos %d timestamp %d\n", err.Error(), c.Pos, c.Timestamp); writeErr != nil {
+			return writeErr
+		}
+	default:
+		return fmt.Errorf("%s pos %d: %w", c.File, c.Pos, err)
+	}
+	s.skipped[c.Table.Schema+"."+c.Table.Name]++
+	return nil
+}
+
+func (s *SqlSink) Commit(*event.Transaction) error {
+	s.inTx = false
+	_, err := s.buf.WriteTo(s.Out)
+	return err
+}
+
+// Discard 丢弃未完成事务的sql
+func (s *SqlSink) Discard(*event.Transaction) error {
+	s.inTx = false
+	s.buf.Reset()
+	return nil
+}
+
+// out 事务中的sql先写入缓冲区
+func (s *SqlSink) out() io.Writer {
+	if s.inTx {
+		return &s.buf
+	}
+	return s.Out
+}
+
+// Close 输出跳过的行数汇总，未提交的事务被丢弃
+func (s *SqlSink) Close() error {
+	s.inTx = false
+	s.buf.Reset()
+	if len(s.skipped) == 0 {
+		return nil
+	}
+	tables := make([]string, 0, len(s.skipped))
+	total := 0
+	for table, n := range s.skipped {
+		tables = append(tables, table)
+		total += n
+	}
+	sort.Strings(tables)
+	_, _ = fmt.Fprintf(s.ErrOut, "无法生成sql，共跳过%d行：\n", total)
+	for _, table := range tables {
+		_, _ = fmt.Fprintf(s.ErrOut, "  %s %d\n", table, s.skipped[table])
+	}
+	return nil
+}
+
+// DDL 输出ddl，默认库、会话变量与上一条ddl不同时先输出USE、SET
+func (s *SqlSink) DDL(ddl *event.DDLChange) error {
+	if ddl.Flashback {
+		return s.flashbackDDL(ddl)
+	}
+	if ddl.Schema != "" && ddl.Schema != s.schema {
+		if _, err := fmt.Fprintf(s.Out, "USE `%s`;\n", strings.ReplaceAll(ddl.Schema, "`", "``")); err != nil {
+			return err
+		}
+		s.schema = ddl.Schema
+	}
+	if err := s.session(ddl); err != nil {
+		return err
+	}
+	query := strings.TrimRight(strings.TrimSpace(ddl.Query), ";")
+	_, err := fmt.Fprintf(s.Out, "%s; # pos %d timestamp %d\n", query, ddl.Pos, ddl.Timestamp)
+	return err
+}
+
+// session 输出与上一条ddl不同的会话变量
+func (s *SqlSink) session(ddl *event.DDLChange) error {
+	for _, stmt := range ddl.Session.Statements() {
+		key := stmt[:strings.Index(stmt, "=")]
+		if s.sessionVars[key] == stmt {
+			continue
+		}
+		if _, err := fmt.Fprintf(s.Out, "%s;\n", stmt); err != nil {
+			return err
+		}
+		s.sessionVars[key] = stmt
+	}
+	return nil
+}
+
+// flashbackDDL 输出反向ddl，无法闪回的ddl输出注释说明原因
+func (s *SqlSink) flashbackDDL(c *event.DDLChange) error {
+	if c.Reverse == nil {
+		return nil
+	}
+	if len(c.Reverse.Irreversible) != 0 {
+		_, err := fmt.Fprintf(s.Out, "# 无法闪回的ddl: %s # pos %d timestamp %d 原因: %s\n",
+			strings.Join(strings.Fields(c.Query), " "), c.Pos, c.Timestamp, strings.Join(c.Reverse.Irreversible, "；"))
+		return err
+	}
+	if len(c.Reverse.Queries) == 0 {
+		return nil
+	}
+	if err := s.session(c); err != nil {
+		return err
+	}
+	for _, query := range c.Reverse.Queries {
+		if _, err := fmt.Fprintf(s.Out, "%s; # pos %d timestamp %d\n", query, c.Pos, c.Timestamp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maskedNote 表中有脱敏字段时在注释中注明
+func (s *SqlSink) maskedNote(table *schema.Table) string {
+	cols := s.Builder.MaskedColumns(table)
+	if len(cols) == 0 {
+		return ""
+	}
+	return " masked " + strings.Join(cols, ",")
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bytes"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"os"
+	"strings"
+	"testing"
+)
+
+// sqlInsert 值的数量与字段不一致时无法生成sql
+func sqlInsert(values ...interface{}) *event.RowChange {
+	return &event.RowChange{Tx: &event.Transaction{}, Table: testTable(), Action: canal.InsertAction, After: values}
+}
+
+func TestSqlSinkOnError(t *testing.T) {
+	tests := []struct {
+		name    string
+		onError string
+		wantErr bool
+		// want 输出中依次出现的内容，notWant 不应出现的内容
+		want    []string
+		notWant []string
+		skipped string
+	}{
+		{name: "默认为fail", wantErr: true, notWant: []string{"values(1,", "values(3,"}},
+		{name: "fail", onError: OnErrorFail, wantErr: true, notWant: []string{"values(1,", "values(3,"}},
+		{name: "skip", onError: OnErrorSkip, want: []string{"values(1,", "values(3,"}, notWant: []string{"# error"}, skipped: "共跳过1行：\n  test.user 1\n"},
+		{name: "comment", onError: OnErrorComment, want: []string{"values(1,", "# error: 字段不一致", "values(3,"}, skipped: "共跳过1行：\n  test.user 1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			s, err := NewSqlSink(&Options{Out: &out, ErrOut: &errOut, OnError: tt.onError})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx := &event.Transaction{}
+			if err := s.Begin(tx); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Row(sqlInsert(int32(1), "a", "b")); err != nil {
+				t.Fatal(err)
+			}
+			err = s.Row(sqlInsert(int32(2), "a"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Row() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if err := s.Row(sqlInsert(int32(3), "a", "b")); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Commit(tx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			got := out.String()
+			last := 0
+			for _, w := range tt.want {
+				i := strings.Index(got[last:], w)
+				if i < 0 {
+					t.Fatalf("输出 = %q, 应依次包含%q", got, tt.want)
+				}
+				last += i + len(w)
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(got, w) {
+					t.Errorf("输出 = %q, 不应包含%q", got, w)
+				}
+			}
+			if tt.skipped == "" && errOut.Len() != 0 || !strings.HasSuffix(errOut.String(), tt.skipped) {
+				t.Errorf("跳过的行数汇总 = %q, want %q", errOut.String(), tt.skipped)
+			}
+		})
+	}
+	if _, err := NewSqlSink(&Options{OnError: "ignore"}); err == nil {
+		t.Error("不支持的错误处理方式应返回错误")
+	}
+}
+
+// TestSqlSinkSpool 大事务转存到临时文件，提交时按顺序输出，丢弃和关闭时删除临时文件
+func TestSqlSinkSpool(t *testing.T) {
+	var out bytes.Buffer
+	s, err := NewSqlSink(&Options{Out: &out, OnError: OnErrorComment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.buf.limit = 64
+	tx := &event.Transaction{}
+	write := func(ids ...int32) string {
+		t.Helper()
+		if err := s.Begin(tx); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range ids {
+			if err := s.Row(sqlInsert(id, "a", "b")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 无法生成sql的注释也写入缓存
+		if err := s.Row(sqlInsert(int32(0))); err != nil {
+			t.Fatal(err)
+		}
+		if s.buf.file == nil {
+			t.Fatal("事务输出超过上限时应转存到临时文件")
+		}
+		return s.buf.file.Name()
+	}
+	removed := func(name string) {
+		t.Helper()
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("临时文件%s没有删除", name)
+		}
+	}
+
+	name := write(1, 2, 3)
+	if out.Len() != 0 {
+		t.Fatalf("提交前输出 = %q", out.String())
+	}
+	if err := s.Commit(tx); err != nil {
+		t.Fatal(err)
+	}
+	removed(name)
+	got := out.String()
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[0], "values(1,") || !strings.Contains(lines[2], "values(3,") || !strings.HasPrefix(lines[3], "# error") {
+		t.Fatalf("提交后输出 = %q", got)
+	}
+
+	// 事务之外的ddl直接输出
+	if err := s.DDL(&event.DDLChange{Schema: "test", Query: "create table t (id int)"}); err != nil {
+		t.Fatal(err)
+	}
+	got = out.String()
+
+	name = write(4, 5, 6)
+	if err := s.Discard(tx); err != nil {
+		t.Fatal(err)
+	}
+	removed(name)
+
+	// 关闭时丢弃未提交的事务
+	name = write(7, 8, 9)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	removed(name)
+	if out.String() != got {
+		t.Errorf("丢弃的事务不应输出: %q", strings.TrimPrefix(out.String(), got))
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"encoding/json"
+	"github.com/dhbin/ra/binlog/sql"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"strings"
+)
+
+// rowImage 以字段名为key的行数据，值按字段类型转换
+func rowImage(masker *sql.Masker, table *schema.Table, row []interface{}) map[string]interface{} {
+	if row == nil || len(row) != len(table.Columns) {
+		return nil
+	}
+	image := make(map[string]interface{}, len(row))
+	for i := range table.Columns {
+		column := &table.Columns[i]
+		if rule := masker.Rule(table, column.Name); rule != nil {
+			image[column.Name] = masker.MaskValue(rule, row[i])
+			continue
+		}
+		image[column.Name] = typedValue(column, row[i])
+	}
+	return image
+}
+
+func primaryKey(table *schema.Table, before map[string]interface{}, after map[string]interface{}) map[string]interface{} {
+	image := after
+	if image == nil {
+		image = before
+	}
+	if image == nil || len(table.PKColumns) == 0 {
+		return nil
+	}
+	pk := make(map[string]interface{}, len(table.PKColumns))
+	for _, idx := range table.PKColumns {
+		name := table.Columns[idx].Name
+		pk[name] = image[name]
+	}
+	return pk
+}
+
+func columnNames(table *schema.Table) []string {
+	columns := make([]string, len(table.Columns))
+	for i := range table.Columns {
+		columns[i] = table.Columns[i].Name
+	}
+	return columns
+}
+
+// typedValue 数字保持数字，decimal保留精度，json内嵌，二进制转为base64
+func typedValue(column *schema.TableColumn, val interface{}) interface{} {
+	if val == nil {
+		return nil
+	}
+	switch column.Type {
+	case schema.TYPE_DECIMAL:
+		if s, ok := val.(string); ok {
+			return json.Number(s)
+		}
+	case schema.TYPE_JSON:
+		var raw []byte
+		switch t := val.(type) {
+		case string:
+			raw = []byte(t)
+		case []byte:
+			raw = t
+		}
+		if json.Valid(raw) {
+			return json.RawMessage(raw)
+		}
+	case schema.TYPE_BINARY:
+		if s, ok := val.(string); ok {
+			return []byte(s)
+		}
+	case schema.TYPE_STRING:
+		// blob为二进制数据，text等转为字符串
+		if b, ok := val.([]byte); ok && !strings.Contains(column.RawType, "blob") {
+			return string(b)
+		}
+	}
+	return val
+}
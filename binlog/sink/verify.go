@@ -0,0 +1,421 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	dbsql "database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"github.com/go-sql-driver/mysql"
+	"io"
+	"math/big"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VerifyOptions 闪回前校验数据是否在之后被再次修改
+type VerifyOptions struct {
+	// DSN 校验的数据库，格式为user:password@tcp(host:port)/
+	DSN string
+	// SkipDrifted 生成的sql中去掉已被再次修改的行
+	SkipDrifted bool
+}
+
+// verifyState 行在解析范围内的最终状态
+type verifyState struct {
+	table *schema.Table
+	// row 最终数据，为nil时行应该不存在
+	row []interface{}
+	key string
+}
+
+// verifyOp 缓存的事件，校验完成后按顺序写入下游Sink
+type verifyOp struct {
+	tx   *event.Transaction
+	row  *event.RowChange
+	ddl  *event.DDLChange
+	keys []string
+}
+
+// VerifySink 闪回时校验每一行的数据是否仍与binlog中的after image一致。
+// 同一行在解析范围内可能被多次修改，只有最后一次修改后的数据需要与当前数据一致，
+// 所以变更先缓存在内存中，Close时统一校验后再写入下游Sink
+type VerifySink struct {
+	sink    event.Sink
+	options *VerifyOptions
+	errOut  io.Writer
+	builder *sql.Builder
+	db      *dbsql.DB
+	// fetch 按条件查询当前数据，行不存在时返回nil
+	fetch func(table *schema.Table, condition string) ([]dbsql.NullString, error)
+
+	ops    []*verifyOp
+	states map[string]*verifyState
+	order  []string
+}
+
+// NewVerifySink 创建VerifySink，校验后的变更写入s
+func NewVerifySink(s event.Sink, options *Options, verify *VerifyOptions) (*VerifySink, error) {
+	errOut := options.ErrOut
+	if errOut == nil {
+		errOut = os.Stderr
+	}
+	cfg, err := mysql.ParseDSN(verify.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("校验库DSN格式错误: %w", err)
+	}
+	// 查询到的timestamp按UTC显示，与binlog中的时刻比较
+	if cfg.Params == nil {
+		cfg.Params = make(map[string]string)
+	}
+	cfg.Params["time_zone"] = "'+00:00'"
+	db, err := dbsql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	v := &VerifySink{
+		sink:    s,
+		options: verify,
+		errOut:  errOut,
+		builder: &sql.Builder{Masker: options.Masker},
+		db:      db,
+		states:  make(map[string]*verifyState),
+	}
+	v.fetch = v.query
+	return v, nil
+}
+
+func (s *VerifySink) Begin(tx *event.Transaction) error {
+	s.ops = append(s.ops, &verifyOp{tx: tx})
+	return nil
+}
+
+// Row 闪回变更的Before是原始变更的after image，After是原始变更的before image
+func (s *VerifySink) Row(c *event.RowChange) error {
+	op := &verifyOp{row: c}
+	if err := s.trackRow(op); err != nil {
+		return err
+	}
+	s.ops = append(s.ops, op)
+	return nil
+}
+
+// trackRow 记录行变更涉及的行的最终状态
+func (s *VerifySink) trackRow(op *verifyOp) error {
+	c := op.row
+	op.keys = op.keys[:0]
+	if c.After != nil {
+		// 原始变更前的行被修改或删除，除非之后再次出现，否则应该不存在
+		key, err := s.track(c.Table, c.After, nil)
+		if err != nil {
+			return fmt.Errorf("%s pos %d: %w", c.File, c.Pos, err)
+		}
+		op.keys = append(op.keys, key)
+	}
+	if c.Before != nil {
+		key, err := s.track(c.Table, c.Before, c.Before)
+		if err != nil {
+			return fmt.Errorf("%s pos %d: %w", c.File, c.Pos, err)
+		}
+		op.keys = append(op.keys, key)
+	}
+	return nil
+}
+
+func (s *VerifySink) DDL(c *event.DDLChange) error {
+	s.ops = append(s.ops, &verifyOp{ddl: c})
+	return nil
+}
+
+func (s *VerifySink) Commit(tx *event.Transaction) error {
+	s.ops = append(s.ops, &verifyOp{tx: tx})
+	return nil
+}
+
+// Discard 丢弃未完成事务缓存的变更，并重新计算行的最终状态
+func (s *VerifySink) Discard(tx *event.Transaction) error {
+	for i := len(s.ops) - 1; i >= 0; i-- {
+		if s.ops[i].tx == tx {
+			s.ops = s.ops[:i]
+			break
+		}
+	}
+	s.states = make(map[string]*verifyState)
+	s.order = s.order[:0]
+	for _, op := range s.ops {
+		if op.row == nil {
+			continue
+		}
+		if err := s.trackRow(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 校验所有行并写入下游Sink
+func (s *VerifySink) Close() error {
+	drifted, err := s.verify()
+	if s.db != nil {
+		if closeErr := s.db.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = s.replay(drifted)
+	}
+	if closeErr := s.sink.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// track 记录行的最终状态，返回行的标识
+func (s *VerifySink) track(table *schema.Table, image []interface{}, row []interface{}) (string, error) {
+	condition, err := s.builder.BuildKeyCondition(table, image)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("`%s`.`%s` where %s", table.Schema, table.Name, condition)
+	state, ok := s.states[key]
+	if !ok {
+		state = &verifyState{table: table, key: condition}
+		s.states[key] = state
+		s.order = append(s.order, key)
+	}
+	state.row = row
+	return key, nil
+}
+
+// verify 按主键查询当前数据，返回已被再次修改的行。
+// float、json等字段在sql中与binlog中的值直接比较不相等，所以查询后逐个字段比较
+func (s *VerifySink) verify() (map[string]bool, error) {
+	drifted := make(map[string]bool)
+	counts := make(map[string]int)
+	for _, key := range s.order {
+		state := s.states[key]
+		current, err := s.fetch(state.table, state.key)
+		if err != nil {
+			return nil, fmt.Errorf("校验%s.%s失败: %w", state.table.Schema, state.table.Name, err)
+		}
+		reason := "已被修改或删除"
+		if state.row == nil {
+			if current == nil {
+				continue
+			}
+			reason = "已被重新插入"
+		} else if current != nil && s.sameRow(state.table, state.row, current) {
+			continue
+		}
+		drifted[key] = true
+		name := state.table.Schema + "." + state.table.Name
+		counts[name]++
+		_, _ = fmt.Fprintf(s.errOut, "# drift %s %s: %s\n", name, state.key, reason)
+	}
+	if len(counts) == 0 {
+		return drifted, nil
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, _ = fmt.Fprintf(s.errOut, "# %s 共%d行数据在之后被再次修改\n", name, counts[name])
+	}
+	if s.options.SkipDrifted {
+		_, _ = fmt.Fprintln(s.errOut, "# 已被再次修改的行不会生成sql")
+	}
+	return drifted, nil
+}
+
+// replay 按原始顺序写入下游Sink
+func (s *VerifySink) replay(drifted map[string]bool) error {
+	begun := false
+	for _, op := range s.ops {
+		var err error
+		switch {
+		case op.row != nil:
+			if s.options.SkipDrifted && isDrifted(drifted, op.keys) {
+				continue
+			}
+			err = s.sink.Row(op.row)
+		case op.ddl != nil:
+			err = s.sink.DDL(op.ddl)
+		case !begun:
+			begun = true
+			err = s.sink.Begin(op.tx)
+		default:
+			begun = false
+			err = s.sink.Commit(op.tx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isDrifted(drifted map[string]bool, keys []string) bool {
+	for _, key := range keys {
+		if drifted[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// query 查询条件匹配的第一行数据，按表字段顺序返回
+func (s *VerifySink) query(table *schema.Table, condition string) ([]dbsql.NullString, error) {
+	columns := make([]string, len(table.Columns))
+	for i := range table.Columns {
+		columns[i] = "`" + table.Columns[i].Name + "`"
+	}
+	query := fmt.Sprintf("select %s from `%s`.`%s` where %s limit 1", strings.Join(columns, ", "), table.Schema, table.Name, condition)
+	values := make([]dbsql.NullString, len(table.Columns))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err := s.db.QueryRow(query).Scan(dest...)
+	if err == dbsql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// sameRow 当前数据是否与binlog中的数据一致，脱敏字段不参与比较
+func (s *VerifySink) sameRow(table *schema.Table, row []interface{}, current []dbsql.NullString) bool {
+	if len(row) != len(table.Columns) || len(current) != len(table.Columns) {
+		return false
+	}
+	for i := range table.Columns {
+		if s.builder.Masker.Rule(table, table.Columns[i].Name) != nil {
+			continue
+		}
+		if !sameValue(&table.Columns[i], row[i], current[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameValue 按字段类型比较binlog中的值与查询到的文本值
+func sameValue(column *schema.TableColumn, expected interface{}, current dbsql.NullString) bool {
+	if expected == nil || !current.Valid {
+		return expected == nil && !current.Valid
+	}
+	actual := current.String
+	switch column.Type {
+	case schema.TYPE_FLOAT:
+		a, err := strconv.ParseFloat(fmt.Sprint(expected), 64)
+		if err != nil {
+			return false
+		}
+		b, err := strconv.ParseFloat(actual, 64)
+		if err != nil {
+			return false
+		}
+		// 5.7查询float时只保留6位有效数字，double保留15位
+		digits := 15
+		if _, ok := expected.(float32); ok || strings.HasPrefix(column.RawType, "float") {
+			if float32(a) == float32(b) {
+				return true
+			}
+			digits = 6
+		}
+		return a == b || strconv.FormatFloat(a, 'g', digits, 64) == strconv.FormatFloat(b, 'g', digits, 64)
+	case schema.TYPE_DECIMAL:
+		a, ok := new(big.Rat).SetString(fmt.Sprint(expected))
+		if !ok {
+			return false
+		}
+		b, ok := new(big.Rat).SetString(actual)
+		return ok && a.Cmp(b) == 0
+	case schema.TYPE_JSON:
+		var a, b interface{}
+		if json.Unmarshal([]byte(stringValue(expected)), &a) != nil || json.Unmarshal([]byte(actual), &b) != nil {
+			return stringValue(expected) == actual
+		}
+		return reflect.DeepEqual(a, b)
+	case schema.TYPE_BIT:
+		// 查询结果为大端序的二进制
+		var v uint64
+		for _, c := range []byte(actual) {
+			v = v<<8 | uint64(c)
+		}
+		return fmt.Sprint(expected) == strconv.FormatUint(v, 10) || fmt.Sprint(expected) == strconv.FormatInt(int64(v), 10)
+	case schema.TYPE_ENUM:
+		// binlog中为从1开始的序号
+		idx, err := strconv.Atoi(fmt.Sprint(expected))
+		if err != nil || idx < 0 || idx > len(column.EnumValues) {
+			return fmt.Sprint(expected) == actual
+		}
+		if idx == 0 {
+			return actual == ""
+		}
+		return column.EnumValues[idx-1] == actual
+	case schema.TYPE_TIMESTAMP:
+		// binlog中按本地时区格式化，查询结果为UTC，比较时刻
+		a, err := parseTime("2006-01-02 15:04:05", stringValue(expected), timestampLocation)
+		if err != nil || a == nil {
+			return stringValue(expected) == actual
+		}
+		b, err := parseTime("2006-01-02 15:04:05", actual, time.UTC)
+		return err == nil && b != nil && a.Equal(*b)
+	case schema.TYPE_SET:
+		// binlog中为位图
+		bits, err := strconv.ParseInt(fmt.Sprint(expected), 10, 64)
+		if err != nil {
+			return fmt.Sprint(expected) == actual
+		}
+		var values []string
+		for i, v := range column.SetValues {
+			if bits&(1<<i) != 0 {
+				values = append(values, v)
+			}
+		}
+		return strings.Join(values, ",") == actual
+	}
+	return stringValue(expected) == actual
+}
+
+func stringValue(val interface{}) string {
+	switch t := val.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	default:
+		return fmt.Sprint(t)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bytes"
+	dbsql "database/sql"
+	"github.com/dhbin/ra/binlog/event"
+	"github.com/dhbin/ra/binlog/sql"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSameValue(t *testing.T) {
+	null := dbsql.NullString{}
+	str := func(s string) dbsql.NullString {
+		return dbsql.NullString{String: s, Valid: true}
+	}
+	float := schema.TableColumn{Type: schema.TYPE_FLOAT, RawType: "float"}
+	double := schema.TableColumn{Type: schema.TYPE_FLOAT, RawType: "double"}
+	jsonColumn := schema.TableColumn{Type: schema.TYPE_JSON, RawType: "json"}
+	tests := []struct {
+		name     string
+		column   schema.TableColumn
+		expected interface{}
+		current  dbsql.NullString
+		want     bool
+	}{
+		{name: "null", column: schema.TableColumn{Type: schema.TYPE_NUMBER}, expected: nil, current: null, want: true},
+		{name: "null与值", column: schema.TableColumn{Type: schema.TYPE_NUMBER}, expected: nil, current: str("0")},
+		{name: "值与null", column: schema.TableColumn{Type: schema.TYPE_STRING}, expected: "", current: null},
+		{name: "整数", column: schema.TableColumn{Type: schema.TYPE_NUMBER}, expected: int64(-3), current: str("-3"), want: true},
+		{name: "无符号整数", column: schema.TableColumn{Type: schema.TYPE_NUMBER}, expected: uint64(18446744073709551615), current: str("18446744073709551615"), want: true},
+		{name: "float", column: float, expected: float32(1.1), current: str("1.1"), want: true},
+		{name: "float显示6位有效数字", column: float, expected: float32(1.1234567), current: str("1.12346"), want: true},
+		{name: "float被修改", column: float, expected: float32(1.1), current: str("1.2")},
+		{name: "double", column: double, expected: 0.1 + 0.2, current: str("0.30000000000000004"), want: true},
+		{name: "double被修改", column: double, expected: 0.3, current: str("0.31")},
+		{name: "decimal", column: schema.TableColumn{Type: schema.TYPE_DECIMAL}, expected: "1.10", current: str("1.10"), want: true},
+		{name: "decimal被修改", column: schema.TableColumn{Type: schema.TYPE_DECIMAL}, expected: "1.10", current: str("1.11")},
+		{name: "json格式不同", column: jsonColumn, expected: []byte(`{"b":[1,2],"a":"x"}`), current: str(`{"a": "x", "b": [1, 2]}`), want: true},
+		{name: "json浮点", column: jsonColumn, expected: `{"a":1}`, current: str(`{"a": 1.0}`), want: true},
+		{name: "json被修改", column: jsonColumn, expected: `{"a":1}`, current: str(`{"a": 2}`)},
+		{name: "bit", column: schema.TableColumn{Type: schema.TYPE_BIT}, expected: int64(5), current: str("\x05"), want: true},
+		{name: "bit(64)", column: schema.TableColumn{Type: schema.TYPE_BIT}, expected: int64(-1), current: str(strings.Repeat("\xff", 8)), want: true},
+		{name: "enum", column: schema.TableColumn{Type: schema.TYPE_ENUM, EnumValues: []string{"a", "b"}}, expected: int64(2), current: str("b"), want: true},
+		{name: "enum被修改", column: schema.TableColumn{Type: schema.TYPE_ENUM, EnumValues: []string{"a", "b"}}, expected: int64(1), current: str("b")},
+		{name: "set", column: schema.TableColumn{Type: schema.TYPE_SET, SetValues: []string{"a", "b", "c"}}, expected: int64(5), current: str("a,c"), want: true},
+		{name: "二进制", column: schema.TableColumn{Type: schema.TYPE_STRING, RawType: "blob"}, expected: []byte{0, 0xff}, current: str("\x00\xff"), want: true},
+		{name: "datetime", column: schema.TableColumn{Type: schema.TYPE_DATETIME}, expected: "2023-01-01 00:00:00", current: str("2023-01-01 00:00:00"), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameValue(&tt.column, tt.expected, tt.current); got != tt.want {
+				t.Errorf("sameValue(%#v, %q) = %v, want %v", tt.expected, tt.current.String, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameValueTimestamp(t *testing.T) {
+	defer func(loc *time.Location) { timestampLocation = loc }(timestampLocation)
+	// binlog按UTC+8格式化，校验连接的time_zone为UTC
+	timestampLocation = time.FixedZone("UTC+8", 8*3600)
+	column := schema.TableColumn{Type: schema.TYPE_TIMESTAMP}
+	tests := []struct {
+		name     string
+		expected interface{}
+		current  string
+		want     bool
+	}{
+		{name: "同一时刻", expected: "2023-01-01 08:00:00", current: "2023-01-01 00:00:00", want: true},
+		{name: "小数秒", expected: "2023-01-01 08:00:00.500", current: "2023-01-01 00:00:00.500", want: true},
+		{name: "本地时间与UTC字符串相同", expected: "2023-01-01 08:00:00", current: "2023-01-01 08:00:00"},
+		{name: "零值", expected: "0000-00-00 00:00:00", current: "0000-00-00 00:00:00", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := dbsql.NullString{String: tt.current, Valid: true}
+			if got := sameValue(&column, tt.expected, current); got != tt.want {
+				t.Errorf("sameValue(%v, %q) = %v, want %v", tt.expected, tt.current, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySink(t *testing.T) {
+	table := &schema.Table{
+		Schema: "test",
+		Name:   "t",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER, RawType: "int"},
+			{Name: "f", Type: schema.TYPE_FLOAT, RawType: "float"},
+			{Name: "j", Type: schema.TYPE_JSON, RawType: "json"},
+		},
+		PKColumns: []int{0},
+	}
+	// 数据库中的当前数据，按主键条件查询
+	current := map[string][]dbsql.NullString{
+		// 与binlog中的after image一致
+		"`id` = 1": {{String: "1", Valid: true}, {String: "2.1", Valid: true}, {String: `{"a": 2}`, Valid: true}},
+		// 之后被再次修改
+		"`id` = 2": {{String: "2", Valid: true}, {String: "9.9", Valid: true}, {String: `{"a": 2}`, Valid: true}},
+		// 被删除后重新插入
+		"`id` = 3": {{String: "3", Valid: true}, {String: "1", Valid: true}, {Valid: false}},
+	}
+	// 闪回变更：Before为原始变更的after image
+	changes := []*event.RowChange{
+		{Action: canal.UpdateAction, Before: []interface{}{int32(1), float32(2.1), `{"a":2}`}, After: []interface{}{int32(1), float32(1.5), `{"a":1}`}},
+		{Action: canal.UpdateAction, Before: []interface{}{int32(2), float32(2.1), `{"a":2}`}, After: []interface{}{int32(2), float32(1.5), `{"a":1}`}},
+		{Action: canal.InsertAction, After: []interface{}{int32(3), float32(1), nil}},
+	}
+	tests := []struct {
+		name        string
+		skipDrifted bool
+		want        []string
+	}{
+		{name: "只报告", want: []string{"update test.t1", "update test.t2", "insert test.t3"}},
+		{name: "跳过被再次修改的行", skipDrifted: true, want: []string{"update test.t1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errOut bytes.Buffer
+			out := &recordSink{}
+			s := &VerifySink{
+				sink:    out,
+				options: &VerifyOptions{SkipDrifted: tt.skipDrifted},
+				errOut:  &errOut,
+				builder: &sql.Builder{},
+				states:  make(map[string]*verifyState),
+				fetch: func(_ *schema.Table, condition string) ([]dbsql.NullString, error) {
+					return current[condition], nil
+				},
+			}
+			tx := &event.Transaction{}
+			_ = s.Begin(tx)
+			for _, c := range changes {
+				c.Tx, c.Table = tx, table
+				if err := s.Row(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_ = s.Commit(tx)
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out.records, tt.want) {
+				t.Errorf("records = %v, want %v", out.records, tt.want)
+			}
+			report := errOut.String()
+			if strings.Contains(report, "`id` = 1") {
+				t.Errorf("float、json字段未变化的行不应报告:\n%s", report)
+			}
+			if !strings.Contains(report, "# drift test.t `id` = 2: 已被修改或删除") || !strings.Contains(report, "# drift test.t `id` = 3: 已被重新插入") {
+				t.Errorf("report:\n%s", report)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// snapshotRow 快照中的一行数据，columns为nil时按表字段顺序
+type snapshotRow struct {
+	schema  string
+	table   string
+	columns []string
+	values  []interface{}
+	// utc TIMESTAMP字段的值为UTC时间，否则为本机时区的时间
+	utc bool
+}
+
+// snapshotCreate 快照中的CREATE TABLE，definition为表名之后的部分
+type snapshotCreate struct {
+	schema     string
+	table      string
+	definition string
+}
+
+// loadDump 读取mysqldump导出的CREATE TABLE和insert语句，没有USE语句时库名为database。
+// mysqldump默认的--tz-utc会输出SET TIME_ZONE='+00:00'，之后的TIMESTAMP值为UTC时间
+func loadDump(file string, database string, create func(c *snapshotCreate) error, fn func(row *snapshotRow) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	lineNo := 0
+	utc := false
+	var stmt strings.Builder
+	start := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		lineNo++
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case stmt.Len() > 0:
+			// CREATE TABLE跨多行，以行末的分号结束
+			stmt.WriteString("\n")
+			stmt.WriteString(trimmed)
+		case strings.HasPrefix(trimmed, "USE "):
+			database = strings.Trim(strings.TrimSuffix(strings.TrimPrefix(trimmed, "USE "), ";"), "`")
+		case strings.Contains(strings.ToUpper(trimmed), "SET TIME_ZONE="):
+			utc = strings.Contains(trimmed, "'+00:00'")
+		case strings.HasPrefix(strings.ToUpper(trimmed), "CREATE TABLE "):
+			stmt.WriteString(trimmed)
+			start = lineNo
+		case strings.HasPrefix(trimmed, "INSERT INTO "):
+			if database == "" {
+				return fmt.Errorf("%s第%d行: 没有USE语句，需要指定database", file, lineNo)
+			}
+			parseErr := parseInsert(trimmed, database, func(row *snapshotRow) error {
+				row.utc = utc
+				return fn(row)
+			})
+			if parseErr != nil {
+				return fmt.Errorf("%s第%d行: %w", file, lineNo, parseErr)
+			}
+		}
+		if stmt.Len() > 0 && strings.HasSuffix(trimmed, ";") {
+			c, parseErr := parseCreate(stmt.String(), database)
+			stmt.Reset()
+			if parseErr != nil {
+				return fmt.Errorf("%s第%d行: %w", file, start, parseErr)
+			}
+			if create != nil {
+				if err := create(c); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// parseCreate 解析CREATE TABLE [IF NOT EXISTS] [`db`.]`table` ...;
+func parseCreate(stmt string, database string) (*snapshotCreate, error) {
+	s := strings.TrimSpace(stmt[len("CREATE TABLE "):])
+	if strings.HasPrefix(strings.ToUpper(s), "IF NOT EXISTS ") {
+		s = s[len("IF NOT EXISTS "):]
+	}
+	name, s, err := parseIdentifier(s)
+	if err != nil {
+		return nil, err
+	}
+	schemaName := database
+	if strings.HasPrefix(s, ".") {
+		schemaName = name
+		if name, s, err = parseIdentifier(s[1:]); err != nil {
+			return nil, err
+		}
+	}
+	if schemaName == "" {
+		return nil, errors.New("没有USE语句，需要指定database")
+	}
+	return &snapshotCreate{schema: schemaName, table: name, definition: strings.TrimSuffix(s, ";")}, nil
+}
+
+// parseInsert 解析INSERT INTO `table` [(`col`, ...)] VALUES (...),(...);
+func parseInsert(stmt string, database string, fn func(row *snapshotRow) error) error {
+	s := strings.TrimPrefix(stmt, "INSERT INTO ")
+	name, s, err := parseIdentifier(s)
+	if err != nil {
+		return err
+	}
+	schemaName := database
+	if strings.HasPrefix(s, ".") {
+		schemaName = name
+		if name, s, err = parseIdentifier(s[1:]); err != nil {
+			return err
+		}
+	}
+	s = strings.TrimSpace(s)
+	var columns []string
+	if strings.HasPrefix(s, "(") {
+		end := strings.Index(s, ")")
+		if end < 0 {
+			return errors.New("字段列表格式错误")
+		}
+		for _, col := range strings.Split(s[1:end], ",") {
+			columns = append(columns, strings.Trim(strings.TrimSpace(col), "`"))
+		}
+		s = strings.TrimSpace(s[end+1:])
+	}
+	if !strings.HasPrefix(strings.ToUpper(s), "VALUES") {
+		return errors.New("缺少VALUES")
+	}
+	p := &valueParser{s: s[len("VALUES"):]}
+	for {
+		p.skipSpace()
+		if p.done() || p.peek() == ';' {
+			return nil
+		}
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		values, err := p.tuple()
+		if err != nil {
+			return err
+		}
+		if err := fn(&snapshotRow{schema: schemaName, table: name, columns: columns, values: values}); err != nil {
+			return err
+		}
+	}
+}
+
+func parseIdentifier(s string) (string, string, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "`") {
+		end := strings.Index(s[1:], "`")
+		if end < 0 {
+			return "", "", errors.New("表名格式错误")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	end := strings.IndexAny(s, " .(")
+	if end < 0 {
+		return "", "", errors.New("表名格式错误")
+	}
+	return s[:end], s[end:], nil
+}
+
+// valueParser 解析mysqldump输出的值
+type valueParser struct {
+	s   string
+	pos int
+}
+
+func (p *valueParser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *valueParser) peek() byte {
+	return p.s[p.pos]
+}
+
+func (p *valueParser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\n' || p.peek() == '\r' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *valueParser) tuple() ([]interface{}, error) {
+	if p.peek() != '(' {
+		return nil, fmt.Errorf("第%d个字符应该为(", p.pos)
+	}
+	p.pos++
+	var values []interface{}
+	for {
+		p.skipSpace()
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		p.skipSpace()
+		if p.done() {
+			return nil, errors.New("values不完整")
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return values, nil
+		default:
+			return nil, fmt.Errorf("第%d个字符格式错误", p.pos)
+		}
+	}
+}
+
+func (p *valueParser) value() (interface{}, error) {
+	if p.done() {
+		return nil, errors.New("values不完整")
+	}
+	if strings.HasPrefix(p.s[p.pos:], "_binary ") {
+		p.pos += len("_binary ")
+	}
+	if p.peek() == '\'' {
+		return p.quoted()
+	}
+	start := p.pos
+	for !p.done() && p.peek() != ',' && p.peek() != ')' {
+		p.pos++
+	}
+	token := strings.TrimSpace(p.s[start:p.pos])
+	switch {
+	case strings.EqualFold(token, "NULL"):
+		return nil, nil
+	case strings.HasPrefix(token, "0x") || strings.HasPrefix(token, "0X"):
+		b, err := hex.DecodeString(token[2:])
+		if err != nil {
+			return nil, fmt.Errorf("十六进制格式错误: %s", token)
+		}
+		return string(b), nil
+	case len(token) >= 3 && (token[0] == 'b' || token[0] == 'B') && token[1] == '\'' && token[len(token)-1] == '\'':
+		// bit字段，转换为与binlog中一致的整数
+		v, err := strconv.ParseUint(token[2:len(token)-1], 2, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bit格式错误: %s", token)
+		}
+		return strconv.FormatUint(v, 10), nil
+	}
+	return token, nil
+}
+
+func (p *valueParser) quoted() (interface{}, error) {
+	p.pos++
+	var b strings.Builder
+	for !p.done() {
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '\\':
+			if p.done() {
+				return nil, errors.New("字符串不完整")
+			}
+			e := p.peek()
+			p.pos++
+			switch e {
+			case '0':
+				b.WriteByte(0)
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			case 'Z':
+				b.WriteByte(26)
+			default:
+				b.WriteByte(e)
+			}
+		case '\'':
+			if !p.done() && p.peek() == '\'' {
+				p.pos++
+				b.WriteByte('\'')
+				continue
+			}
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return nil, errors.New("字符串不完整")
+}
+
+// loadCsv 读取带表头的csv，\N为NULL
+func loadCsv(file string, schemaName string, table string, fn func(row *snapshotRow) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := csv.NewReader(bufio.NewReader(f))
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("%s读取表头失败: %w", file, err)
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		values := make([]interface{}, len(record))
+		for i, v := range record {
+			if v != `\N` {
+				values[i] = v
+			}
+		}
+		if err := fn(&snapshotRow{schema: schemaName, table: table, columns: header, values: values}); err != nil {
+			return err
+		}
+	}
+}
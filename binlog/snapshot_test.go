@@ -0,0 +1,194 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseInsert(t *testing.T) {
+	tests := []struct {
+		name    string
+		stmt    string
+		want    []*snapshotRow
+		wantErr bool
+	}{
+		{
+			name: "多行",
+			stmt: "INSERT INTO `user` VALUES (1,'alice'),(2,'bob');",
+			want: []*snapshotRow{
+				{schema: "test", table: "user", values: []interface{}{"1", "alice"}},
+				{schema: "test", table: "user", values: []interface{}{"2", "bob"}},
+			},
+		},
+		{
+			name: "字段列表和库名",
+			stmt: "INSERT INTO `db2`.`user` (`id`, `name`) VALUES (1, 'a');",
+			want: []*snapshotRow{{schema: "db2", table: "user", columns: []string{"id", "name"}, values: []interface{}{"1", "a"}}},
+		},
+		{
+			name: "没有反引号",
+			stmt: "INSERT INTO user VALUES (1,NULL);",
+			want: []*snapshotRow{{schema: "test", table: "user", values: []interface{}{"1", nil}}},
+		},
+		{
+			name: "转义",
+			stmt: `INSERT INTO t VALUES ('a\'b','c''d','\\','\n\r\t\0\Z','\%');`,
+			want: []*snapshotRow{{schema: "test", table: "t", values: []interface{}{"a'b", "c'd", `\`, "\n\r\t\x00\x1a", "%"}}},
+		},
+		{
+			name: "字符串中的逗号和括号",
+			stmt: "INSERT INTO t VALUES ('a,b)','(c');",
+			want: []*snapshotRow{{schema: "test", table: "t", values: []interface{}{"a,b)", "(c"}}},
+		},
+		{
+			name: "_binary和十六进制",
+			stmt: "INSERT INTO t VALUES (_binary 'ab',0x00FF,0X61,'');",
+			want: []*snapshotRow{{schema: "test", table: "t", values: []interface{}{"ab", "\x00\xff", "a", ""}}},
+		},
+		{
+			name: "bit",
+			stmt: "INSERT INTO t VALUES (b'101',B'0',b'1111111111111111111111111111111111111111111111111111111111111111');",
+			want: []*snapshotRow{{schema: "test", table: "t", values: []interface{}{"5", "0", "18446744073709551615"}}},
+		},
+		{
+			name: "数字和负数",
+			stmt: "INSERT INTO t VALUES (-1.5,1e3, 7 );",
+			want: []*snapshotRow{{schema: "test", table: "t", values: []interface{}{"-1.5", "1e3", "7"}}},
+		},
+		{name: "缺少VALUES", stmt: "INSERT INTO t (1);", wantErr: true},
+		{name: "字符串不完整", stmt: "INSERT INTO t VALUES ('a", wantErr: true},
+		{name: "values不完整", stmt: "INSERT INTO t VALUES (1,", wantErr: true},
+		{name: "十六进制格式错误", stmt: "INSERT INTO t VALUES (0xZZ);", wantErr: true},
+		{name: "bit格式错误", stmt: "INSERT INTO t VALUES (b'12');", wantErr: true},
+		{name: "表名格式错误", stmt: "INSERT INTO `t VALUES (1);", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []*snapshotRow
+			err := parseInsert(tt.stmt, "test", func(row *snapshotRow) error {
+				got = append(got, row)
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseInsert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseInsert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadDump(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		database string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "USE切换库",
+			data: "-- dump\nUSE `a`;\nINSERT INTO `t` VALUES (1);\nUSE `b`;\nINSERT INTO `t` VALUES (2);",
+			want: []string{"a.t", "b.t"},
+		},
+		{name: "没有USE时使用database", data: "INSERT INTO `t` VALUES (1);\n", database: "c", want: []string{"c.t"}},
+		{name: "没有USE和database", data: "INSERT INTO `t` VALUES (1);\n", wantErr: true},
+		{name: "忽略其它语句", data: "CREATE TABLE `t` (id int);\nLOCK TABLES `t` WRITE;\n", database: "c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "dump.sql")
+			if err := os.WriteFile(file, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			err := loadDump(file, tt.database, nil, func(row *snapshotRow) error {
+				got = append(got, row.schema+"."+row.table)
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadDump() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadDump() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCsv(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "t.csv")
+	if err := os.WriteFile(file, []byte("id,name\n1,\\N\n2,\"a,\"\"b\"\"\"\n3,\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got []*snapshotRow
+	err := loadCsv(file, "test", "t", func(row *snapshotRow) error {
+		got = append(got, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := []string{"id", "name"}
+	want := []*snapshotRow{
+		{schema: "test", table: "t", columns: columns, values: []interface{}{"1", nil}},
+		{schema: "test", table: "t", columns: columns, values: []interface{}{"2", `a,"b"`}},
+		{schema: "test", table: "t", columns: columns, values: []interface{}{"3", ""}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadCsv() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadDumpCreate(t *testing.T) {
+	data := "USE `a`;\n/*!40103 SET TIME_ZONE='+00:00' */;\nCREATE TABLE `t` (\n  `id` int NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB;\n" +
+		"INSERT INTO `t` VALUES (1);\nCREATE TABLE IF NOT EXISTS `b`.`u` (id int);\n/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;\nINSERT INTO `t` VALUES (2);\n"
+	file := filepath.Join(t.TempDir(), "dump.sql")
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var creates []snapshotCreate
+	var utc []bool
+	err := loadDump(file, "", func(c *snapshotCreate) error {
+		creates = append(creates, *c)
+		return nil
+	}, func(row *snapshotRow) error {
+		utc = append(utc, row.utc)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []snapshotCreate{
+		{schema: "a", table: "t", definition: " (\n`id` int NOT NULL,\nPRIMARY KEY (`id`)\n) ENGINE=InnoDB"},
+		{schema: "b", table: "u", definition: " (id int)"},
+	}
+	if !reflect.DeepEqual(creates, want) {
+		t.Errorf("create = %+v, want %+v", creates, want)
+	}
+	if !reflect.DeepEqual(utc, []bool{true, false}) {
+		t.Errorf("utc = %v, want [true false]", utc)
+	}
+}
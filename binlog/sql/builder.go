@@ -23,79 +23,140 @@ import (
 	"strings"
 )
 
+// Builder sql构建器
+type Builder struct {
+	// Masker 敏感字段脱敏，为nil时不脱敏
+	Masker *Masker
+}
+
 // BuildInsertSql 构建插入sql
-func BuildInsertSql(table *schema.Table, rows []interface{}) string {
+func (b *Builder) BuildInsertSql(table *schema.Table, rows []interface{}) (string, error) {
 	err := check(table, rows, "insert")
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
 	colLength := len(table.Columns)
 	colsName := make([]string, colLength)
 	colsVal := make([]string, colLength)
 	for i := range table.Columns {
 		colsName[i] = wrapColName(table.Columns[i].Name)
-		colsVal[i] = typeConvertString(&table.Columns[i], rows[i])
+		colsVal[i] = b.valueString(table, &table.Columns[i], rows[i])
 	}
 	cols := strings.Join(colsName, ", ")
 	values := strings.Join(colsVal, ", ")
 	sqlTemplate := "insert into `%v`.`%v` (%v) values(%v);"
-	return fmt.Sprintf(sqlTemplate, table.Schema, table.Name, cols, values)
+	return fmt.Sprintf(sqlTemplate, table.Schema, table.Name, cols, values), nil
 }
 
 // BuildDeleteSql 构建删除sql
-func BuildDeleteSql(table *schema.Table, rows []interface{}) string {
+func (b *Builder) BuildDeleteSql(table *schema.Table, rows []interface{}) (string, error) {
 	err := check(table, rows, "delete")
 	if err != nil {
-		return err.Error()
+		return "", err
+	}
+	conditions := b.genCondition(table, rows)
+	if len(conditions) == 0 {
+		return "", fmt.Errorf("字段全部脱敏，无法生成delete sql table: %s.%s", table.Schema, table.Name)
 	}
-	conditions := genCondition(table, rows)
 	sqlTemplate := "delete from `%v`.`%v` where %s limit 1;"
-	return fmt.Sprintf(sqlTemplate, table.Schema, table.Name, strings.Join(conditions, " and "))
+	return fmt.Sprintf(sqlTemplate, table.Schema, table.Name, strings.Join(conditions, " and ")), nil
 }
 
 // BuildUpdateSql 构建更新sql
-func BuildUpdateSql(table *schema.Table, conditionRow []interface{}, row []interface{}) string {
+func (b *Builder) BuildUpdateSql(table *schema.Table, conditionRow []interface{}, row []interface{}) (string, error) {
 	err := check(table, row, "update")
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
 	err = check(table, conditionRow, "update")
 	if err != nil {
-		return err.Error()
+		return "", err
+	}
+	conditions := b.genCondition(table, conditionRow)
+	if len(conditions) == 0 {
+		return "", fmt.Errorf("字段全部脱敏，无法生成update sql table: %s.%s", table.Schema, table.Name)
 	}
 	sqlTemplate := "update `%v`.`%v` set %s where %s limit 1;"
-	setValues := strings.Join(genAssignment(table, row), ", ")
-	conditions := strings.Join(genCondition(table, conditionRow), " and ")
-	return fmt.Sprintf(sqlTemplate, table.Schema, table.Name, setValues, conditions)
+	setValues := strings.Join(b.genAssignment(table, row), ", ")
+	return fmt.Sprintf(sqlTemplate, table.Schema, table.Name, setValues, strings.Join(conditions, " and ")), nil
 }
 
-func genAssignment(table *schema.Table, rows []interface{}) []string {
+// BuildCondition 构建匹配整行数据的where条件，脱敏字段不参与条件
+func (b *Builder) BuildCondition(table *schema.Table, row []interface{}) (string, error) {
+	err := check(table, row, "where")
+	if err != nil {
+		return "", err
+	}
+	conditions := b.genCondition(table, row)
+	if len(conditions) == 0 {
+		return "", fmt.Errorf("字段全部脱敏，无法生成where条件 table: %s.%s", table.Schema, table.Name)
+	}
+	return strings.Join(conditions, " and "), nil
+}
+
+// BuildKeyCondition 构建按主键匹配的where条件，没有主键时匹配整行数据
+func (b *Builder) BuildKeyCondition(table *schema.Table, row []interface{}) (string, error) {
+	if len(table.PKColumns) == 0 {
+		return b.BuildCondition(table, row)
+	}
+	err := check(table, row, "where")
+	if err != nil {
+		return "", err
+	}
+	conditions := make([]string, len(table.PKColumns))
+	for i, idx := range table.PKColumns {
+		column := &table.Columns[idx]
+		if row[idx] == nil {
+			conditions[i] = fmt.Sprintf("`%s` is null", column.Name)
+		} else {
+			conditions[i] = fmt.Sprintf("`%s` = %s", column.Name, typeConvertString(column, row[idx]))
+		}
+	}
+	return strings.Join(conditions, " and "), nil
+}
+
+// MaskedColumns 表中被脱敏的字段
+func (b *Builder) MaskedColumns(table *schema.Table) []string {
+	return b.Masker.MaskedColumns(table)
+}
+
+func (b *Builder) genAssignment(table *schema.Table, rows []interface{}) []string {
 	colLength := len(table.Columns)
 	values := make([]string, colLength)
 	for i := range table.Columns {
-		values[i] = fmt.Sprintf("`%s` = %s", table.Columns[i].Name, typeConvertString(&table.Columns[i], rows[i]))
+		values[i] = fmt.Sprintf("`%s` = %s", table.Columns[i].Name, b.valueString(table, &table.Columns[i], rows[i]))
 	}
 	return values
 }
 
-func genCondition(table *schema.Table, rows []interface{}) []string {
-	colLength := len(table.Columns)
-	values := make([]string, colLength)
+// genCondition 生成where条件，脱敏字段不参与条件
+func (b *Builder) genCondition(table *schema.Table, rows []interface{}) []string {
+	values := make([]string, 0, len(table.Columns))
 	for i := range table.Columns {
+		if b.Masker.Rule(table, table.Columns[i].Name) != nil {
+			continue
+		}
 		if rows[i] == nil {
-			values[i] = fmt.Sprintf("`%s` is null", table.Columns[i].Name)
+			values = append(values, fmt.Sprintf("`%s` is null", table.Columns[i].Name))
 		} else {
-			values[i] = fmt.Sprintf("`%s` = %s", table.Columns[i].Name, typeConvertString(&table.Columns[i], rows[i]))
+			values = append(values, fmt.Sprintf("`%s` = %s", table.Columns[i].Name, typeConvertString(&table.Columns[i], rows[i])))
 		}
 	}
 	return values
 }
 
+func (b *Builder) valueString(table *schema.Table, column *schema.TableColumn, val interface{}) string {
+	if rule := b.Masker.Rule(table, column.Name); rule != nil {
+		return b.Masker.mask(rule, val)
+	}
+	return typeConvertString(column, val)
+}
+
 func check(table *schema.Table, rows []interface{}, action string) error {
 	colLength := len(table.Columns)
 	rowLength := len(rows)
 	if colLength != rowLength {
-		return fmt.Errorf("字段不一致，跳过生成%s sql cols: %v values: %v", action, table.Columns, rows)
+		return fmt.Errorf("字段不一致，无法生成%s sql table: %s.%s cols: %d values: %d", action, table.Schema, table.Name, colLength, rowLength)
 	}
 	return nil
 }
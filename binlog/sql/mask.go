@@ -0,0 +1,155 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"strconv"
+	"strings"
+)
+
+// MaskType 脱敏方式
+type MaskType string
+
+const (
+	// MaskRedact 置为null
+	MaskRedact MaskType = "redact"
+	// MaskHash 加盐后sha256
+	MaskHash MaskType = "hash"
+	// MaskPrefix 保留前n个字符，其余用*代替
+	MaskPrefix MaskType = "prefix"
+	// MaskReplace 替换为固定值
+	MaskReplace MaskType = "replace"
+)
+
+// MaskRule 单个字段的脱敏规则
+type MaskRule struct {
+	Type        MaskType
+	Prefix      int
+	Replacement string
+}
+
+// Masker 敏感字段脱敏，规则以db.table.column为key
+type Masker struct {
+	salt  string
+	rules map[string]*MaskRule
+}
+
+// NewMasker 解析脱敏规则，规则格式：db.table.column:redact、db.table.column:hash、
+// db.table.column:prefix:3、db.table.column:replace:***
+func NewMasker(rules []string, salt string) (*Masker, error) {
+	m := &Masker{salt: salt, rules: make(map[string]*MaskRule, len(rules))}
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, ":", 3)
+		if len(parts) < 2 || strings.Count(parts[0], ".") != 2 {
+			return nil, fmt.Errorf("脱敏规则格式错误: %s", rule)
+		}
+		r := &MaskRule{Type: MaskType(strings.ToLower(parts[1]))}
+		switch r.Type {
+		case MaskRedact, MaskHash:
+		case MaskPrefix:
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("脱敏规则缺少保留长度: %s", rule)
+			}
+			n, err := strconv.Atoi(parts[2])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("脱敏规则保留长度错误: %s", rule)
+			}
+			r.Prefix = n
+		case MaskReplace:
+			if len(parts) == 3 {
+				r.Replacement = parts[2]
+			}
+		default:
+			return nil, fmt.Errorf("不支持的脱敏方式: %s", rule)
+		}
+		m.rules[strings.ToLower(parts[0])] = r
+	}
+	return m, nil
+}
+
+// Rule 获取字段的脱敏规则，没有规则时返回nil
+func (m *Masker) Rule(table *schema.Table, column string) *MaskRule {
+	if m == nil || len(m.rules) == 0 {
+		return nil
+	}
+	return m.rules[strings.ToLower(table.Schema+"."+table.Name+"."+column)]
+}
+
+// Enabled 是否有脱敏规则
+func (m *Masker) Enabled() bool {
+	return m != nil && len(m.rules) > 0
+}
+
+// MaskedColumns 表中需要脱敏的字段
+func (m *Masker) MaskedColumns(table *schema.Table) []string {
+	var cols []string
+	for i := range table.Columns {
+		if m.Rule(table, table.Columns[i].Name) != nil {
+			cols = append(cols, table.Columns[i].Name)
+		}
+	}
+	return cols
+}
+
+// MaskValue 返回脱敏后的值，redact时返回nil
+func (m *Masker) MaskValue(rule *MaskRule, val interface{}) interface{} {
+	if val == nil {
+		return nil
+	}
+	switch rule.Type {
+	case MaskHash:
+		sum := sha256.Sum256([]byte(m.salt + valueString(val)))
+		return hex.EncodeToString(sum[:])
+	case MaskPrefix:
+		runes := []rune(valueString(val))
+		if len(runes) > rule.Prefix {
+			for i := rule.Prefix; i < len(runes); i++ {
+				runes[i] = '*'
+			}
+		}
+		return string(runes)
+	case MaskReplace:
+		return rule.Replacement
+	default:
+		return nil
+	}
+}
+
+// mask 返回脱敏后的sql值
+func (m *Masker) mask(rule *MaskRule, val interface{}) string {
+	masked := m.MaskValue(rule, val)
+	if masked == nil {
+		return "null"
+	}
+	return "'" + mysql.Escape(masked.(string)) + "'"
+}
+
+func valueString(val interface{}) string {
+	switch t := val.(type) {
+	case string:
+		return t
+	case []uint8:
+		return string(t)
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
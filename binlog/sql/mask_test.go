@@ -0,0 +1,89 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"github.com/go-mysql-org/go-mysql/schema"
+	"reflect"
+	"testing"
+)
+
+func TestNewMasker(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		want    *MaskRule
+		wantErr bool
+	}{
+		{name: "redact", rule: "db.t.c:redact", want: &MaskRule{Type: MaskRedact}},
+		{name: "hash大写", rule: "db.t.c:HASH", want: &MaskRule{Type: MaskHash}},
+		{name: "prefix", rule: "db.t.c:prefix:3", want: &MaskRule{Type: MaskPrefix, Prefix: 3}},
+		{name: "replace", rule: "db.t.c:replace:***", want: &MaskRule{Type: MaskReplace, Replacement: "***"}},
+		{name: "replace包含逗号和冒号", rule: "db.t.c:replace:a,b:c", want: &MaskRule{Type: MaskReplace, Replacement: "a,b:c"}},
+		{name: "replace没有值", rule: "db.t.c:replace", want: &MaskRule{Type: MaskReplace}},
+		{name: "缺少方式", rule: "db.t.c", wantErr: true},
+		{name: "缺少库名", rule: "t.c:redact", wantErr: true},
+		{name: "prefix缺少长度", rule: "db.t.c:prefix", wantErr: true},
+		{name: "prefix长度为负数", rule: "db.t.c:prefix:-1", wantErr: true},
+		{name: "prefix长度不是数字", rule: "db.t.c:prefix:x", wantErr: true},
+		{name: "不支持的方式", rule: "db.t.c:shuffle", wantErr: true},
+	}
+	table := &schema.Table{Schema: "DB", Name: "t"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMasker([]string{tt.rule}, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewMasker(%q) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			// 库名、表名、字段名不区分大小写
+			if got := m.Rule(table, "C"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Rule() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskValue(t *testing.T) {
+	m := &Masker{salt: "s"}
+	tests := []struct {
+		name string
+		rule *MaskRule
+		val  interface{}
+		want interface{}
+	}{
+		{name: "redact", rule: &MaskRule{Type: MaskRedact}, val: "abc", want: nil},
+		{name: "null不脱敏", rule: &MaskRule{Type: MaskReplace, Replacement: "x"}, val: nil, want: nil},
+		{name: "hash加盐", rule: &MaskRule{Type: MaskHash}, val: "abc",
+			want: "cf0bbce2b0833f47b48155c56a549459af12f1724088f0246d837ec199eb787a"},
+		{name: "prefix", rule: &MaskRule{Type: MaskPrefix, Prefix: 3}, val: "13812345678", want: "138********"},
+		{name: "prefix按字符", rule: &MaskRule{Type: MaskPrefix, Prefix: 1}, val: "张三丰", want: "张**"},
+		{name: "prefix长度超过值", rule: &MaskRule{Type: MaskPrefix, Prefix: 5}, val: "abc", want: "abc"},
+		{name: "prefix数字", rule: &MaskRule{Type: MaskPrefix, Prefix: 2}, val: int64(12345), want: "12***"},
+		{name: "prefix二进制", rule: &MaskRule{Type: MaskPrefix, Prefix: 0}, val: []byte("ab"), want: "**"},
+		{name: "replace", rule: &MaskRule{Type: MaskReplace, Replacement: "***"}, val: "abc", want: "***"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.MaskValue(tt.rule, tt.val); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MaskValue(%v) = %v, want %v", tt.val, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// TableStats 表的行变更统计
+type TableStats struct {
+	Table  string `json:"table"`
+	Insert int64  `json:"insert"`
+	Update int64  `json:"update"`
+	Delete int64  `json:"delete"`
+	// Bytes 行事件的大小
+	Bytes int64 `json:"bytes"`
+}
+
+// TxStats 事务统计
+type TxStats struct {
+	File   string `json:"file"`
+	Pos    uint32 `json:"pos"`
+	EndPos uint32 `json:"end_pos"`
+	GTID   string `json:"gtid,omitempty"`
+	Rows   int64  `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	Start  uint32 `json:"start"`
+	End    uint32 `json:"end"`
+	// Duration 事务第一个事件到提交的时间，单位秒
+	Duration uint32 `json:"duration"`
+}
+
+// MinuteStats 每分钟的事件数
+type MinuteStats struct {
+	Minute string `json:"minute"`
+	Events int64  `json:"events"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// SourceStats gtid来源的事务数
+type SourceStats struct {
+	UUID         string `json:"uuid"`
+	Transactions int64  `json:"transactions"`
+}
+
+// Stats binlog统计
+type Stats struct {
+	Events       int64 `json:"events"`
+	Bytes        int64 `json:"bytes"`
+	Transactions int64 `json:"transactions"`
+
+	Tables          []*TableStats  `json:"tables"`
+	LargestByRows   []*TxStats     `json:"largest_by_rows"`
+	LargestBySize   []*TxStats     `json:"largest_by_size"`
+	Longest         []*TxStats     `json:"longest"`
+	EventsPerMinute []*MinuteStats `json:"events_per_minute"`
+	GTIDSources     []*SourceStats `json:"gtid_sources"`
+}
+
+// statsCollector 统计原始binlog事件
+type statsCollector struct {
+	filter *tableFilter
+	top    int
+
+	stats   Stats
+	tables  map[string]*TableStats
+	minutes map[int64]*MinuteStats
+	sources map[string]*SourceStats
+	byRows  topTx
+	bySize  topTx
+	longest topTx
+
+	gtid string
+	// gtidPos gtid事件的位置，事务从gtid事件开始
+	gtidPos uint32
+	tx      *TxStats
+}
+
+// CollectStats 统计解析范围内的binlog，top为排行输出的事务数。
+// 表过滤条件只作用于表统计和事务排行，事务排行只包含有满足过滤条件的行变更的事务
+func CollectStats(ctx context.Context, config *config.BinlogConfig, top int) (*Stats, error) {
+	filter, err := newTableFilter(config)
+	if err != nil {
+		return nil, err
+	}
+	c := newStatsCollector(filter, top)
+	if err := eachEvent(ctx, config, c.collect); err != nil {
+		return nil, err
+	}
+	return c.result(), nil
+}
+
+func newStatsCollector(filter *tableFilter, top int) *statsCollector {
+	c := &statsCollector{
+		filter:  filter,
+		top:     top,
+		tables:  make(map[string]*TableStats),
+		minutes: make(map[int64]*MinuteStats),
+		sources: make(map[string]*SourceStats),
+	}
+	c.byRows.list, c.bySize.list, c.longest.list = []*TxStats{}, []*TxStats{}, []*TxStats{}
+	c.byRows.less = func(a, b *TxStats) bool { return a.Rows > b.Rows }
+	c.bySize.less = func(a, b *TxStats) bool { return a.Bytes > b.Bytes }
+	c.longest.less = func(a, b *TxStats) bool { return a.Duration > b.Duration }
+	return c
+}
+
+func (c *statsCollector) collect(file string, ev *replication.BinlogEvent) error {
+	header := ev.Header
+	size := int64(header.EventSize)
+	c.stats.Events++
+	c.stats.Bytes += size
+
+	minute := int64(header.Timestamp) / 60
+	m, ok := c.minutes[minute]
+	if !ok {
+		m = &MinuteStats{Minute: time.Unix(minute*60, 0).Format("2006-01-02 15:04")}
+		c.minutes[minute] = m
+	}
+	m.Events++
+	m.Bytes += size
+
+	if c.tx != nil {
+		c.tx.Bytes += size
+	}
+	switch e := ev.Event.(type) {
+	case *replication.GTIDEvent, *replication.MariadbGTIDEvent:
+		c.gtid, _ = gtidString(ev)
+		c.gtidPos = header.LogPos - header.EventSize
+		if c.gtid != "" {
+			c.source(c.gtid)
+		}
+		// mariadb的gtid事件开始事务，非standalone的事件组没有BEGIN语句
+		if e, ok := e.(*replication.MariadbGTIDEvent); ok && !e.IsStandalone() {
+			c.tx = &TxStats{File: file, Pos: c.gtidPos, GTID: c.gtid, Bytes: size, Start: header.Timestamp}
+		}
+	case *replication.QueryEvent:
+		query := string(e.Query)
+		if strings.EqualFold(query, "BEGIN") {
+			if c.tx != nil && c.gtid != "" && c.tx.GTID == c.gtid {
+				// 已经由mariadb的gtid事件开始
+				return nil
+			}
+			pos := header.LogPos - header.EventSize
+			if c.gtid != "" {
+				pos = c.gtidPos
+			}
+			// 事务大小包含gtid事件
+			c.tx = &TxStats{File: file, Pos: pos, GTID: c.gtid, Bytes: int64(header.LogPos - pos), Start: header.Timestamp}
+		} else if strings.EqualFold(query, "COMMIT") {
+			c.commit(header)
+		} else {
+			c.gtid = ""
+		}
+	case *replication.XIDEvent:
+		c.commit(header)
+	case *replication.RowsEvent:
+		if !c.filter.Rows(ev) {
+			return nil
+		}
+		table := string(e.Table.Schema) + "." + string(e.Table.Table)
+		t, ok := c.tables[table]
+		if !ok {
+			t = &TableStats{Table: table}
+			c.tables[table] = t
+		}
+		rows := int64(rowCount(ev))
+		switch rowsAction(header.EventType) {
+		case canal.InsertAction:
+			t.Insert += rows
+		case canal.UpdateAction:
+			t.Update += rows
+		case canal.DeleteAction:
+			t.Delete += rows
+		}
+		t.Bytes += size
+		if c.tx != nil {
+			c.tx.Rows += rows
+		}
+	}
+	return nil
+}
+
+func (c *statsCollector) commit(header *replication.EventHeader) {
+	c.gtid = ""
+	tx := c.tx
+	if tx == nil {
+		return
+	}
+	c.tx = nil
+	c.stats.Transactions++
+	if tx.Rows == 0 {
+		return
+	}
+	tx.EndPos = header.LogPos
+	tx.End = header.Timestamp
+	if tx.End > tx.Start {
+		tx.Duration = tx.End - tx.Start
+	}
+	c.byRows.add(tx, c.top)
+	c.bySize.add(tx, c.top)
+	c.longest.add(tx, c.top)
+}
+
+// source 按gtid的uuid统计事务数，mariadb的gtid按domain id-server id统计
+func (c *statsCollector) source(gtid string) {
+	uuid := gtid
+	if i := strings.Index(gtid, ":"); i > 0 {
+		uuid = gtid[:i]
+	} else if i := strings.LastIndex(gtid, "-"); i > 0 {
+		uuid = gtid[:i]
+	}
+	s, ok := c.sources[uuid]
+	if !ok {
+		s = &SourceStats{UUID: uuid}
+		c.sources[uuid] = s
+	}
+	s.Transactions++
+}
+
+func (c *statsCollector) result() *Stats {
+	stats := c.stats
+	stats.Tables = make([]*TableStats, 0, len(c.tables))
+	for _, t := range c.tables {
+		stats.Tables = append(stats.Tables, t)
+	}
+	sort.Slice(stats.Tables, func(i, j int) bool {
+		a, b := stats.Tables[i], stats.Tables[j]
+		if a.Insert+a.Update+a.Delete != b.Insert+b.Update+b.Delete {
+			return a.Insert+a.Update+a.Delete > b.Insert+b.Update+b.Delete
+		}
+		return a.Table < b.Table
+	})
+	stats.EventsPerMinute = make([]*MinuteStats, 0, len(c.minutes))
+	minutes := make([]int64, 0, len(c.minutes))
+	for minute := range c.minutes {
+		minutes = append(minutes, minute)
+	}
+	sort.Slice(minutes, func(i, j int) bool { return minutes[i] < minutes[j] })
+	for _, minute := range minutes {
+		stats.EventsPerMinute = append(stats.EventsPerMinute, c.minutes[minute])
+	}
+	stats.GTIDSources = make([]*SourceStats, 0, len(c.sources))
+	for _, s := range c.sources {
+		stats.GTIDSources = append(stats.GTIDSources, s)
+	}
+	sort.Slice(stats.GTIDSources, func(i, j int) bool {
+		return stats.GTIDSources[i].Transactions > stats.GTIDSources[j].Transactions
+	})
+	if len(stats.GTIDSources) > c.top {
+		stats.GTIDSources = stats.GTIDSources[:c.top]
+	}
+	stats.LargestByRows = c.byRows.list
+	stats.LargestBySize = c.bySize.list
+	stats.Longest = c.longest.list
+	return &stats
+}
+
+// topTx 保留排名前n的事务
+type topTx struct {
+	less func(a, b *TxStats) bool
+	list []*TxStats
+}
+
+func (t *topTx) add(tx *TxStats, n int) {
+	i := sort.Search(len(t.list), func(i int) bool { return t.less(tx, t.list[i]) })
+	if i >= n {
+		return
+	}
+	t.list = append(t.list, nil)
+	copy(t.list[i+1:], t.list[i:])
+	t.list[i] = tx
+	if len(t.list) > n {
+		t.list = t.list[:n]
+	}
+}
+
+// WriteStats 按format输出统计结果
+func WriteStats(out io.Writer, stats *Stats, format string) error {
+	switch format {
+	case FormatJson:
+		encoder := json.NewEncoder(out)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	case FormatTable:
+	default:
+		return fmt.Errorf("不支持的输出格式: %s，支持%s,%s", format, FormatTable, FormatJson)
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "事件数: %d  大小: %d  事务数: %d\n", stats.Events, stats.Bytes, stats.Transactions)
+
+	_, _ = fmt.Fprintln(w, "\n# 表")
+	_, _ = fmt.Fprintln(w, "TABLE\tINSERT\tUPDATE\tDELETE\tBYTES")
+	for _, t := range stats.Tables {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", t.Table, t.Insert, t.Update, t.Delete, t.Bytes)
+	}
+
+	txs := []struct {
+		title string
+		list  []*TxStats
+	}{
+		{"行数最多的事务", stats.LargestByRows},
+		{"大小最大的事务", stats.LargestBySize},
+		{"耗时最长的事务", stats.Longest},
+	}
+	for _, t := range txs {
+		_, _ = fmt.Fprintf(w, "\n# %s\n", t.title)
+		_, _ = fmt.Fprintln(w, "FILE\tPOS\tEND_POS\tGTID\tROWS\tBYTES\tSTART\tDURATION")
+		for _, tx := range t.list {
+			_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%d\t%s\t%ds\n", tx.File, tx.Pos, tx.EndPos, tx.GTID, tx.Rows, tx.Bytes,
+				time.Unix(int64(tx.Start), 0).Format("2006-01-02 15:04:05"), tx.Duration)
+		}
+	}
+
+	_, _ = fmt.Fprintln(w, "\n# 每分钟事件数")
+	_, _ = fmt.Fprintln(w, "MINUTE\tEVENTS\tBYTES")
+	for _, m := range stats.EventsPerMinute {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\n", m.Minute, m.Events, m.Bytes)
+	}
+
+	_, _ = fmt.Fprintln(w, "\n# gtid来源")
+	_, _ = fmt.Fprintln(w, "UUID\tTRANSACTIONS")
+	for _, s := range stats.GTIDSources {
+		_, _ = fmt.Fprintf(w, "%s\t%d\n", s.UUID, s.Transactions)
+	}
+	return w.Flush()
+}
+
+// Statistics 统计解析范围内的binlog并按config.Format输出
+func Statistics(ctx context.Context, config *config.BinlogConfig, top int) error {
+	if config.Format != FormatTable && config.Format != FormatJson {
+		return fmt.Errorf("不支持的输出格式: %s，支持%s,%s", config.Format, FormatTable, FormatJson)
+	}
+	stats, err := CollectStats(ctx, config, top)
+	if err != nil {
+		return err
+	}
+	out, err := config.GetOut()
+	if err != nil {
+		return err
+	}
+	err = WriteStats(out, stats, config.Format)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
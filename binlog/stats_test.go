@@ -0,0 +1,218 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/google/uuid"
+	"reflect"
+	"testing"
+)
+
+// statsEvents 依次生成事件，每个事件的位置紧接上一个事件
+type statsEvents struct {
+	pos    uint32
+	events []*replication.BinlogEvent
+}
+
+func (s *statsEvents) add(eventType replication.EventType, timestamp uint32, size uint32, e replication.Event) {
+	s.pos += size
+	header := &replication.EventHeader{EventType: eventType, Timestamp: timestamp, LogPos: s.pos, EventSize: size}
+	s.events = append(s.events, &replication.BinlogEvent{Header: header, Event: e})
+}
+
+func (s *statsEvents) gtid(timestamp uint32, sid string, gno int64) {
+	u := uuid.MustParse(sid)
+	s.add(replication.GTID_EVENT, timestamp, 10, &replication.GTIDEvent{SID: u[:], GNO: gno})
+}
+
+func (s *statsEvents) mariadbGTID(timestamp uint32, seq uint64, flags byte) {
+	e := &replication.MariadbGTIDEvent{GTID: mysql.MariadbGTID{DomainID: 0, ServerID: 1, SequenceNumber: seq}, Flags: flags}
+	s.add(replication.MARIADB_GTID_EVENT, timestamp, 10, e)
+}
+
+func (s *statsEvents) query(timestamp uint32, query string) {
+	s.add(replication.QUERY_EVENT, timestamp, 10, &replication.QueryEvent{Query: []byte(query)})
+}
+
+func (s *statsEvents) rows(eventType replication.EventType, timestamp uint32, size uint32, table string, rows int) {
+	e := &replication.RowsEvent{Table: &replication.TableMapEvent{Schema: []byte("test"), Table: []byte(table)}, Rows: make([][]interface{}, rows)}
+	s.add(eventType, timestamp, size, e)
+}
+
+func (s *statsEvents) xid(timestamp uint32) {
+	s.add(replication.XID_EVENT, timestamp, 10, &replication.XIDEvent{})
+}
+
+func TestStatsCollector(t *testing.T) {
+	const a = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	const b = "4e11fa47-71ca-11e1-9e33-c80aa9429562"
+	s := &statsEvents{}
+	// tx1: 3行insert，耗时5s
+	s.gtid(60, a, 1)
+	s.query(60, "BEGIN")
+	s.rows(replication.WRITE_ROWS_EVENTv2, 60, 100, "user", 3)
+	s.xid(65)
+	// tx2: 2行update和过滤掉的1行delete，耗时70s
+	s.gtid(130, a, 2)
+	s.query(130, "BEGIN")
+	s.rows(replication.UPDATE_ROWS_EVENTv2, 130, 300, "user", 4)
+	s.rows(replication.DELETE_ROWS_EVENTv2, 150, 50, "log", 1)
+	s.xid(200)
+	// ddl
+	s.gtid(200, b, 1)
+	s.query(200, "CREATE TABLE t (id int)")
+	// 没有行变更的事务
+	s.query(210, "BEGIN")
+	s.xid(210)
+	// tx4: 没有gtid，以COMMIT结束的事务
+	s.query(220, "BEGIN")
+	s.rows(replication.DELETE_ROWS_EVENTv2, 220, 20, "user", 5)
+	s.query(221, "COMMIT")
+
+	filter, err := newTableFilter(&config.BinlogConfig{Tables: []string{"user"}, SqlTypes: []string{canal.InsertAction, canal.UpdateAction, canal.DeleteAction}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newStatsCollector(filter, 2)
+	for _, ev := range s.events {
+		if err := c.collect("mysql-bin.000001", ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := c.result()
+
+	if stats.Events != int64(len(s.events)) || stats.Bytes != int64(s.pos) || stats.Transactions != 4 {
+		t.Errorf("events = %d, bytes = %d, transactions = %d", stats.Events, stats.Bytes, stats.Transactions)
+	}
+	wantTables := []*TableStats{{Table: "test.user", Insert: 3, Update: 2, Delete: 5, Bytes: 420}}
+	if !reflect.DeepEqual(stats.Tables, wantTables) {
+		t.Errorf("Tables = %+v, want %+v", stats.Tables[0], wantTables[0])
+	}
+
+	tx1 := &TxStats{File: "mysql-bin.000001", Pos: 0, EndPos: 130, GTID: a + ":1", Rows: 3, Bytes: 130, Start: 60, End: 65, Duration: 5}
+	tx2 := &TxStats{File: "mysql-bin.000001", Pos: 130, EndPos: 510, GTID: a + ":2", Rows: 2, Bytes: 380, Start: 130, End: 200, Duration: 70}
+	tx4 := &TxStats{File: "mysql-bin.000001", Pos: 550, EndPos: 590, Rows: 5, Bytes: 40, Start: 220, End: 221, Duration: 1}
+	tests := []struct {
+		name string
+		got  []*TxStats
+		want []*TxStats
+	}{
+		{name: "行数", got: stats.LargestByRows, want: []*TxStats{tx4, tx1}},
+		{name: "大小", got: stats.LargestBySize, want: []*TxStats{tx2, tx1}},
+		{name: "耗时", got: stats.Longest, want: []*TxStats{tx2, tx1}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s排行:", tt.name)
+			for _, tx := range tt.got {
+				t.Errorf("  got %+v", tx)
+			}
+			for _, tx := range tt.want {
+				t.Errorf("  want %+v", tx)
+			}
+		}
+	}
+
+	var minutes []int64
+	for _, m := range stats.EventsPerMinute {
+		minutes = append(minutes, m.Events)
+	}
+	// 60-119: tx1的4个事件；120-179: tx2的前4个事件；180-239: 其余事件
+	if !reflect.DeepEqual(minutes, []int64{4, 4, 8}) {
+		t.Errorf("EventsPerMinute = %v", minutes)
+	}
+	wantSources := []*SourceStats{{UUID: a, Transactions: 2}, {UUID: b, Transactions: 1}}
+	if !reflect.DeepEqual(stats.GTIDSources, wantSources) {
+		t.Errorf("GTIDSources = %+v %+v", stats.GTIDSources[0], stats.GTIDSources[1])
+	}
+}
+
+// TestStatsCollectorMariadb mariadb的事务由gtid事件开始，没有BEGIN语句
+func TestStatsCollectorMariadb(t *testing.T) {
+	s := &statsEvents{}
+	// 事务: 2行insert，耗时3s
+	s.mariadbGTID(60, 1, replication.BINLOG_MARIADB_FL_TRANSACTIONAL)
+	s.rows(replication.WRITE_ROWS_EVENTv1, 61, 100, "user", 2)
+	s.xid(63)
+	// ddl
+	s.mariadbGTID(70, 2, replication.BINLOG_MARIADB_FL_STANDALONE|replication.BINLOG_MARIADB_FL_DDL)
+	s.query(70, "CREATE TABLE t (id int)")
+	// 非事务表，gtid事件之后有BEGIN，以COMMIT结束
+	s.mariadbGTID(80, 3, 0)
+	s.query(80, "BEGIN")
+	s.rows(replication.DELETE_ROWS_EVENTv1, 80, 20, "user", 5)
+	s.query(81, "COMMIT")
+
+	filter, err := newTableFilter(&config.BinlogConfig{SqlTypes: []string{canal.InsertAction, canal.UpdateAction, canal.DeleteAction}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newStatsCollector(filter, 10)
+	for _, ev := range s.events {
+		if err := c.collect("mysql-bin.000001", ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := c.result()
+	if stats.Transactions != 2 {
+		t.Errorf("transactions = %d, want 2", stats.Transactions)
+	}
+	tx1 := &TxStats{File: "mysql-bin.000001", Pos: 0, EndPos: 120, GTID: "0-1-1", Rows: 2, Bytes: 120, Start: 60, End: 63, Duration: 3}
+	tx2 := &TxStats{File: "mysql-bin.000001", Pos: 140, EndPos: 190, GTID: "0-1-3", Rows: 5, Bytes: 50, Start: 80, End: 81, Duration: 1}
+	if want := []*TxStats{tx2, tx1}; !reflect.DeepEqual(stats.LargestByRows, want) {
+		t.Errorf("LargestByRows = %+v %+v", stats.LargestByRows[0], stats.LargestByRows[len(stats.LargestByRows)-1])
+	}
+	if want := []*TxStats{tx1, tx2}; !reflect.DeepEqual(stats.Longest, want) {
+		t.Errorf("Longest = %+v", stats.Longest)
+	}
+	if want := []*SourceStats{{UUID: "0-1", Transactions: 3}}; !reflect.DeepEqual(stats.GTIDSources, want) {
+		t.Errorf("GTIDSources = %+v", stats.GTIDSources)
+	}
+}
+
+func TestStatsSource(t *testing.T) {
+	c := newStatsCollector(nil, 10)
+	for _, gtid := range []string{"3e11fa47-71ca-11e1-9e33-c80aa9429562:1", "3e11fa47-71ca-11e1-9e33-c80aa9429562:2", "0-1-5", "0-1-6", "1-2-3"} {
+		c.source(gtid)
+	}
+	got := make(map[string]int64)
+	for uuid, s := range c.sources {
+		got[uuid] = s.Transactions
+	}
+	want := map[string]int64{"3e11fa47-71ca-11e1-9e33-c80aa9429562": 2, "0-1": 2, "1-2": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sources = %v, want %v", got, want)
+	}
+}
+
+func TestTopTx(t *testing.T) {
+	top := &topTx{less: func(a, b *TxStats) bool { return a.Rows > b.Rows }}
+	for _, rows := range []int64{3, 1, 5, 3, 4} {
+		top.add(&TxStats{Rows: rows, Pos: uint32(len(top.list))}, 3)
+	}
+	var got []int64
+	for _, tx := range top.list {
+		got = append(got, tx.Rows)
+	}
+	if !reflect.DeepEqual(got, []int64{5, 4, 3}) {
+		t.Errorf("top = %v", got)
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package binlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dhbin/ra/binlog/parse"
+	"github.com/dhbin/ra/config"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/google/uuid"
+	"path/filepath"
+	"regexp"
+)
+
+// errStop 到达终止位置
+var errStop = errors.New("stop")
+
+// eventFunc 处理原始binlog事件，file为事件所在的binlog文件
+type eventFunc func(file string, ev *replication.BinlogEvent) error
+
+// eachEvent 依次读取解析范围内的原始binlog事件，不需要表结构。
+// 解析远程binlog且没有指定终止位置、终止时间时，读取到开始时数据库的最新位置为止
+func eachEvent(ctx context.Context, config *config.BinlogConfig, fn eventFunc) error {
+	r := &eventRange{config: config, progress: newProgress(config, false)}
+	defer r.progress.Close()
+	if config.Local {
+		return r.local(ctx, fn)
+	}
+	return r.remote(ctx, fn)
+}
+
+type eventRange struct {
+	config *config.BinlogConfig
+	// 远程解析的终止位置
+	stopFile string
+	stopPos  uint32
+	// progress 为nil时不输出进度
+	progress *progress
+}
+
+func (r *eventRange) local(ctx context.Context, fn eventFunc) error {
+	files, err := parse.LocalFiles(r.config.StartBinlogName, r.config.StopBinlogName)
+	if err != nil {
+		return err
+	}
+	flavor, err := parse.Flavor(r.config)
+	if err != nil {
+		return err
+	}
+	r.stopFile = filepath.Base(r.config.StopBinlogName)
+	r.stopPos = r.config.StopPosition
+	decoder := &parse.Decoder{Files: files, Offset: int64(r.config.StartPosition), Workers: r.config.Parallel, Flavor: flavor}
+	err = decoder.Run(ctx, func(path string, ev *replication.BinlogEvent) error {
+		return r.handle(filepath.Base(path), ev, fn)
+	})
+	if err == errStop {
+		return nil
+	}
+	return err
+}
+
+func (r *eventRange) remote(ctx context.Context, fn eventFunc) error {
+	r.stopFile = r.config.StopBinlogName
+	r.stopPos = r.config.StopPosition
+	if r.stopPos == 0 && r.config.StopDatetime == nil {
+		if err := r.masterPosition(); err != nil {
+			return err
+		}
+	}
+
+	syncer, err := parse.NewSyncer(r.config)
+	if err != nil {
+		return err
+	}
+	defer syncer.Close()
+	streamer, err := syncer.StartSync(mysql.Position{Name: r.config.StartBinlogName, Pos: r.config.StartPosition})
+	if err != nil {
+		return err
+	}
+	file := r.config.StartBinlogName
+	for {
+		ev, err := streamer.GetEvent(ctx)
+		if err != nil {
+			return err
+		}
+		if rotate, ok := ev.Event.(*replication.RotateEvent); ok && ev.Header.LogPos == 0 {
+			// 切换文件时服务端发送的rotate事件，不在binlog文件中
+			file = string(rotate.NextLogName)
+			if mysql.CompareBinlogFileName(file, r.stopFile) > 0 {
+				return nil
+			}
+			continue
+		}
+		if err := r.handle(file, ev, fn); err != nil {
+			if err == errStop {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// masterPosition 没有指定终止位置时，读取到数据库当前的位置为止
+func (r *eventRange) masterPosition() error {
+	conn, err := connect(r.config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	result, err := conn.Execute("SHOW MASTER STATUS")
+	if err != nil {
+		// 8.4以后改为SHOW BINARY LOG STATUS
+		result, err = conn.Execute("SHOW BINARY LOG STATUS")
+		if err != nil {
+			return err
+		}
+	}
+	if result.RowNumber() == 0 {
+		return fmt.Errorf("数据库没有开启binlog")
+	}
+	file, _ := result.GetString(0, 0)
+	pos, _ := result.GetUint(0, 1)
+	if file == r.stopFile {
+		r.stopPos = uint32(pos)
+	}
+	return nil
+}
+
+// handle 过滤解析范围外的事件，到达终止位置时返回errStop
+func (r *eventRange) handle(file string, ev *replication.BinlogEvent, fn eventFunc) error {
+	header := ev.Header
+	r.progress.event(file, header)
+	if header.LogPos == 0 {
+		// 服务端生成的事件，不在binlog文件中
+		return nil
+	}
+	if r.config.StopDatetime != nil && int64(header.Timestamp) >= r.config.StopDatetime.Unix() {
+		return errStop
+	}
+	if file == r.stopFile && r.stopPos != 0 && header.LogPos > r.stopPos {
+		return errStop
+	}
+	if header.LogPos-header.EventSize < r.config.StartPosition && file == filepath.Base(r.config.StartBinlogName) {
+		// 读取起始位置之后的事件前，会先读取format description事件
+		return nil
+	}
+	if r.config.StartDatetime != nil && int64(header.Timestamp) < r.config.StartDatetime.Unix() {
+		return nil
+	}
+	if err := fn(file, ev); err != nil {
+		return err
+	}
+	if file == r.stopFile && r.stopPos != 0 && header.LogPos >= r.stopPos {
+		return errStop
+	}
+	return nil
+}
+
+// gtidString 事件的gtid，匿名事务为空
+func gtidString(ev *replication.BinlogEvent) (string, bool) {
+	switch e := ev.Event.(type) {
+	case *replication.GTIDEvent:
+		if e.GNO == 0 {
+			return "", true
+		}
+		u, _ := uuid.FromBytes(e.SID)
+		return fmt.Sprintf("%s:%d", u.String(), e.GNO), true
+	case *replication.MariadbGTIDEvent:
+		return e.GTID.String(), true
+	}
+	return "", false
+}
+
+// tableFilter 与tosql相同的表过滤条件
+type tableFilter struct {
+	regex []*regexp.Regexp
+	cfg   *config.BinlogConfig
+}
+
+func newTableFilter(config *config.BinlogConfig) (*tableFilter, error) {
+	f := &tableFilter{cfg: config}
+	for _, expr := range parse.IncludeTableRegex(config) {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("表过滤条件格式错误: %w", err)
+		}
+		f.regex = append(f.regex, re)
+	}
+	return f, nil
+}
+
+// Table 是否解析该表
+func (f *tableFilter) Table(schema string, table string) bool {
+	if len(f.regex) == 0 {
+		return true
+	}
+	key := schema + "." + table
+	for _, re := range f.regex {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rows 是否解析该行事件
+func (f *tableFilter) Rows(ev *replication.BinlogEvent) bool {
+	e := ev.Event.(*replication.RowsEvent)
+	if e.Table == nil || !f.Table(string(e.Table.Schema), string(e.Table.Table)) {
+		return false
+	}
+	return f.cfg.SupportSqlType(rowsAction(ev.Header.EventType))
+}
+
+// rowsAction 行事件对应的变更类型
+func rowsAction(eventType replication.EventType) string {
+	switch eventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		return canal.InsertAction
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		return canal.UpdateAction
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		return canal.DeleteAction
+	}
+	return ""
+}
+
+// rowCount 行事件的行数，update的before、after算一行
+func rowCount(ev *replication.BinlogEvent) int {
+	e := ev.Event.(*replication.RowsEvent)
+	if rowsAction(ev.Header.EventType) == canal.UpdateAction {
+		return len(e.Rows) / 2
+	}
+	return len(e.Rows)
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/dhbin/ra/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"os"
+	"strings"
+)
+
+// loadConfig 命令行没有指定的参数依次从环境变量、配置文件中读取
+func loadConfig(cmd *cobra.Command) error {
+	path, _ := cmd.Flags().GetString("config")
+	if path == "" {
+		path = os.Getenv(config.EnvName("config"))
+	}
+	missingOk := false
+	if path == "" {
+		path = config.DefaultFile()
+		missingOk = true
+	}
+	file := &config.File{}
+	if path != "" {
+		var err error
+		file, err = config.LoadFile(path, missingOk)
+		if err != nil {
+			return err
+		}
+		if err := checkConfigKeys(file, path); err != nil {
+			return err
+		}
+	}
+	name, _ := cmd.Flags().GetString("profile")
+	if name == "" {
+		name = os.Getenv(config.EnvName("profile"))
+	}
+	values, err := file.Values(name, cmd.Name())
+	if err != nil {
+		return err
+	}
+
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		switch f.Name {
+		case "config", "profile", "help", "version":
+			return
+		}
+		var items []string
+		value, ok := os.LookupEnv(config.EnvName(f.Name))
+		source := "环境变量" + config.EnvName(f.Name)
+		if ok {
+			items = []string{value}
+		} else {
+			items, ok = values[f.Name]
+			source = "配置文件" + path + "中的" + f.Name
+		}
+		if !ok {
+			return
+		}
+		switch f.Value.Type() {
+		case "stringArray":
+		case "stringSlice":
+			// pflag按csv拆分，包含逗号、引号的项需要转义
+			items = []string{csvJoin(items)}
+		default:
+			// 逗号分隔的列表参数由pflag拆分
+			items = []string{strings.Join(items, ",")}
+		}
+		for _, item := range items {
+			if setErr := cmd.Flags().Set(f.Name, item); setErr != nil {
+				err = fmt.Errorf("%s错误: %w", source, setErr)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// csvJoin 按csv格式拼接列表
+func csvJoin(items []string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	_ = w.Write(items)
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// checkConfigKeys 配置文件中的参数必须是某个命令的参数，命令中的参数必须是该命令的参数
+func checkConfigKeys(file *config.File, path string) error {
+	for _, key := range file.Keys() {
+		name, flag, ok := strings.Cut(key, ".")
+		if !ok {
+			flag = key
+			name = ""
+		}
+		found := false
+		for _, c := range rootCmd.Commands() {
+			if name != "" && c.Name() != name {
+				continue
+			}
+			if c.LocalFlags().Lookup(flag) != nil || c.InheritedFlags().Lookup(flag) != nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("配置文件%s中的参数%s不存在", path, key)
+		}
+	}
+	return nil
+}
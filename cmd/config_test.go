@@ -0,0 +1,172 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfigFile = `
+profile: prod
+defaults:
+  port: 3307
+  host: 127.0.0.1
+  username: root
+  tables: [a]
+profiles:
+  prod:
+    host: 10.0.0.1
+    username: ra
+    format: csv
+    tables: [user, order]
+    mask:
+      - test.user.phone:prefix:3
+      - test.user.name:replace:a,b
+    tosql:
+      format: jsonl
+`
+
+// newConfigCommand 与tosql同名、参数相同的命令，避免修改rootCmd中参数的值
+func newConfigCommand(t *testing.T, text string, args ...string) *cobra.Command {
+	path := filepath.Join(t.TempDir(), "ra.yaml")
+	if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &cobra.Command{Use: "tosql"}
+	cmd.Flags().String("config", "", "")
+	cmd.Flags().String("profile", "", "")
+	cmd.Flags().String("host", "127.0.0.1", "")
+	cmd.Flags().Int("port", 3306, "")
+	cmd.Flags().String("username", "", "")
+	cmd.Flags().String("password", "", "")
+	cmd.Flags().String("format", "sql", "")
+	cmd.Flags().String("flavor", "mysql", "")
+	cmd.Flags().StringSlice("tables", []string{}, "")
+	cmd.Flags().StringArray("mask", []string{}, "")
+	if err := cmd.ParseFlags(append([]string{"--config", path}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("RA_HOST", "env")
+	t.Setenv("RA_USERNAME", "env")
+	cmd := newConfigCommand(t, testConfigFile, "--host", "flag")
+	if err := loadConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		flag string
+		want string
+	}{
+		{name: "命令行参数优先于环境变量", flag: "host", want: "flag"},
+		{name: "环境变量优先于配置文件", flag: "username", want: "env"},
+		{name: "profile中命令的参数优先于profile", flag: "format", want: "jsonl"},
+		{name: "profile优先于defaults", flag: "tables", want: "[user,order]"},
+		{name: "defaults优先于参数默认值", flag: "port", want: "3307"},
+		{name: "没有配置时为参数默认值", flag: "flavor", want: "mysql"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cmd.Flags().Lookup(tt.flag).Value.String(); got != tt.want {
+				t.Errorf("%s = %s, want %s", tt.flag, got, tt.want)
+			}
+		})
+	}
+	tables, _ := cmd.Flags().GetStringSlice("tables")
+	if want := []string{"user", "order"}; !reflect.DeepEqual(tables, want) {
+		t.Errorf("tables = %q, want %q", tables, want)
+	}
+	mask, _ := cmd.Flags().GetStringArray("mask")
+	if want := []string{"test.user.phone:prefix:3", "test.user.name:replace:a,b"}; !reflect.DeepEqual(mask, want) {
+		t.Errorf("mask = %q, want %q", mask, want)
+	}
+}
+
+func TestLoadConfigProfile(t *testing.T) {
+	text := testConfigFile + "  dev:\n    host: 10.0.0.2\n"
+	t.Run("命令行指定profile", func(t *testing.T) {
+		t.Setenv("RA_PROFILE", "prod")
+		cmd := newConfigCommand(t, text, "--profile", "dev")
+		if err := loadConfig(cmd); err != nil {
+			t.Fatal(err)
+		}
+		if host, _ := cmd.Flags().GetString("host"); host != "10.0.0.2" {
+			t.Errorf("host = %s, want 10.0.0.2", host)
+		}
+		if format, _ := cmd.Flags().GetString("format"); format != "sql" {
+			t.Errorf("format = %s, want sql", format)
+		}
+	})
+	t.Run("环境变量指定profile", func(t *testing.T) {
+		t.Setenv("RA_PROFILE", "dev")
+		cmd := newConfigCommand(t, text)
+		if err := loadConfig(cmd); err != nil {
+			t.Fatal(err)
+		}
+		if tables, _ := cmd.Flags().GetStringSlice("tables"); !reflect.DeepEqual(tables, []string{"a"}) {
+			t.Errorf("tables = %v, want [a]", tables)
+		}
+	})
+}
+
+func TestLoadConfigList(t *testing.T) {
+	cmd := newConfigCommand(t, "defaults:\n  tables: [\"a,b\", 'c\"d', e]\n  mask: []\n")
+	if err := loadConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if tables, _ := cmd.Flags().GetStringSlice("tables"); !reflect.DeepEqual(tables, []string{"a,b", "c\"d", "e"}) {
+		t.Errorf("tables = %q, 列表中的每一项不应被拆分", tables)
+	}
+	if mask, _ := cmd.Flags().GetStringArray("mask"); len(mask) != 0 {
+		t.Errorf("mask = %q, want []", mask)
+	}
+}
+
+func TestLoadConfigError(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		env  string
+		want string
+	}{
+		{name: "未知的参数", text: "defaults:\n  hots: db\n", want: "参数hots不存在"},
+		{name: "命令中未知的参数", text: "defaults:\n  tosql:\n    top: 5\n", want: "参数tosql.top不存在"},
+		{name: "不存在的命令", text: "defaults:\n  foo:\n    host: db\n", want: "参数foo.host不存在"},
+		{name: "profile不存在", text: "profile: dev\n", want: "没有profile: dev"},
+		{name: "配置文件中的值错误", text: "defaults:\n  port: abc\n", want: "中的port错误"},
+		{name: "环境变量的值错误", env: "abc", want: "环境变量RA_PORT错误"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("RA_PORT", tt.env)
+			}
+			err := loadConfig(newConfigCommand(t, tt.text))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig() = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dhbin/ra/config"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"os"
+)
+
+// promptPassword -p没有值时设置的密码，执行前交互输入
+const promptPassword = "\x00"
+
+// passwordRequired 命令的annotation，连接参数读取完成后必须有密码
+const passwordRequired = "ra_password_required"
+
+// mysqlOptions mysql选项文件中的选项对应的参数
+var mysqlOptions = map[string]string{
+	"user":     "username",
+	"password": "password",
+	"host":     "host",
+	"port":     "port",
+	"socket":   "socket",
+	"ssl-mode": "ssl-mode",
+	"ssl-ca":   "ssl-ca",
+	"ssl-cert": "ssl-cert",
+	"ssl-key":  "ssl-key",
+}
+
+// normalizePasswordArgs 与mysql客户端一致，密码只能写成-p123456或--password=123456，
+// -p、--password后面没有值时交互输入密码，不会把下一个参数作为密码
+func normalizePasswordArgs(args []string) []string {
+	result := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(result, args[i:]...)
+		}
+		if arg == "-p" || arg == "--password" {
+			arg = "--password=" + promptPassword
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// noArgs 命令不接受位置参数。-p 123456中的123456会作为位置参数，提示密码的写法
+func noArgs(cmd *cobra.Command, args []string) error {
+	if err := cobra.NoArgs(cmd, args); err != nil {
+		return fmt.Errorf("%w，密码需要写成-p123456或--password=123456", err)
+	}
+	return nil
+}
+
+// requirePassword 命令需要密码，在读取环境变量、配置文件、选项文件之后校验
+func requirePassword(cmd *cobra.Command) {
+	if cmd.Annotations == nil {
+		cmd.Annotations = map[string]string{}
+	}
+	cmd.Annotations[passwordRequired] = "true"
+}
+
+// loadCredentials 命令行、环境变量、配置文件都没有指定的连接参数，
+// 依次从--login-path、mysql选项文件、MYSQL_PWD中读取，-p没有值时交互输入密码
+func loadCredentials(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	if flags.Lookup("password") == nil {
+		return nil
+	}
+	loginPath := flagValue(cmd, "login-path")
+	defaultsFile := flagValue(cmd, "defaults-file")
+	var sources []map[string]string
+	// 与mysql客户端一致，没有指定login-path时也读取登录文件中的[client]
+	if file := config.DefaultLoginPathFile(); file != "" {
+		options, err := config.ReadLoginPath(file, loginPath)
+		if err != nil && (loginPath != "" || !os.IsNotExist(err)) {
+			return fmt.Errorf("读取login-path失败: %w", err)
+		}
+		if err == nil {
+			sources = append(sources, options)
+		}
+	}
+	if defaultsFile != "" {
+		options, err := config.ReadOptionFile(defaultsFile, config.OptionGroups...)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, options)
+	} else {
+		files := config.DefaultOptionFiles()
+		// 后面的文件优先
+		for i := len(files) - 1; i >= 0; i-- {
+			options, err := config.ReadOptionFile(files[i], config.OptionGroups...)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+			sources = append(sources, options)
+		}
+	}
+	if pwd, ok := os.LookupEnv("MYSQL_PWD"); ok {
+		sources = append(sources, map[string]string{"password": pwd})
+	}
+
+	for _, options := range sources {
+		for option, name := range mysqlOptions {
+			value, ok := options[option]
+			if !ok || flags.Lookup(name) == nil || flags.Changed(name) {
+				continue
+			}
+			if err := flags.Set(name, value); err != nil {
+				return fmt.Errorf("选项文件中的%s错误: %w", option, err)
+			}
+		}
+	}
+
+	// 通过socket连接时不需要host
+	if flagValue(cmd, "socket") != "" && !flags.Changed("host") {
+		if err := flags.Set("host", flags.Lookup("host").DefValue); err != nil {
+			return err
+		}
+	}
+
+	if flagValue(cmd, "password") == promptPassword {
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if err := flags.Set("password", password); err != nil {
+			return err
+		}
+	}
+	if cmd.Annotations[passwordRequired] != "" && !flags.Changed("password") {
+		return errors.New("没有指定密码，可以通过-p、MYSQL_PWD、RA_PASSWORD、配置文件、--defaults-file或--login-path指定")
+	}
+	return nil
+}
+
+// flagValue 命令的参数值，命令没有该参数时为空
+func flagValue(cmd *cobra.Command, name string) string {
+	f := cmd.Flags().Lookup(name)
+	if f == nil {
+		return ""
+	}
+	return f.Value.String()
+}
+
+// readPassword 从终端读取密码，不回显
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("标准输入不是终端，无法输入密码，可以通过MYSQL_PWD、RA_PASSWORD、--defaults-file或--login-path指定密码")
+	}
+	_, _ = fmt.Fprint(os.Stderr, "Enter password: ")
+	password, err := term.ReadPassword(fd)
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("读取密码失败: %w", err)
+	}
+	return string(password), nil
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePasswordArgs(t *testing.T) {
+	prompt := "--password=" + promptPassword
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "-p后面有值", args: []string{"-u", "root", "-p123456"}, want: []string{"-u", "root", "-p123456"}},
+		{name: "--password=", args: []string{"--password=123456"}, want: []string{"--password=123456"}},
+		{name: "-p交互输入", args: []string{"-u", "root", "-p"}, want: []string{"-u", "root", prompt}},
+		{name: "--password交互输入", args: []string{"--password", "--host", "db"}, want: []string{prompt, "--host", "db"}},
+		{name: "-p后面的参数不作为密码", args: []string{"-p", "123456"}, want: []string{prompt, "123456"}},
+		{name: "--之后不处理", args: []string{"-p", "--", "-p"}, want: []string{prompt, "--", "-p"}},
+		{name: "没有参数", args: []string{}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizePasswordArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizePasswordArgs(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoArgs(t *testing.T) {
+	for _, name := range []string{"tosql", "flashback", "events", "stats", "history", "pitr"} {
+		t.Run(name, func(t *testing.T) {
+			cmd, _, err := rootCmd.Find([]string{name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cmd.ValidateArgs(nil); err != nil {
+				t.Errorf("没有位置参数时ValidateArgs() = %v", err)
+			}
+			// -p 123456中的123456
+			if err := cmd.ValidateArgs([]string{"123456"}); err == nil || !strings.Contains(err.Error(), "-p123456") {
+				t.Errorf("有位置参数时ValidateArgs() = %v, 应提示密码的写法", err)
+			}
+		})
+	}
+}
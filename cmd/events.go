@@ -0,0 +1,57 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"github.com/dhbin/ra/binlog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	eventsOptions = &binlogFlags{}
+	eventsFormat  string
+)
+
+// eventsCmd represents the events command
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Args:  noArgs,
+	Short: "列出binlog事件",
+	Long: `列出binlog事件的类型、位置、时间、server id、gtid以及表和行数，用于确定start-position、stop-position
+解析本地binlog时不需要连接数据库`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		binlogConfig, err := buildBinlogConfig(eventsOptions)
+		if err != nil {
+			return err
+		}
+		if !binlogConfig.Local && binlogConfig.Username == "" {
+			return errors.New("解析远程binlog需要指定username")
+		}
+		binlogConfig.Format = eventsFormat
+		ctx, stop := signalContext()
+		defer stop()
+		return binlog.Events(ctx, &binlogConfig)
+	},
+}
+
+func init() {
+	parseBinlogSourceFlags(eventsCmd, eventsOptions)
+	eventsCmd.PersistentFlags().StringVar(&eventsFormat, "format", binlog.FormatTable, "输出格式。支持table,json。json每个事件输出一个json对象")
+
+	rootCmd.AddCommand(eventsCmd)
+}
@@ -18,26 +18,35 @@ package cmd
 
 import (
 	"github.com/dhbin/ra/binlog"
-	"github.com/siddontang/go-log/log"
 
 	"github.com/spf13/cobra"
 )
 
+// flashbackOptions flashback的参数
+var flashbackOptions = &binlogFlags{}
+
 // flashbackCmd represents the flashback command
 var flashbackCmd = &cobra.Command{
 	Use:   "flashback",
+	Args:  noArgs,
 	Short: "数据闪回",
 	Long:  `通过binlog日志生成恢复数据的sql`,
-	Run: func(cmd *cobra.Command, args []string) {
-		binlogConfig := buildBinlogConfig()
-		err := binlog.Flashback(&binlogConfig)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		binlogConfig, err := buildBinlogConfig(flashbackOptions)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
+		ctx, stop := signalContext()
+		defer stop()
+		return binlog.Flashback(ctx, &binlogConfig)
 	},
 }
 
 func init() {
-	parseBinlogCommonFlags(flashbackCmd)
+	parseBinlogCommonFlags(flashbackCmd, flashbackOptions)
+	flashbackCmd.PersistentFlags().BoolVar(&flashbackOptions.Verify, "verify", false, "按主键查询当前数据，校验与binlog中的after image是否一致，被再次修改的行输出到stderr。需要缓存解析范围内的所有变更")
+	flashbackCmd.PersistentFlags().BoolVar(&flashbackOptions.SkipDrifted, "skip-drifted", false, "配合verify使用，生成的sql中去掉已被再次修改的行")
+	flashbackCmd.PersistentFlags().BoolVar(&flashbackOptions.DDL, "ddl", false, "输出反向ddl，如ADD COLUMN输出DROP COLUMN，MODIFY COLUMN恢复之前的字段定义。无法闪回的ddl输出注释说明原因")
+	flashbackCmd.PersistentFlags().BoolVar(&flashbackOptions.RecoverDropped, "recover-dropped", false, "遇到DROP TABLE、TRUNCATE时，用解析范围内每一行最后的数据生成insert，解析范围内没有变更过的行无法恢复。需要缓存解析范围内所有行的数据")
 	rootCmd.AddCommand(flashbackCmd)
 }
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/dhbin/ra/binlog"
+	"github.com/spf13/cobra"
+	"strings"
+	"time"
+)
+
+var (
+	historyOptions = &binlogFlags{}
+	historyTable   string
+	historyPK      []string
+	historyAt      string
+	historyFormat  string
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Args:  noArgs,
+	Short: "单行数据的变更历史",
+	Long: `按时间顺序输出单行数据在解析范围内的变更，包括变更前后的数据、update修改的字段、位置、时间和gtid
+指定at时输出该时间的行数据`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		binlogConfig, err := buildBinlogConfig(historyOptions)
+		if err != nil {
+			return err
+		}
+		names := strings.SplitN(historyTable, ".", 2)
+		if len(names) != 2 || names[0] == "" || names[1] == "" {
+			return fmt.Errorf("table格式错误: %s，格式为db.table", historyTable)
+		}
+		options := &binlog.HistoryOptions{
+			Schema: names[0],
+			Table:  names[1],
+			PK:     historyPK,
+			Format: historyFormat,
+		}
+		if historyAt != "" {
+			at, err := time.ParseInLocation("2006-01-02 15:04:05", historyAt, time.Local)
+			if err != nil {
+				return fmt.Errorf("at格式错误: %w", err)
+			}
+			options.At = &at
+		}
+		ctx, stop := signalContext()
+		defer stop()
+		return binlog.History(ctx, &binlogConfig, options)
+	},
+}
+
+func init() {
+	parseBinlogSourceFlags(historyCmd, historyOptions)
+	parseSchemaFileFlag(historyCmd, historyOptions)
+	_ = historyCmd.MarkPersistentFlagRequired("host")
+	_ = historyCmd.MarkPersistentFlagRequired("username")
+	requirePassword(historyCmd)
+	historyCmd.PersistentFlags().StringVar(&historyTable, "table", "", "目标表，格式为db.table。必须")
+	historyCmd.PersistentFlags().StringSliceVar(&historyPK, "pk", []string{}, "主键值，联合主键按主键字段顺序用逗号隔开。必须")
+	historyCmd.PersistentFlags().StringVar(&historyAt, "at", "", "输出该时间的行数据。可选。格式'%Y-%m-%d %H:%M:%S'")
+	historyCmd.PersistentFlags().StringVar(&historyFormat, "format", binlog.FormatText, "输出格式。支持text,json。json每次变更输出一个json对象")
+	_ = historyCmd.MarkPersistentFlagRequired("table")
+	_ = historyCmd.MarkPersistentFlagRequired("pk")
+
+	rootCmd.AddCommand(historyCmd)
+}
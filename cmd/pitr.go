@@ -0,0 +1,81 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dhbin/ra/binlog"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+var (
+	pitrOptions = &binlogFlags{}
+	pitrDumps   []string
+	pitrCsv     map[string]string
+	pitrAt      string
+	pitrAtGTID  string
+)
+
+// pitrCmd represents the pitr command
+var pitrCmd = &cobra.Command{
+	Use:   "pitr",
+	Args:  noArgs,
+	Short: "恢复表在指定时间点的数据",
+	Long: `读取表的逻辑快照（mysqldump导出的文件或csv），在内存中重放快照之后的binlog，输出表在目标时间或gtid时的数据
+解析范围应该从快照对应的位置开始，只恢复database、table选中的表，表需要有主键，
+表结构优先使用快照中的CREATE TABLE，其次是schema-file和数据库。输出的TIMESTAMP统一为UTC时间，开头设置time_zone为+00:00`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		binlogConfig, err := buildBinlogConfig(pitrOptions)
+		if err != nil {
+			return err
+		}
+		if len(pitrDumps) == 0 && len(pitrCsv) == 0 {
+			return errors.New("需要指定dump或csv快照")
+		}
+		options := &binlog.PitrOptions{
+			Dumps: pitrDumps,
+			Csv:   pitrCsv,
+			GTID:  pitrAtGTID,
+		}
+		if pitrAt != "" {
+			at, err := time.ParseInLocation("2006-01-02 15:04:05", pitrAt, time.Local)
+			if err != nil {
+				return fmt.Errorf("at格式错误: %w", err)
+			}
+			options.At = &at
+		}
+		ctx, stop := signalContext()
+		defer stop()
+		return binlog.Pitr(ctx, &binlogConfig, options)
+	},
+}
+
+func init() {
+	parseBinlogSourceFlags(pitrCmd, pitrOptions)
+	parseSchemaFileFlag(pitrCmd, pitrOptions)
+	_ = pitrCmd.MarkPersistentFlagRequired("host")
+	_ = pitrCmd.MarkPersistentFlagRequired("username")
+	requirePassword(pitrCmd)
+	pitrCmd.PersistentFlags().StringSliceVar(&pitrDumps, "dump", []string{}, "mysqldump导出的快照文件，多个文件用逗号隔开。没有USE语句时库名为database参数")
+	pitrCmd.PersistentFlags().StringToStringVar(&pitrCsv, "csv", map[string]string{}, "csv导出的快照，格式为db.table=文件，第一行为字段名，\\N为NULL。多个表用逗号隔开")
+	pitrCmd.PersistentFlags().StringVar(&pitrAt, "at", "", "恢复到该时间，包括该时间提交的事务。格式'%Y-%m-%d %H:%M:%S'")
+	pitrCmd.PersistentFlags().StringVar(&pitrAtGTID, "at-gtid", "", "恢复到该gtid的事务提交之后")
+
+	rootCmd.AddCommand(pitrCmd)
+}
@@ -17,37 +17,33 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/dhbin/ra/binlog"
+	"github.com/dhbin/ra/binlog/sink"
 	"github.com/dhbin/ra/config"
-	"github.com/siddontang/go-log/log"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 )
 
-var (
-	host     string
-	port     int
-	username string
-	password string
-
-	startBinlogName string
-	stopBinlogName  string
-	startPosition   uint32
-	stopPosition    uint32
-	startDatetime   string
-	stopDatetime    string
-
-	database string
-	tables   []string
-	sqlTypes []string
-	ddl      bool
-
-	out   string
-	local bool
-)
+// binlogFlags binlog相关命令的参数，直接绑定到BinlogConfig的字段
+type binlogFlags struct {
+	config.BinlogConfig
+	// startDatetime、stopDatetime 解析后设置到BinlogConfig
+	startDatetime string
+	stopDatetime  string
+	// defaultsFile、loginPath 读取连接参数的mysql选项文件
+	defaultsFile string
+	loginPath    string
+	// quiet 不输出解析进度
+	quiet bool
+}
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -63,97 +59,168 @@ var rootCmd = &cobra.Command{
 8.0.x
 
 binlog转sql例子：
-ra tosql --host 127.0.0.1 -u root -p 123456 --start-file mysql-bin.000001
+ra tosql --host 127.0.0.1 -u root -p123456 --start-file mysql-bin.000001
 
 binlog生成恢复sql例子：
-ra flashback --host 127.0.0.1 -u root -p 123456 --start-file mysql-bin.000001
+ra flashback --host 127.0.0.1 -u root -p123456 --start-file mysql-bin.000001
 
 解析本地binlog例子：
-ra tosql --host 127.0.0.1 -u root -p 123456 --start-file ./mysql-bin.000001 --local
+ra tosql --host 127.0.0.1 -u root -p123456 --start-file ./mysql-bin.000001 --local
 
 注：解析本地binlog也需要提供数据库信息，用于获取表信息
+
+-p后面没有值时交互输入密码，命令行中的密码需要写成-p123456或--password=123456，也可以通过MYSQL_PWD、~/.my.cnf、--defaults-file、--login-path提供密码，
+避免密码出现在命令行中：
+ra tosql --login-path prod --start-file mysql-bin.000001
+
+参数也可以通过环境变量（RA_加上大写的参数名，-替换为_，如RA_HOST、RA_START_FILE）
+或配置文件~/.ra.yaml中的profile指定：
+ra tosql --profile prod --start-file mysql-bin.000001
 `,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
+// 出错时以非0状态码退出
 func Execute() {
+	rootCmd.SetArgs(normalizePasswordArgs(os.Args[1:]))
 	err := rootCmd.Execute()
-	if err != nil {
-		os.Exit(0)
+	switch {
+	case err == nil:
+	case errors.Is(err, binlog.ErrInterrupted):
+		// 已输出继续解析的位置
+		os.Exit(130)
+	case errors.Is(err, context.Canceled):
+		_, _ = fmt.Fprintln(os.Stderr, "已中断")
+		os.Exit(130)
+	default:
+		_, _ = fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
 }
 
+// signalContext 收到SIGINT、SIGTERM时取消，再次收到时直接退出
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
 func init() {
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.SilenceUsage = true
+	// 错误在Execute中输出，被中断时不输出错误
+	rootCmd.SilenceErrors = true
 	rootCmd.Version = fmt.Sprintf("%s %s %s %s %s", config.Version, runtime.GOOS, runtime.GOARCH, runtime.Version(), config.BuildTime)
+	rootCmd.PersistentFlags().String("config", "", "配置文件，默认为~/.ra.yaml。命令行参数优先于环境变量，环境变量优先于配置文件")
+	rootCmd.PersistentFlags().String("profile", "", "使用配置文件中的profile，默认为配置文件中的profile")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+		return loadCredentials(cmd)
+	}
 }
 
-func parseBinlogCommonFlags(cmd *cobra.Command) {
-	cmd.PersistentFlags().StringVar(&host, "host", "127.0.0.1", "数据库host")
-	cmd.PersistentFlags().IntVarP(&port, "port", "P", 3306, "数据库端口")
-	cmd.PersistentFlags().StringVarP(&username, "username", "u", "", "数据库用户名")
-	cmd.PersistentFlags().StringVarP(&password, "password", "p", "", "数据库密码")
+// parseBinlogCommonFlags tosql、flashback的参数，绑定到options
+func parseBinlogCommonFlags(cmd *cobra.Command, options *binlogFlags) {
+	parseBinlogSourceFlags(cmd, options)
 	_ = cmd.MarkPersistentFlagRequired("host")
 	_ = cmd.MarkPersistentFlagRequired("username")
-	_ = cmd.MarkPersistentFlagRequired("password")
-
-	cmd.PersistentFlags().StringVar(&startBinlogName, "start-file", "", "起始解析文件。必须。只需文件名，无需全路径，local模式时，该参数为文件路径")
-	cmd.PersistentFlags().StringVar(&stopBinlogName, "stop-file", "", "终止解析文件。可选。默认为start-file同一个文件")
-	cmd.PersistentFlags().Uint32Var(&startPosition, "start-position", 4, "起始解析位置。可选。默认为start-file的起始位置")
-	cmd.PersistentFlags().Uint32Var(&stopPosition, "stop-position", 0, "终止解析位置。可选。默认为stop-file的最末位置")
-	cmd.PersistentFlags().StringVar(&startDatetime, "start-datetime", "", "起始解析时间'。可选。格式'%Y-%m-%d %H:%M:%S。默认不过滤")
-	cmd.PersistentFlags().StringVar(&stopDatetime, "stop-datetime", "", "终止解析时间。可选。格式'%Y-%m-%d %H:%M:%S'。默认不过滤")
+	requirePassword(cmd)
+
+	cmd.PersistentFlags().StringVar(&options.Format, "format", sink.Sql, "输出格式。支持sql,jsonl,debezium,csv,tsv,parquet。jsonl每行变更输出一个json对象，debezium输出Debezium MySQL connector格式的变更事件，csv、tsv、parquet按表输出到out-dir目录")
+	cmd.PersistentFlags().StringVar(&options.OutDir, "out-dir", "", "输出目录，csv、tsv、parquet格式时每张表输出一个文件")
+	cmd.PersistentFlags().StringVar(&options.OnError, "on-error", sink.OnErrorFail, "无法生成sql时的处理方式。fail停止并返回错误，skip跳过该行，comment输出注释说明原因。跳过的行数汇总输出到stderr")
+	cmd.PersistentFlags().Int64Var(&options.RowGroupSize, "row-group-size", 128, "parquet格式的row group大小，单位MB")
+
+	cmd.PersistentFlags().StringVar(&options.ApplyTo, "apply-to", "", "直接在目标库按原始事务执行生成的sql，格式为user:password@tcp(host:port)/。指定后format参数无效，不能与mask、ddl同时使用")
+	cmd.PersistentFlags().BoolVar(&options.DryRun, "dry-run", false, "配合apply-to使用，只输出将要执行的sql和事务边界，不连接目标库")
+	cmd.PersistentFlags().IntVar(&options.ApplyBatch, "apply-batch", 1, "配合apply-to使用，每多少个原始事务提交一次")
+	cmd.PersistentFlags().StringVar(&options.ApplyCheck, "apply-check", sink.ApplyCheckAbort, "配合apply-to使用，影响行数不为1时的处理方式。abort回滚当前批次并停止，report输出到stderr并继续")
+	cmd.PersistentFlags().StringVar(&options.ProgressFile, "progress-file", "", "配合apply-to使用，记录已提交的位置，重新执行时跳过已执行的事务")
+
+	cmd.PersistentFlags().StringArrayVar(&options.MaskRules, "mask", []string{}, "敏感字段脱敏规则，格式为db.table.column:方式，方式支持redact(置空)、hash(加盐哈希)、prefix:n(保留前n个字符)、replace:值(固定值替换，可以包含逗号)。多个规则重复指定--mask。脱敏字段不参与where条件")
+	cmd.PersistentFlags().StringVar(&options.MaskSalt, "mask-salt", "", "hash脱敏使用的盐")
+
+	parseSchemaFileFlag(cmd, options)
+
+}
+
+// parseBinlogSourceFlags 数据库连接、解析范围、过滤条件以及输出文件，绑定到options
+func parseBinlogSourceFlags(cmd *cobra.Command, options *binlogFlags) {
+	cmd.PersistentFlags().StringVar(&options.Host, "host", "127.0.0.1", "数据库host")
+	cmd.PersistentFlags().IntVarP(&options.Port, "port", "P", 3306, "数据库端口")
+	cmd.PersistentFlags().StringVarP(&options.Username, "username", "u", "", "数据库用户名")
+	cmd.PersistentFlags().StringVarP(&options.Password, "password", "p", "", "数据库密码，格式为-p123456或--password=123456。-p后面没有值时交互输入，也可以通过环境变量MYSQL_PWD指定")
+	cmd.PersistentFlags().StringVarP(&options.Socket, "socket", "S", "", "通过unix socket连接数据库，指定时忽略host、port")
+	cmd.PersistentFlags().StringVar(&options.SSLMode, "ssl-mode", "", "连接数据库的tls模式。支持disabled,required,verify_ca,verify_identity。默认指定ssl-ca时为verify_ca，否则为disabled")
+	cmd.PersistentFlags().StringVar(&options.SSLCA, "ssl-ca", "", "校验服务端证书的CA证书文件")
+	cmd.PersistentFlags().StringVar(&options.SSLCert, "ssl-cert", "", "客户端证书文件")
+	cmd.PersistentFlags().StringVar(&options.SSLKey, "ssl-key", "", "客户端私钥文件")
+	cmd.PersistentFlags().StringVar(&options.defaultsFile, "defaults-file", "", "mysql选项文件，读取[client]、[ra]中的user、password、host、port、socket以及ssl参数。默认依次读取/etc/my.cnf、/etc/mysql/my.cnf、~/.my.cnf")
+	cmd.PersistentFlags().StringVar(&options.loginPath, "login-path", "", "读取mysql_config_editor保存在~/.mylogin.cnf中的登录信息")
+	cmd.PersistentFlags().StringVar(&options.Flavor, "flavor", "mysql", "数据库类型。支持mysql,mariadb")
+	cmd.PersistentFlags().Uint32Var(&options.ServerID, "server-id", 0, "读取远程binlog时作为从库使用的server id，不能与其它从库重复。默认在1001-2000中随机生成")
+
+	cmd.PersistentFlags().StringVar(&options.StartBinlogName, "start-file", "", "起始解析文件。必须。只需文件名，无需全路径，local模式时，该参数为文件路径")
+	cmd.PersistentFlags().StringVar(&options.StopBinlogName, "stop-file", "", "终止解析文件。可选。默认为start-file同一个文件。local模式时只需文件名，解析start-file所在目录中从start-file到该文件的所有binlog文件")
+	cmd.PersistentFlags().Uint32Var(&options.StartPosition, "start-position", 4, "起始解析位置。可选。默认为start-file的起始位置")
+	cmd.PersistentFlags().Uint32Var(&options.StopPosition, "stop-position", 0, "终止解析位置。可选。默认为stop-file的最末位置")
+	cmd.PersistentFlags().StringVar(&options.startDatetime, "start-datetime", "", "起始解析时间'。可选。格式'%Y-%m-%d %H:%M:%S。默认不过滤")
+	cmd.PersistentFlags().StringVar(&options.stopDatetime, "stop-datetime", "", "终止解析时间。可选。格式'%Y-%m-%d %H:%M:%S'。默认不过滤")
 	_ = cmd.MarkPersistentFlagRequired("start-file")
 
-	cmd.PersistentFlags().StringVarP(&database, "database", "d", "", "只解析目标db的sql，多个库用空格隔开，如-d db1 db2。可选。默认支持所有数据库")
-	cmd.PersistentFlags().StringSliceVarP(&tables, "tables", "t", []string{}, "只解析目标table的sql，多张表用空格隔开，如-t tbl1 tbl2。可选。默认支持所有表，当database配置为空时，支持跨库重名的表")
-	cmd.PersistentFlags().StringSliceVar(&sqlTypes, "only-type", []string{"insert", "update", "delete"}, "只解析指定类型。支持insert,update,delete。多个类型用逗号隔开，如--sql-type insert,delete。可选。默认为增删改都解析")
+	cmd.PersistentFlags().StringVarP(&options.Database, "database", "d", "", "只解析目标db的sql，多个库用空格隔开，如-d db1 db2。可选。默认支持所有数据库")
+	cmd.PersistentFlags().StringSliceVarP(&options.Tables, "tables", "t", []string{}, "只解析目标table的sql，多张表用空格隔开，如-t tbl1 tbl2。可选。默认支持所有表，当database配置为空时，支持跨库重名的表")
+	cmd.PersistentFlags().StringSliceVar(&options.SqlTypes, "only-type", []string{"insert", "update", "delete"}, "只解析指定类型。支持insert,update,delete。多个类型用逗号隔开，如--sql-type insert,delete。可选。默认为增删改都解析")
 
-	cmd.PersistentFlags().StringVarP(&out, "out", "o", "", "输出sql文件，默认stdout")
-	cmd.PersistentFlags().BoolVar(&local, "local", false, "解析本地binlog文件")
+	cmd.PersistentFlags().StringVarP(&options.Out, "out", "o", "", "输出sql文件，默认stdout")
+	cmd.PersistentFlags().BoolVar(&options.Local, "local", false, "解析本地binlog文件")
+	cmd.PersistentFlags().IntVar(&options.Parallel, "parallel", 4, "local模式解析多个binlog文件时同时解码的文件数，结果仍按binlog顺序输出")
+	cmd.PersistentFlags().BoolVarP(&options.quiet, "quiet", "q", false, "不在stderr输出解析进度")
+	cmd.PersistentFlags().StringVar(&options.ProgressFormat, "progress-format", binlog.ProgressText, "解析进度的输出格式。支持text,json。text在终端中同一行刷新，json每次输出一行json，便于其它程序读取")
+	cmd.PersistentFlags().DurationVar(&options.ProgressInterval, "progress-interval", 0, "输出解析进度的间隔，如5s。默认终端中的text格式和json格式为1s，其它为10s")
+}
 
+// parseSchemaFileFlag 需要表结构的命令
+func parseSchemaFileFlag(cmd *cobra.Command, options *binlogFlags) {
+	cmd.PersistentFlags().StringVar(&options.SchemaFile, "schema-file", "", "表结构文件，如mysqldump --no-data的输出，为解析范围开始时的表结构。"+
+		"表结构依次从binlog元数据（binlog_row_metadata=FULL）、该文件、数据库获取，解析时跟踪ddl的修改。flashback配合ddl使用时也用于获取解析范围之前的字段、索引定义")
 }
 
-func buildBinlogConfig() config.BinlogConfig {
-	binlogConfig := config.BinlogConfig{
-		Host:     host,
-		Port:     port,
-		Username: username,
-		Password: password,
-
-		StartBinlogName: startBinlogName,
-		StopBinlogName:  stopBinlogName,
-		StartPosition:   startPosition,
-		StopPosition:    stopPosition,
-
-		Database: database,
-		Tables:   tables,
-		SqlTypes: sqlTypes,
-		DDL:      ddl,
-
-		Out:   out,
-		Local: local,
-	}
+// buildBinlogConfig 根据命令的参数生成BinlogConfig
+func buildBinlogConfig(options *binlogFlags) (config.BinlogConfig, error) {
+	binlogConfig := options.BinlogConfig
 
 	if binlogConfig.StopBinlogName == "" {
 		binlogConfig.StopBinlogName = binlogConfig.StartBinlogName
 	}
 
-	if startDatetime != "" {
-		startDateTimeTmp, err := time.ParseInLocation("2006-01-02 15:04:05", startDatetime, time.Local)
+	switch {
+	case options.quiet:
+		binlogConfig.ProgressFormat = ""
+	case binlogConfig.ProgressFormat != binlog.ProgressText && binlogConfig.ProgressFormat != binlog.ProgressJSON:
+		return binlogConfig, fmt.Errorf("progress-format格式错误: %s，支持text、json", binlogConfig.ProgressFormat)
+	}
+
+	if options.startDatetime != "" {
+		startDateTimeTmp, err := time.ParseInLocation("2006-01-02 15:04:05", options.startDatetime, time.Local)
 		if err != nil {
-			log.Panic(err)
+			return binlogConfig, fmt.Errorf("start-datetime格式错误: %w", err)
 		}
 		binlogConfig.StartDatetime = &startDateTimeTmp
 	}
 
-	if stopDatetime != "" {
-		stopDatetimeTmp, err := time.ParseInLocation("2006-01-02 15:04:05", stopDatetime, time.Local)
+	if options.stopDatetime != "" {
+		stopDatetimeTmp, err := time.ParseInLocation("2006-01-02 15:04:05", options.stopDatetime, time.Local)
 		if err != nil {
-			log.Panic(err)
+			return binlogConfig, fmt.Errorf("stop-datetime格式错误: %w", err)
 		}
 		binlogConfig.StopDatetime = &stopDatetimeTmp
 	}
 
-	return binlogConfig
+	return binlogConfig, nil
 }
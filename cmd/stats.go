@@ -0,0 +1,59 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"github.com/dhbin/ra/binlog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsOptions = &binlogFlags{}
+	statsFormat  string
+	statsTop     int
+)
+
+// statsCmd represents the stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Args:  noArgs,
+	Short: "统计binlog",
+	Long: `统计解析范围内每张表的增删改行数和大小、行数和大小最大的事务、耗时最长的事务、每分钟事件数以及gtid来源
+解析本地binlog时不需要连接数据库`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		binlogConfig, err := buildBinlogConfig(statsOptions)
+		if err != nil {
+			return err
+		}
+		if !binlogConfig.Local && binlogConfig.Username == "" {
+			return errors.New("解析远程binlog需要指定username")
+		}
+		binlogConfig.Format = statsFormat
+		ctx, stop := signalContext()
+		defer stop()
+		return binlog.Statistics(ctx, &binlogConfig, statsTop)
+	},
+}
+
+func init() {
+	parseBinlogSourceFlags(statsCmd, statsOptions)
+	statsCmd.PersistentFlags().StringVar(&statsFormat, "format", binlog.FormatTable, "输出格式。支持table,json")
+	statsCmd.PersistentFlags().IntVar(&statsTop, "top", 10, "事务排行和gtid来源输出的数量")
+
+	rootCmd.AddCommand(statsCmd)
+}
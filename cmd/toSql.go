@@ -18,26 +18,35 @@ package cmd
 
 import (
 	"github.com/dhbin/ra/binlog"
-	"github.com/siddontang/go-log/log"
 	"github.com/spf13/cobra"
 )
 
+// toSqlOptions tosql的参数
+var toSqlOptions = &binlogFlags{}
+
 // toSqlCmd represents the toSql command
 var toSqlCmd = &cobra.Command{
 	Use:   "tosql",
+	Args:  noArgs,
 	Short: "通过binlog日志生成sql",
-	Run: func(cmd *cobra.Command, args []string) {
-		binlogConfig := buildBinlogConfig()
-		err := binlog.ToSql(&binlogConfig)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		binlogConfig, err := buildBinlogConfig(toSqlOptions)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
+		ctx, stop := signalContext()
+		defer stop()
+		return binlog.ToSql(ctx, &binlogConfig)
 	},
 }
 
 func init() {
-	parseBinlogCommonFlags(toSqlCmd)
-	toSqlCmd.PersistentFlags().BoolVar(&ddl, "ddl", false, "是否解析ddl语句")
+	parseBinlogCommonFlags(toSqlCmd, toSqlOptions)
+	toSqlCmd.PersistentFlags().BoolVar(&toSqlOptions.DDL, "ddl", false, "是否解析ddl语句。输出USE、会话变量，BEGIN、COMMIT等事务控制语句不输出")
+	toSqlCmd.PersistentFlags().BoolVar(&toSqlOptions.Follow, "follow", false, "持续解析数据库新写入的binlog，不支持local模式和终止位置、终止时间")
+	toSqlCmd.PersistentFlags().StringVar(&toSqlOptions.StateFile, "state-file", "", "配合follow使用，每个事务后记录位置（file:pos）和已处理的gtid集合，重启后从记录的gtid集合或位置继续，out追加写入")
+	toSqlCmd.PersistentFlags().Int64Var(&toSqlOptions.RotateSize, "rotate-size", 0, "配合follow、out使用，输出文件达到该大小后切换，单位MB。默认不切换")
+	toSqlCmd.PersistentFlags().DurationVar(&toSqlOptions.RotateInterval, "rotate-interval", 0, "配合follow、out使用，输出文件按时间间隔切换，如1h。默认不切换")
 
 	rootCmd.AddCommand(toSqlCmd)
 }
@@ -28,6 +28,17 @@ type BinlogConfig struct {
 	Port     int
 	Username string
 	Password string
+	// Socket 指定时通过unix socket连接，忽略Host、Port
+	Socket string
+	// SSLMode、SSLCA、SSLCert、SSLKey 连接数据库的tls参数，见TLSConfig
+	SSLMode string
+	SSLCA   string
+	SSLCert string
+	SSLKey  string
+	// Flavor 数据库类型，支持mysql、mariadb，为空时为mysql
+	Flavor string
+	// ServerID 作为从库读取binlog时使用的server id，为0时在1001-2000中随机生成
+	ServerID uint32
 
 	StartBinlogName string
 	StopBinlogName  string
@@ -35,14 +46,46 @@ type BinlogConfig struct {
 	StopPosition    uint32
 	StartDatetime   *time.Time
 	StopDatetime    *time.Time
+	// StartGTIDSet 不为空时远程解析从该gtid集合之后开始，忽略StartBinlogName、StartPosition
+	StartGTIDSet string
 
 	Database string
 	Tables   []string
 	SqlTypes []string
 	DDL      bool
+	// SchemaFile 闪回ddl时使用的表结构文件
+	SchemaFile string
 
-	Out   string
-	Local bool
+	Out          string
+	OutDir       string
+	Format       string
+	RowGroupSize int64
+	OnError      string
+	Local        bool
+	// Parallel 本地解析多个binlog文件时同时解码的文件数
+	Parallel int
+	// ProgressFormat 解析进度在stderr的输出格式，支持text、json，为空时不输出
+	ProgressFormat string
+	// ProgressInterval 输出进度的间隔，为0时终端中text格式、json格式为1s，其它为10s
+	ProgressInterval time.Duration
+
+	MaskRules []string
+	MaskSalt  string
+
+	ApplyTo      string
+	DryRun       bool
+	ApplyBatch   int
+	ApplyCheck   string
+	ProgressFile string
+
+	Verify         bool
+	SkipDrifted    bool
+	RecoverDropped bool
+
+	Follow         bool
+	StateFile      string
+	RotateSize     int64
+	RotateInterval time.Duration
 
 	supportSqlTypeMap map[string]bool
 }
@@ -59,11 +102,22 @@ func (h *BinlogConfig) SupportSqlType(sqlType string) bool {
 	return h.supportSqlTypeMap[strings.ToLower(sqlType)]
 }
 
-func (h *BinlogConfig) GetOut() (io.Writer, error) {
+// GetOut 输出文件，out为空时为stdout，使用完需要Close，stdout不会被关闭
+func (h *BinlogConfig) GetOut() (io.WriteCloser, error) {
 	if h.Out == "" {
-		return os.Stdout, nil
+		return stdout{}, nil
 	} else {
 		file, err := os.Create(h.Out)
 		return file, err
 	}
 }
+
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (stdout) Close() error {
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ssl-mode，与mysql客户端的--ssl-mode一致。
+// 不支持PREFERRED：服务端不支持tls时无法回退到非加密连接
+const (
+	SSLDisabled       = "disabled"
+	SSLRequired       = "required"
+	SSLVerifyCA       = "verify_ca"
+	SSLVerifyIdentity = "verify_identity"
+)
+
+// Addr 数据库地址，指定socket时为unix socket路径，否则为host:port
+func (h *BinlogConfig) Addr() string {
+	if h.Socket != "" {
+		return h.Socket
+	}
+	return h.Host + ":" + strconv.Itoa(h.Port)
+}
+
+// Network 数据库地址的网络类型，tcp或unix
+func (h *BinlogConfig) Network() string {
+	if h.Socket != "" {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// TLSConfig 根据ssl参数创建tls配置，不使用tls时返回nil。
+// 没有指定ssl-mode时，指定了ssl-ca则为verify_ca，否则为disabled
+func (h *BinlogConfig) TLSConfig() (*tls.Config, error) {
+	mode := strings.ToLower(h.SSLMode)
+	if mode == "" {
+		mode = SSLDisabled
+		if h.SSLCA != "" {
+			mode = SSLVerifyCA
+		}
+	}
+	if mode == SSLDisabled {
+		if h.SSLCA != "" || h.SSLCert != "" || h.SSLKey != "" {
+			return nil, errors.New("ssl-mode为disabled时不能指定ssl-ca、ssl-cert、ssl-key")
+		}
+		return nil, nil
+	}
+	if mode != SSLRequired && mode != SSLVerifyCA && mode != SSLVerifyIdentity {
+		return nil, fmt.Errorf("不支持的ssl-mode: %s，支持disabled,required,verify_ca,verify_identity", h.SSLMode)
+	}
+
+	cfg := &tls.Config{}
+	if h.SSLCert != "" || h.SSLKey != "" {
+		if h.SSLCert == "" || h.SSLKey == "" {
+			return nil, errors.New("ssl-cert和ssl-key需要同时指定")
+		}
+		cert, err := tls.LoadX509KeyPair(h.SSLCert, h.SSLKey)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端证书失败: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if mode == SSLRequired {
+		// 只加密，不校验服务端证书
+		cfg.InsecureSkipVerify = true
+		return cfg, nil
+	}
+
+	if h.SSLCA == "" {
+		return nil, fmt.Errorf("ssl-mode为%s时需要指定ssl-ca", mode)
+	}
+	pem, err := os.ReadFile(h.SSLCA)
+	if err != nil {
+		return nil, fmt.Errorf("读取ssl-ca失败: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("ssl-ca %s中没有证书", h.SSLCA)
+	}
+	if mode == SSLVerifyIdentity {
+		cfg.RootCAs = roots
+		cfg.ServerName = h.Host
+		return cfg, nil
+	}
+	// verify_ca只校验证书链，不校验主机名
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+		if len(certs) == 0 {
+			return errors.New("服务端没有提供证书")
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+		return err
+	}
+	return cfg, nil
+}
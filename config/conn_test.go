@@ -0,0 +1,199 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert 生成证书，parent为nil时为自签名的CA
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write 证书和私钥写入dir，返回文件路径
+func (c *testCert) write(t *testing.T, dir string, name string) (string, string) {
+	t.Helper()
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "client", ca).write(t, dir, "client")
+	emptyFile := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(emptyFile, []byte("no cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config BinlogConfig
+		// want 期望的tls模式，为空时不使用tls
+		want    string
+		wantErr bool
+		// cert 是否加载了客户端证书
+		cert bool
+	}{
+		{name: "默认不使用tls", config: BinlogConfig{}},
+		{name: "指定ssl-ca时默认为verify_ca", config: BinlogConfig{SSLCA: caFile}, want: SSLVerifyCA},
+		{name: "disabled不能指定证书", config: BinlogConfig{SSLMode: "disabled", SSLCA: caFile}, wantErr: true},
+		{name: "不支持preferred", config: BinlogConfig{SSLMode: "preferred"}, wantErr: true},
+		{name: "required不区分大小写", config: BinlogConfig{SSLMode: "REQUIRED"}, want: SSLRequired},
+		{
+			name:   "客户端证书",
+			config: BinlogConfig{SSLMode: SSLRequired, SSLCert: certFile, SSLKey: keyFile},
+			want:   SSLRequired,
+			cert:   true,
+		},
+		{name: "ssl-cert缺少ssl-key", config: BinlogConfig{SSLMode: SSLRequired, SSLCert: certFile}, wantErr: true},
+		{name: "客户端证书不存在", config: BinlogConfig{SSLMode: SSLRequired, SSLCert: certFile, SSLKey: caFile + ".x"}, wantErr: true},
+		{name: "verify_ca缺少ssl-ca", config: BinlogConfig{SSLMode: SSLVerifyCA}, wantErr: true},
+		{name: "ssl-ca不存在", config: BinlogConfig{SSLMode: SSLVerifyCA, SSLCA: caFile + ".x"}, wantErr: true},
+		{name: "ssl-ca中没有证书", config: BinlogConfig{SSLMode: SSLVerifyCA, SSLCA: emptyFile}, wantErr: true},
+		{
+			name:   "verify_identity",
+			config: BinlogConfig{SSLMode: SSLVerifyIdentity, SSLCA: caFile, Host: "db"},
+			want:   SSLVerifyIdentity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tt.config.TLSConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.want == "" {
+				if cfg != nil {
+					t.Errorf("TLSConfig() = %+v, want nil", cfg)
+				}
+				return
+			}
+			if cfg == nil {
+				t.Fatal("TLSConfig() = nil")
+			}
+			if got := len(cfg.Certificates) != 0; got != tt.cert {
+				t.Errorf("Certificates = %d, want %v", len(cfg.Certificates), tt.cert)
+			}
+			switch tt.want {
+			case SSLRequired:
+				if !cfg.InsecureSkipVerify || cfg.VerifyPeerCertificate != nil {
+					t.Errorf("required应该只加密不校验证书")
+				}
+			case SSLVerifyCA:
+				if !cfg.InsecureSkipVerify || cfg.VerifyPeerCertificate == nil {
+					t.Errorf("verify_ca应该只校验证书链")
+				}
+			case SSLVerifyIdentity:
+				if cfg.InsecureSkipVerify || cfg.RootCAs == nil || cfg.ServerName != tt.config.Host {
+					t.Errorf("verify_identity应该校验证书链和主机名，ServerName = %q", cfg.ServerName)
+				}
+			}
+		})
+	}
+}
+
+// TestTLSConfigVerifyCA verify_ca校验证书链，不校验主机名
+func TestTLSConfigVerifyCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	cfg, err := (&BinlogConfig{SSLMode: SSLVerifyCA, SSLCA: caFile, Host: "db"}).TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestCert(t, "other", nil)
+	tests := []struct {
+		name    string
+		certs   [][]byte
+		wantErr bool
+	}{
+		{name: "ca签发的证书，主机名不一致", certs: [][]byte{newTestCert(t, "server", ca).der}},
+		{name: "其它ca签发的证书", certs: [][]byte{newTestCert(t, "db", other).der}, wantErr: true},
+		{name: "自签名证书", certs: [][]byte{other.der}, wantErr: true},
+		{name: "没有证书", wantErr: true},
+		{name: "证书格式错误", certs: [][]byte{[]byte("x")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cfg.VerifyPeerCertificate(tt.certs, nil); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyPeerCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
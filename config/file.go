@@ -0,0 +1,171 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EnvPrefix 环境变量前缀，参数名转为大写、-转为_，如RA_HOST、RA_START_FILE
+const EnvPrefix = "RA_"
+
+// File 配置文件，key为命令行参数名。
+//
+//	profile: prod
+//	defaults:
+//	  port: 3306
+//	profiles:
+//	  prod:
+//	    host: 10.0.0.1
+//	    username: ra
+//	    tosql:
+//	      format: jsonl
+//
+// 值为map且key为命令名时，只作用于该命令
+type File struct {
+	// Profile 没有指定profile时使用的profile
+	Profile string `yaml:"profile"`
+	// Defaults 所有profile共用的参数
+	Defaults map[string]interface{} `yaml:"defaults"`
+	// Profiles 命名的连接配置
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+}
+
+// DefaultFile 默认配置文件~/.ra.yaml，获取不到home目录时为空
+func DefaultFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ra.yaml")
+}
+
+// LoadFile 读取配置文件，文件不存在且missingOk为true时返回空配置
+func LoadFile(path string, missingOk bool) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if missingOk && os.IsNotExist(err) {
+			return &File{}, nil
+		}
+		return nil, err
+	}
+	f := &File{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(f); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("配置文件%s格式错误: %w", path, err)
+	}
+	return f, nil
+}
+
+// Values 合并defaults和profile中的参数，命令的参数覆盖通用参数，返回参数名到参数值的映射，
+// 列表为每一项，其它值只有一项。profile为空时使用配置文件中的profile，都为空时只使用defaults
+func (f *File) Values(profile string, command string) (map[string][]string, error) {
+	if profile == "" {
+		profile = f.Profile
+	}
+	sections := []map[string]interface{}{f.Defaults}
+	if profile != "" {
+		p, ok := f.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("配置文件中没有profile: %s，可用的profile: %s", profile, strings.Join(f.profileNames(), ","))
+		}
+		sections = append(sections, p)
+	}
+	values := make(map[string][]string)
+	for _, section := range sections {
+		if err := addValues(values, section, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, section := range sections {
+		sub, ok := section[command].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if err := addValues(values, sub, command+"."); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// Keys 配置文件中出现的所有参数名，命令中的参数带上命令名前缀，如tosql.format
+func (f *File) Keys() []string {
+	var keys []string
+	sections := []map[string]interface{}{f.Defaults}
+	for _, name := range f.profileNames() {
+		sections = append(sections, f.Profiles[name])
+	}
+	for _, section := range sections {
+		for key, value := range section {
+			if sub, ok := value.(map[string]interface{}); ok {
+				for subKey := range sub {
+					keys = append(keys, key+"."+subKey)
+				}
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (f *File) profileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addValues 将配置值转换为命令行参数的字符串形式
+func addValues(values map[string][]string, section map[string]interface{}, prefix string) error {
+	for key, value := range section {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if prefix != "" {
+				return fmt.Errorf("配置文件中%s%s嵌套层级过多", prefix, key)
+			}
+			// 命令的参数单独处理
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = items
+		case nil:
+			values[key] = []string{""}
+		default:
+			values[key] = []string{fmt.Sprint(v)}
+		}
+	}
+	return nil
+}
+
+// EnvName 参数对应的环境变量名
+func EnvName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeFile 在临时目录中写入配置文件
+func writeFile(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "ra.yaml")
+	if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testFile = `
+profile: prod
+defaults:
+  port: 3306
+  host: 127.0.0.1
+  format: sql
+  tosql:
+    format: jsonl
+    out-dir: /tmp/default
+profiles:
+  prod:
+    host: 10.0.0.1
+    username: ra
+    format: csv
+    tables: [user, order]
+    mask:
+      - test.user.phone:prefix:3
+      - test.user.name:replace:a,b
+    socket:
+    tosql:
+      out-dir: /tmp/prod
+  test:
+    host: 10.0.0.2
+    stats:
+      top: 5
+`
+
+func TestFileValues(t *testing.T) {
+	f, err := LoadFile(writeFile(t, testFile), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		profile string
+		command string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name:    "profile覆盖defaults，命令的参数覆盖通用参数",
+			command: "tosql",
+			want: map[string][]string{
+				"port": {"3306"}, "host": {"10.0.0.1"}, "username": {"ra"}, "format": {"jsonl"}, "out-dir": {"/tmp/prod"},
+				"tables": {"user", "order"}, "mask": {"test.user.phone:prefix:3", "test.user.name:replace:a,b"}, "socket": {""},
+			},
+		},
+		{
+			name:    "其它命令的参数不生效",
+			command: "stats",
+			want: map[string][]string{
+				"port": {"3306"}, "host": {"10.0.0.1"}, "username": {"ra"}, "format": {"csv"},
+				"tables": {"user", "order"}, "mask": {"test.user.phone:prefix:3", "test.user.name:replace:a,b"}, "socket": {""},
+			},
+		},
+		{
+			name:    "指定profile",
+			profile: "test",
+			command: "stats",
+			want:    map[string][]string{"port": {"3306"}, "host": {"10.0.0.2"}, "format": {"sql"}, "top": {"5"}},
+		},
+		{name: "profile不存在", profile: "dev", command: "tosql", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.Values(tt.profile, tt.command)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Values() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Values() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileValuesWithoutProfile(t *testing.T) {
+	f, err := LoadFile(writeFile(t, "defaults:\n  host: db\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.Values("", "tosql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]string{"host": {"db"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Values() = %v, want %v", got, want)
+	}
+}
+
+func TestFileValuesNested(t *testing.T) {
+	f, err := LoadFile(writeFile(t, "defaults:\n  tosql:\n    format:\n      a: b\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Values("", "tosql"); err == nil {
+		t.Error("嵌套层级过多时Values()应返回错误")
+	}
+}
+
+func TestFileKeys(t *testing.T) {
+	f, err := LoadFile(writeFile(t, testFile), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := f.Keys()
+	sort.Strings(got)
+	want := []string{"format", "format", "host", "host", "host", "mask", "port", "socket", "stats.top", "tables",
+		"tosql.format", "tosql.out-dir", "tosql.out-dir", "username"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	tests := []struct {
+		name      string
+		path      string
+		missingOk bool
+		wantErr   bool
+	}{
+		{name: "文件不存在", path: missing, missingOk: true},
+		{name: "指定的文件不存在", path: missing, wantErr: true},
+		{name: "空文件", path: writeFile(t, "")},
+		{name: "未知的字段", path: writeFile(t, "profil: prod\n"), wantErr: true},
+		{name: "格式错误", path: writeFile(t, "profiles: [a\n"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := LoadFile(tt.path, tt.missingOk)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && f == nil {
+				t.Error("LoadFile() = nil")
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("start-file"); got != "RA_START_FILE" {
+		t.Errorf("EnvName() = %s, want RA_START_FILE", got)
+	}
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OptionGroups 读取mysql选项文件中的[client]和[ra]
+var OptionGroups = []string{"client", "ra"}
+
+// DefaultOptionFiles mysql客户端默认读取的选项文件，按顺序读取，后面的覆盖前面的
+func DefaultOptionFiles() []string {
+	files := []string{"/etc/my.cnf", "/etc/mysql/my.cnf"}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".my.cnf"))
+	}
+	return files
+}
+
+// DefaultLoginPathFile mysql_config_editor生成的~/.mylogin.cnf，可以通过MYSQL_TEST_LOGIN_FILE指定
+func DefaultLoginPathFile() string {
+	if file := os.Getenv("MYSQL_TEST_LOGIN_FILE"); file != "" {
+		return file
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".mylogin.cnf")
+}
+
+// ReadOptionFile 读取mysql选项文件中指定group的选项，后面的group覆盖前面的。
+// 选项名中的_统一转为-，没有值的选项值为空
+func ReadOptionFile(file string, groups ...string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	options, err := parseOptions(f, groups)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return options, nil
+}
+
+// ReadLoginPath 读取mysql_config_editor加密的登录文件，返回[client]和loginPath中的选项
+func ReadLoginPath(file string, loginPath string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := decryptLoginFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	groups := []string{"client"}
+	if loginPath != "" && loginPath != "client" {
+		groups = append(groups, loginPath)
+	}
+	options, err := parseOptions(bytes.NewReader(plain), groups)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return options, nil
+}
+
+// parseOptions 解析ini格式的选项，不支持!include、!includedir
+func parseOptions(r io.Reader, groups []string) (map[string]string, error) {
+	rank := make(map[string]int, len(groups))
+	for i, group := range groups {
+		rank[group] = i + 1
+	}
+	type option struct {
+		value string
+		rank  int
+	}
+	found := make(map[string]option)
+	current := 0
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' || text[0] == ';' || text[0] == '!' {
+			continue
+		}
+		if text[0] == '[' {
+			end := strings.IndexByte(text, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("第%d行group格式错误: %s", line, text)
+			}
+			current = rank[strings.ToLower(strings.TrimSpace(text[1:end]))]
+			continue
+		}
+		if current == 0 {
+			continue
+		}
+		key, value, _ := strings.Cut(text, "=")
+		key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "_", "-")
+		value, err := optionValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("第%d行%s: %w", line, key, err)
+		}
+		if prev, ok := found[key]; !ok || prev.rank <= current {
+			found[key] = option{value: value, rank: current}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	options := make(map[string]string, len(found))
+	for key, o := range found {
+		options[key] = o.value
+	}
+	return options, nil
+}
+
+// optionValue 去掉引号并处理转义，没有引号时去掉行尾的#注释
+func optionValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	quote := value[0]
+	if quote != '\'' && quote != '"' {
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		return unescapeOption(value), nil
+	}
+	end := strings.LastIndexByte(value, quote)
+	if end == 0 {
+		return "", errors.New("引号没有结束")
+	}
+	return unescapeOption(value[1:end]), nil
+}
+
+func unescapeOption(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '\\' || i+1 == len(value) {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'b':
+			sb.WriteByte('\b')
+		case 's':
+			sb.WriteByte(' ')
+		default:
+			sb.WriteByte(value[i])
+		}
+	}
+	return sb.String()
+}
+
+// decryptLoginFile 解密.mylogin.cnf：4字节保留，20字节密钥，
+// 之后每一行为4字节长度加aes-128-ecb加密的内容，使用pkcs7填充
+func decryptLoginFile(data []byte) ([]byte, error) {
+	const headerLen = 4 + 20
+	if len(data) < headerLen {
+		return nil, errors.New("登录文件格式错误")
+	}
+	key := make([]byte, 16)
+	for i, b := range data[4:headerLen] {
+		key[i%16] ^= b
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	var plain bytes.Buffer
+	for pos := headerLen; pos < len(data); {
+		if pos+4 > len(data) {
+			return nil, errors.New("登录文件格式错误")
+		}
+		n := int(binary.LittleEndian.Uint32(data[pos:]))
+		pos += 4
+		if n%aes.BlockSize != 0 || pos+n > len(data) {
+			return nil, errors.New("登录文件格式错误")
+		}
+		line := make([]byte, n)
+		for i := 0; i < n; i += aes.BlockSize {
+			block.Decrypt(line[i:i+aes.BlockSize], data[pos+i:pos+i+aes.BlockSize])
+		}
+		pos += n
+		if n > 0 {
+			padding := int(line[n-1])
+			if padding == 0 || padding > aes.BlockSize || padding > n {
+				return nil, errors.New("登录文件解密失败")
+			}
+			line = line[:n-padding]
+		}
+		plain.Write(line)
+	}
+	return plain.Bytes(), nil
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2023 The Ra Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		groups  []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "只读取指定group",
+			text:   "[mysqld]\nport=3307\n[client]\nuser=root\nport = 3306\n",
+			groups: []string{"client"},
+			want:   map[string]string{"user": "root", "port": "3306"},
+		},
+		{
+			name:   "后面的group优先",
+			text:   "[ra]\nuser=ra\n[client]\nuser=root\nhost=db\n",
+			groups: []string{"client", "ra"},
+			want:   map[string]string{"user": "ra", "host": "db"},
+		},
+		{
+			name:   "同一个group后面的值优先",
+			text:   "[client]\nuser=a\nuser=b\n",
+			groups: []string{"client"},
+			want:   map[string]string{"user": "b"},
+		},
+		{
+			name:   "选项名不区分大小写，_转为-",
+			text:   "[CLIENT]\nSSL_CA=ca.pem\n",
+			groups: []string{"client"},
+			want:   map[string]string{"ssl-ca": "ca.pem"},
+		},
+		{
+			name:   "注释和没有值的选项",
+			text:   "# c\n; c\n!includedir /etc/mysql\n[client]\ncompress\npassword=a#b #注释\n",
+			groups: []string{"client"},
+			want:   map[string]string{"compress": "", "password": "a#b"},
+		},
+		{
+			name:   "引号和转义",
+			text:   "[client]\npassword=\"a b#c\"\nsocket='/tmp/x'\nuser=a\\sb\\\\c\n",
+			groups: []string{"client"},
+			want:   map[string]string{"password": "a b#c", "socket": "/tmp/x", "user": "a b\\c"},
+		},
+		{name: "group没有结束", text: "[client\nuser=root\n", groups: []string{"client"}, wantErr: true},
+		{name: "引号没有结束", text: "[client]\npassword=\"abc\n", groups: []string{"client"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOptions(strings.NewReader(tt.text), tt.groups)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// encryptLoginFile 按mysql_config_editor的格式加密，每一行单独加密
+func encryptLoginFile(key []byte, lines ...string) []byte {
+	data := append([]byte{0, 0, 0, 0}, key...)
+	aesKey := make([]byte, 16)
+	for i, b := range key {
+		aesKey[i%16] ^= b
+	}
+	block, _ := aes.NewCipher(aesKey)
+	for _, line := range lines {
+		padding := aes.BlockSize - len(line)%aes.BlockSize
+		plain := append([]byte(line), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(plain)))
+		for i := 0; i < len(plain); i += aes.BlockSize {
+			encrypted := make([]byte, aes.BlockSize)
+			block.Encrypt(encrypted, plain[i:i+aes.BlockSize])
+			data = append(data, encrypted...)
+		}
+	}
+	return data
+}
+
+func TestDecryptLoginFile(t *testing.T) {
+	key := []byte("0123456789abcdefghij")
+	valid := encryptLoginFile(key, "[client]\n", "user = \"root\"\n", "password = \"a very long password\"\n")
+	// 密钥全为0，解密后最后一个字节为0
+	badPadding := append(make([]byte, 24), 16, 0, 0, 0)
+	block, _ := aes.NewCipher(make([]byte, 16))
+	encrypted := make([]byte, aes.BlockSize)
+	block.Encrypt(encrypted, make([]byte, aes.BlockSize))
+	badPadding = append(badPadding, encrypted...)
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "解密", data: valid, want: "[client]\nuser = \"root\"\npassword = \"a very long password\"\n"},
+		{name: "没有内容", data: encryptLoginFile(key)},
+		{name: "缺少密钥", data: valid[:10], wantErr: true},
+		{name: "缺少长度", data: valid[:26], wantErr: true},
+		{name: "内容不完整", data: valid[:len(valid)-1], wantErr: true},
+		{name: "长度不是块大小的整数倍", data: append(append(append([]byte{}, valid[:24]...), 15, 0, 0, 0), make([]byte, 15)...), wantErr: true},
+		{name: "填充错误", data: badPadding, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decryptLoginFile(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decryptLoginFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decryptLoginFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}